@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// authRealm is the HTTP Basic authentication realm of the routes serving
+// keys and decoded media of encrypted streams.
+const authRealm = "multimedia-sys"
+
+// basicAuth checks the credentials clients present with HTTP Basic
+// authentication. An empty user accepts any user name; without a password
+// every request is refused.
+type basicAuth struct {
+	user     string
+	password string
+}
+
+// authorized reports whether r carries the configured credentials.
+func (a basicAuth) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || a.password == "" {
+		return false
+	}
+	userOK := a.user == "" || subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+	return userOK && passwordOK
+}
+
+// challenge responds that the request needs credentials.
+func (a basicAuth) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// require returns next guarded by the credentials.
+func (a basicAuth) require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			a.challenge(w)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuthRequire(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     basicAuth
+		user     string
+		password string
+		send     bool
+		want     int
+	}{
+		{"valid credentials", basicAuth{user: "crew", password: "secret"}, "crew", "secret", true, http.StatusOK},
+		{"any user", basicAuth{password: "secret"}, "someone", "secret", true, http.StatusOK},
+		{"wrong password", basicAuth{user: "crew", password: "secret"}, "crew", "guess", true, http.StatusUnauthorized},
+		{"wrong user", basicAuth{user: "crew", password: "secret"}, "guest", "secret", true, http.StatusUnauthorized},
+		{"no credentials", basicAuth{user: "crew", password: "secret"}, "", "", false, http.StatusUnauthorized},
+		{"no password configured", basicAuth{}, "", "", true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.auth.require(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/stream/snapshot", nil)
+			if tt.send {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("refused request carries no WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cdaprod/multimedia-sys/internal/facade"
+	"github.com/Cdaprod/multimedia-sys/internal/streaming"
+)
+
+// blockingRequestTimeout bounds how long an LL-HLS request may be held open.
+const blockingRequestTimeout = 10 * time.Second
+
+// hlsHandler serves the HLS directory, in which every stream has a
+// subdirectory. Requests for a stream's LL-HLS playlist with _HLS_msn/_HLS_part
+// are held until the playlist contains the requested part, and requests for
+// the hinted next part are held until FFmpeg writes it. Playlists requested
+// with dvr=1 are served from the DVR window, when the stream keeps one,
+// instead of the live window.
+type hlsHandler struct {
+	facade facade.Facade
+	dir    string
+	files  http.Handler
+}
+
+// newHLSHandler creates the handler for the /hls/ route.
+func newHLSHandler(f facade.Facade, dir string) http.Handler {
+	return &hlsHandler{
+		facade: f,
+		dir:    dir,
+		files:  http.StripPrefix("/hls/", http.FileServer(http.Dir(dir))),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *hlsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/hls/")
+	stream, rest, _ := strings.Cut(name, "/")
+	lowLatency := strings.HasPrefix(rest, streaming.LowLatencyDir+"/")
+
+	switch {
+	case strings.HasSuffix(name, ".m3u8"):
+		w.Header().Set("Cache-Control", "no-cache")
+		if lowLatency && r.URL.Query().Has("_HLS_msn") && !h.awaitPlaylist(w, r, stream) {
+			return
+		}
+		if dvr, _ := strconv.ParseBool(r.URL.Query().Get("dvr")); dvr && !lowLatency {
+			r = h.dvrRequest(r, name)
+		}
+	case lowLatency && strings.HasSuffix(name, ".m4s"):
+		if _, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(name))); os.IsNotExist(err) {
+			ctx, cancel := context.WithTimeout(r.Context(), blockingRequestTimeout)
+			defer cancel()
+			if err := h.facade.AwaitPart(ctx, stream, strings.TrimPrefix(rest, streaming.LowLatencyDir+"/")); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+	}
+
+	h.files.ServeHTTP(w, r)
+}
+
+// dvrRequest returns r rewritten to the DVR counterpart of the playlist at
+// name, or r itself when there is none.
+func (h *hlsHandler) dvrRequest(r *http.Request, name string) *http.Request {
+	var dvr string
+	switch path.Base(name) {
+	case streaming.MasterPlaylist:
+		dvr = path.Join(path.Dir(name), streaming.DVRMasterPlaylist)
+	case streaming.LivePlaylist:
+		dvr = path.Join(path.Dir(name), streaming.DVRPlaylist)
+	default:
+		return r
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(dvr))); err != nil {
+		return r
+	}
+	r = r.Clone(r.Context())
+	r.URL.Path = "/hls/" + dvr
+	r.URL.RawPath = ""
+	return r
+}
+
+// awaitPlaylist handles a blocking playlist reload of stream. It returns false when an
+// error response has already been written.
+func (h *hlsHandler) awaitPlaylist(w http.ResponseWriter, r *http.Request, stream string) bool {
+	query := r.URL.Query()
+	msn, err := strconv.Atoi(query.Get("_HLS_msn"))
+	if err != nil || msn < 0 {
+		http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+		return false
+	}
+	part := -1
+	if query.Has("_HLS_part") {
+		if part, err = strconv.Atoi(query.Get("_HLS_part")); err != nil || part < 0 {
+			http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), blockingRequestTimeout)
+	defer cancel()
+	switch err := h.facade.AwaitPlaylist(ctx, stream, msn, part); {
+	case err == nil, errors.Is(err, streaming.ErrLowLatencyInactive):
+		return true
+	case errors.Is(err, streaming.ErrPlaylistTooFarAhead):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "playlist update timed out", http.StatusServiceUnavailable)
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Cdaprod/multimedia-sys/internal/facade"
+)
+
+// keyHandler serves the AES-128 keys of encrypted HLS streams to clients
+// that present the configured credentials with HTTP Basic authentication.
+type keyHandler struct {
+	facade facade.Facade
+	auth   basicAuth
+}
+
+// newKeyHandler creates the handler for the /keys/ route.
+func newKeyHandler(f facade.Facade, auth basicAuth) http.Handler {
+	return &keyHandler{facade: f, auth: auth}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *keyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.auth.authorized(r) {
+		h.auth.challenge(w)
+		return
+	}
+
+	key, err := h.facade.Key(strings.TrimPrefix(r.URL.Path, "/keys/"))
+	if err != nil {
+		http.Error(w, err.Error(), streamErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(key)
+}
@@ -4,15 +4,17 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Cdaprod/multimedia-sys/internal/devices"
 	"github.com/Cdaprod/multimedia-sys/internal/facade"
 	"github.com/Cdaprod/multimedia-sys/internal/gpio"
 	"github.com/Cdaprod/multimedia-sys/internal/streaming"
@@ -31,6 +33,47 @@ const (
 	VideoStorageDir = "/mnt/nas/videos"
 	GPIOButtonPin   = 18 // BCM pin number
 	ServerPort      = ":8080"
+	ProfilesFile    = "/etc/multimedia-sys/profiles.json"
+	// DestinationsFile holds restream destinations and their stream keys; it
+	// is written with owner-only permissions.
+	DestinationsFile = "/var/lib/multimedia-sys/destinations.json"
+	// OverlaysFile holds the overlays set per profile through /stream/overlays.
+	OverlaysFile = "/var/lib/multimedia-sys/overlays.json"
+	// AssetsDir holds the images and fonts overlays may use; overlay paths
+	// are relative to it.
+	AssetsDir = "/var/lib/multimedia-sys/assets"
+	// PosterDir holds the poster image of each stream, served through
+	// /streams/{name}/poster.jpg rather than next to the playlists.
+	PosterDir     = "/var/lib/multimedia-sys/posters"
+	StatsInterval = 2 * time.Second
+	// RecordingPreRoll and RecordingPostRoll pad every take, so that a take
+	// started with the GPIO button includes the moments before the press.
+	RecordingPreRoll  = 10 * time.Second
+	RecordingPostRoll = 10 * time.Second
+	// DVRWindow is how far back viewers can scrub a running stream, within
+	// DVRMaxSize bytes of segments per stream.
+	DVRWindow  = 2 * time.Hour
+	DVRMaxSize = 4 << 30
+	// ArchiveDir receives every finished session as VOD; each is also remuxed
+	// to an MP4 in VideoStorageDir.
+	ArchiveDir = VideoStorageDir + "/sessions"
+	// KeyDir holds the HLS encryption keys, outside HLSDir so that they are
+	// only served through the authenticated /keys/ route. Segments are
+	// encrypted, with a new key every KeyRotation segments, when
+	// KeyPasswordEnv is set.
+	KeyDir      = "/var/lib/multimedia-sys/keys"
+	KeyRotation = 10
+	// KeyUserEnv and KeyPasswordEnv hold the credentials viewers need to fetch
+	// the keys and, while segments are encrypted, the snapshots, posters,
+	// previews, WebRTC feeds and videos. An empty user accepts any user name.
+	KeyUserEnv     = "MULTIMEDIA_SYS_KEY_USER"
+	KeyPasswordEnv = "MULTIMEDIA_SYS_KEY_PASSWORD"
+	// StallTimeout is how long a stream may go without a new segment before
+	// it is reported as stalled and restarted.
+	StallTimeout = 20 * time.Second
+	// SourceEnv selects the stream source for every profile, e.g. "test" to run
+	// without a camera or sound card.
+	SourceEnv = "MULTIMEDIA_SYS_SOURCE"
 )
 
 func main() {
@@ -49,14 +92,62 @@ func main() {
 		logEntry.Fatalf("Failed to create Video Storage directory: %v", err)
 	}
 
+	// Load stream profiles, falling back to the built-in default
+	var profiles []streaming.StreamProfile
+	if loaded, err := streaming.LoadProfiles(ProfilesFile); err == nil {
+		profiles = loaded
+		logEntry.Infof("Loaded %d stream profiles from %s", len(profiles), ProfilesFile)
+	} else if !os.IsNotExist(err) {
+		logEntry.Fatalf("Failed to load stream profiles: %v", err)
+	}
+
+	// Encrypt HLS segments once viewers have to authenticate for the keys
+	keyUser, keyPassword := os.Getenv(KeyUserEnv), os.Getenv(KeyPasswordEnv)
+	var keyDir string
+	if keyPassword != "" {
+		keyDir = KeyDir
+		logEntry.Infof("Encrypting HLS segments with keys in %s", KeyDir)
+	}
+
 	// Initialize Components
-	streamer := streaming.NewFFmpegStreamer(HLSDir, logrus.NewEntry(logger))
+	streamer := streaming.NewFFmpegStreamer(streaming.Config{
+		HLSDir:       HLSDir,
+		Profiles:     profiles,
+		Restart:      streaming.DefaultRestartPolicy(),
+		RecordingDir: VideoStorageDir,
+		PreRoll:      RecordingPreRoll,
+		PostRoll:     RecordingPostRoll,
+		DVRWindow:    DVRWindow,
+		DVRMaxSize:   DVRMaxSize,
+		ArchiveDir:   ArchiveDir,
+		ArchiveMP4:   true,
+		KeyDir:       keyDir,
+		KeyURI:       "/keys",
+		KeyRotation:  KeyRotation,
+		StallTimeout: StallTimeout,
+		StallRestart: true,
+		Source:       os.Getenv(SourceEnv),
+		AssetsDir:    AssetsDir,
+		PosterDir:    PosterDir,
+	}, logrus.NewEntry(logger))
+	probeCtx, probeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := streamer.ProbeEncoders(probeCtx); err != nil {
+		logEntry.Warnf("Falling back to %s: %v", streamer.Encoder().Selected, err)
+	}
+	probeCancel()
+	if err := streamer.LoadDestinations(DestinationsFile); err != nil {
+		logEntry.Fatalf("Failed to load restream destinations: %v", err)
+	}
+	if err := streamer.LoadOverlays(OverlaysFile); err != nil {
+		logEntry.Fatalf("Failed to load overlays: %v", err)
+	}
 	wsManager := websocket.NewWebSocketManager(logrus.NewEntry(logger))
-	videoManager := videomanager.NewVideoManager(VideoStorageDir, logrus.NewEntry(logger))
+	videoManager := videomanager.NewVideoManager(VideoStorageDir, ArchiveDir, logrus.NewEntry(logger))
 	gpioManager := gpio.NewGPIOManager(GPIOButtonPin, 500*time.Millisecond, logrus.NewEntry(logger))
+	discoverer := devices.NewDiscoverer(devices.Config{}, logrus.NewEntry(logger))
 
 	// Create Facade
-	facade := facade.NewFacade(streamer, wsManager, videoManager, gpioManager, logrus.NewEntry(logger))
+	facade := facade.NewFacade(streamer, wsManager, videoManager, gpioManager, discoverer, logrus.NewEntry(logger))
 
 	// Initialize GPIO
 	if err := facade.InitGPIO(); err != nil {
@@ -67,23 +158,292 @@ func main() {
 	// Setup Router
 	r := mux.NewRouter()
 
-	// API Endpoints
-	r.HandleFunc("/start-stream", func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// protect puts the routes that serve or save decoded media, or handle
+	// the stream keys of destinations, behind the key credentials when
+	// segments are encrypted
+	auth := basicAuth{user: keyUser, password: keyPassword}
+	protect := func(h http.HandlerFunc) http.HandlerFunc {
+		if keyDir == "" {
+			return h
+		}
+		return auth.require(h)
+	}
+
+	// startStream starts the named stream with the profile and source from the query
+	startStream := func(w http.ResponseWriter, r *http.Request, name string) {
+		// The timeout only bounds the startup handshake, not the stream itself
+		ctx, cancel := context.WithTimeout(r.Context(), 12*time.Second)
 		defer cancel()
-		if err := facade.StartStream(ctx); err != nil {
+		opts := streaming.StartOptions{
+			Stream:  name,
+			Profile: r.URL.Query().Get("profile"),
+			Source:  r.URL.Query().Get("source"),
+		}
+		if err := facade.StartStream(ctx, opts); err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string]interface{}{"status": "Stream started", "session": facade.CurrentSession(name)})
+	}
+
+	// stopStream stops the named stream
+	stopStream := func(w http.ResponseWriter, r *http.Request, name string) {
+		result, err := facade.StopStream(name)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		respondJSON(w, map[string]string{"status": "Stream started"})
+		respondJSON(w, map[string]interface{}{"status": "Stream stopped", "result": result})
+	}
+
+	// API Endpoints. The single-stream routes act on the default stream unless
+	// a "stream" query parameter names another one.
+	r.HandleFunc("/start-stream", func(w http.ResponseWriter, r *http.Request) {
+		startStream(w, r, r.URL.Query().Get("stream"))
 	}).Methods("GET")
 
 	r.HandleFunc("/stop-stream", func(w http.ResponseWriter, r *http.Request) {
-		if err := facade.StopStream(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		stopStream(w, r, r.URL.Query().Get("stream"))
+	}).Methods("GET")
+
+	r.HandleFunc("/streams", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string][]streaming.StreamStatus{"streams": facade.Streams()})
+	}).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/start", func(w http.ResponseWriter, r *http.Request) {
+		startStream(w, r, mux.Vars(r)["name"])
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/streams/{name}/stop", func(w http.ResponseWriter, r *http.Request) {
+		stopStream(w, r, mux.Vars(r)["name"])
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/streams/{name}/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := facade.StreamStatus(mux.Vars(r)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, status)
+	}).Methods("GET")
+
+	// snapshot responds with a JPEG of the latest frame of the named stream
+	snapshot := func(w http.ResponseWriter, r *http.Request, name string) {
+		width := 0
+		if v := r.URL.Query().Get("width"); v != "" {
+			var err error
+			if width, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid width", http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		jpeg, err := facade.Snapshot(ctx, name, width)
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(jpeg)
+	}
+
+	r.HandleFunc("/stream/snapshot", protect(func(w http.ResponseWriter, r *http.Request) {
+		snapshot(w, r, r.URL.Query().Get("stream"))
+	})).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/snapshot", protect(func(w http.ResponseWriter, r *http.Request) {
+		snapshot(w, r, mux.Vars(r)["name"])
+	})).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/poster.jpg", protect(func(w http.ResponseWriter, r *http.Request) {
+		jpeg, err := facade.Poster(mux.Vars(r)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(jpeg)
+	})).Methods("GET")
+
+	r.HandleFunc("/stream/preview", protect(func(w http.ResponseWriter, r *http.Request) {
+		servePreview(w, r, facade, r.URL.Query().Get("stream"))
+	})).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/preview.mjpg", protect(func(w http.ResponseWriter, r *http.Request) {
+		servePreview(w, r, facade, mux.Vars(r)["name"])
+	})).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/whep", withWHEPCORS(protect(func(w http.ResponseWriter, r *http.Request) {
+		serveWHEP(w, r, facade, mux.Vars(r)["name"])
+	}))).Methods("POST")
+
+	r.HandleFunc("/streams/{name}/whep/{id}", withWHEPCORS(protect(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		closeWHEP(w, facade, vars["name"], vars["id"])
+	}))).Methods("DELETE")
+
+	// Trickle ICE is not supported; answers already carry every candidate
+	r.HandleFunc("/streams/{name}/whep/{id}", withWHEPCORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})).Methods("PATCH")
+
+	// Preflight requests carry no credentials
+	r.HandleFunc("/streams/{name}/whep{id:(?:/[^/]+)?}", withWHEPCORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods("OPTIONS")
+
+	r.HandleFunc("/streams/{name}/destinations", protect(func(w http.ResponseWriter, r *http.Request) {
+		destinations, err := facade.Destinations(mux.Vars(r)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string][]streaming.DestinationStatus{"destinations": destinations})
+	})).Methods("GET")
+
+	r.HandleFunc("/streams/{name}/destinations", protect(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name    string `json:"name"`
+			URL     string `json:"url"`
+			Key     string `json:"key"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid destination: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		d := streaming.Destination{Name: req.Name, URL: req.URL, Key: req.Key, Enabled: req.Enabled == nil || *req.Enabled}
+		status, err := facade.AddDestination(mux.Vars(r)["name"], d)
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSONStatus(w, http.StatusCreated, status)
+	})).Methods("POST")
+
+	r.HandleFunc("/streams/{name}/destinations/{id}/{action:enable|disable}", protect(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		status, err := facade.SetDestinationEnabled(vars["name"], vars["id"], vars["action"] == "enable")
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, status)
+	})).Methods("POST")
+
+	r.HandleFunc("/streams/{name}/destinations/{id}", protect(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := facade.RemoveDestination(vars["name"], vars["id"]); err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
 			return
 		}
-		respondJSON(w, map[string]string{"status": "Stream stopped"})
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods("DELETE")
+
+	r.HandleFunc("/start-recording", func(w http.ResponseWriter, r *http.Request) {
+		take, err := facade.StartRecording(r.URL.Query().Get("stream"))
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string]interface{}{"status": "Recording started", "recording": take})
+	}).Methods("GET")
+
+	r.HandleFunc("/stop-recording", func(w http.ResponseWriter, r *http.Request) {
+		take, err := facade.StopRecording(r.URL.Query().Get("stream"))
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string]interface{}{"status": "Recording stopped", "recording": take})
+	}).Methods("GET")
+
+	r.HandleFunc("/stream/replay", protect(func(w http.ResponseWriter, r *http.Request) {
+		seconds := streaming.DefaultReplaySeconds
+		if v := r.URL.Query().Get("seconds"); v != "" {
+			var err error
+			if seconds, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid seconds", http.StatusBadRequest)
+				return
+			}
+		}
+		clip, err := facade.Replay(r.URL.Query().Get("stream"), seconds)
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSONStatus(w, http.StatusAccepted, map[string]interface{}{"status": "Replay is being saved", "replay": clip})
+	})).Methods("GET", "POST")
+
+	r.HandleFunc("/recording", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string]interface{}{"recording": facade.Recording(r.URL.Query().Get("stream"))})
+	}).Methods("GET")
+
+	r.HandleFunc("/stream/stats", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, facade.Stats(r.URL.Query().Get("stream")))
+	}).Methods("GET")
+
+	r.HandleFunc("/stream/levels", func(w http.ResponseWriter, r *http.Request) {
+		levels, err := facade.Levels(r.URL.Query().Get("stream"))
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, levels)
+	}).Methods("GET")
+
+	r.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string]interface{}{
+			"current":  facade.CurrentSession(r.URL.Query().Get("stream")),
+			"sessions": facade.Sessions(),
+		})
+	}).Methods("GET")
+
+	r.HandleFunc("/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		session, err := facade.Session(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, session)
+	}).Methods("GET")
+
+	r.HandleFunc("/profiles", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string][]streaming.StreamProfile{"profiles": facade.Profiles()})
+	}).Methods("GET")
+
+	r.HandleFunc("/stream/overlays", func(w http.ResponseWriter, r *http.Request) {
+		profile := r.URL.Query().Get("profile")
+		overlays, err := facade.Overlays(profile)
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string]interface{}{"profile": profile, "overlays": overlays})
+	}).Methods("GET")
+
+	r.HandleFunc("/stream/overlays", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Overlays []streaming.Overlay `json:"overlays"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid overlays: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		profile := r.URL.Query().Get("profile")
+		overlays, err := facade.SetOverlays(profile, req.Overlays)
+		if err != nil {
+			http.Error(w, err.Error(), streamErrorStatus(err))
+			return
+		}
+		respondJSON(w, map[string]interface{}{"profile": profile, "overlays": overlays})
+	}).Methods("PUT", "POST")
+
+	r.HandleFunc("/encoders", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, facade.Encoder())
 	}).Methods("GET")
 
 	r.HandleFunc("/list-videos", func(w http.ResponseWriter, r *http.Request) {
@@ -92,22 +452,37 @@ func main() {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		respondJSON(w, map[string][]string{"videos": videos})
+		respondJSON(w, map[string]interface{}{"videos": videos})
 	}).Methods("GET")
 
-	r.HandleFunc("/videos/{filename}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/devices", func(w http.ResponseWriter, r *http.Request) {
+		inventory, err := facade.Devices()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, inventory)
+	}).Methods("GET")
+
+	r.HandleFunc("/videos/{filename}", protect(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		filename := vars["filename"]
-		if err := facade.ServeVideo(filename, w); err != nil {
+		if err := facade.ServeVideo(filename, w, r); err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-	}).Methods("GET")
+	})).Methods("GET")
 
 	r.HandleFunc("/ws", facade.RegisterWebSocket).Methods("GET")
 
-	// Serve HLS streams
-	r.PathPrefix("/hls/").Handler(http.StripPrefix("/hls/", http.FileServer(http.Dir(HLSDir))))
+	// Serve HLS streams, with LL-HLS blocking playlist reloads and preload hints
+	r.PathPrefix("/hls/").Handler(newHLSHandler(facade, HLSDir))
+
+	// Serve HLS encryption keys to authenticated viewers
+	r.PathPrefix("/keys/").Handler(newKeyHandler(facade, auth))
+
+	// Serve archived sessions as VOD to authenticated viewers
+	r.PathPrefix("/archive/").Handler(protect(http.StripPrefix("/archive/", http.FileServer(http.Dir(ArchiveDir))).ServeHTTP))
 
 	// Serve Embedded Web Client
 	r.HandleFunc("/", serveWebClient).Methods("GET")
@@ -128,6 +503,9 @@ func main() {
 	// Start GPIO Monitoring in a separate goroutine
 	go facade.MonitorGPIO(ctx)
 
+	// Push encoder telemetry to WebSocket clients
+	go facade.MonitorStats(ctx, StatsInterval)
+
 	// WaitGroup to handle graceful shutdown
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -161,6 +539,16 @@ func main() {
 	// Cancel GPIO monitoring
 	cancel()
 
+	// Stop the running streams so FFmpeg can finalize their playlists
+	for _, status := range facade.Streams() {
+		if !status.Streaming {
+			continue
+		}
+		if _, err := facade.StopStream(status.Name); err != nil {
+			logEntry.Errorf("Failed to stop stream %s during shutdown: %v", status.Name, err)
+		}
+	}
+
 	// Wait for server goroutine to finish
 	wg.Wait()
 	logEntry.Info("Server exited gracefully")
@@ -213,6 +601,33 @@ func serveWebClient(w http.ResponseWriter, r *http.Request) {
 
 // respondJSON sends a JSON response with appropriate headers.
 func respondJSON(w http.ResponseWriter, payload interface{}) {
+	respondJSONStatus(w, http.StatusOK, payload)
+}
+
+// respondJSONStatus writes payload as JSON with the given status code.
+func respondJSONStatus(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(payload)
-}
\ No newline at end of file
+}
+
+// streamErrorStatus maps streaming errors to HTTP status codes.
+func streamErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, streaming.ErrInvalidProfile), errors.Is(err, streaming.ErrUnknownProfile),
+		errors.Is(err, streaming.ErrInvalidStreamName), errors.Is(err, streaming.ErrInvalidDestination),
+		errors.Is(err, streaming.ErrInvalidWidth), errors.Is(err, streaming.ErrInvalidOffer),
+		errors.Is(err, streaming.ErrInvalidOverlay), errors.Is(err, streaming.ErrInvalidReplay):
+		return http.StatusBadRequest
+	case errors.Is(err, streaming.ErrNoFrame):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, streaming.ErrDestinationNotFound), errors.Is(err, streaming.ErrViewerNotFound),
+		errors.Is(err, streaming.ErrUnknownKey):
+		return http.StatusNotFound
+	case errors.Is(err, streaming.ErrNotStreaming), errors.Is(err, streaming.ErrAlreadyRecording),
+		errors.Is(err, streaming.ErrNotRecording), errors.Is(err, streaming.ErrRecordingDisabled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Cdaprod/multimedia-sys/internal/facade"
+)
+
+// previewBoundary separates the JPEG parts of the MJPEG response.
+const previewBoundary = "frame"
+
+// servePreview streams the MJPEG viewfinder of the named stream as a
+// multipart/x-mixed-replace response until the client goes away or the
+// stream stops.
+func servePreview(w http.ResponseWriter, r *http.Request, f facade.Facade, name string) {
+	frames, cancel, err := f.SubscribePreview(name)
+	if err != nil {
+		http.Error(w, err.Error(), streamErrorStatus(err))
+		return
+	}
+	defer cancel()
+
+	// The preview outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+previewBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Connection", "close")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", previewBoundary, len(frame))
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			fmt.Fprint(w, "\r\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
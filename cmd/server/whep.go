@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/Cdaprod/multimedia-sys/internal/facade"
+)
+
+const (
+	// maxOfferSize bounds the SDP offer of a WHEP request.
+	maxOfferSize = 64 << 10
+	// whepTimeout bounds the negotiation of a WHEP viewer, ICE gathering included.
+	whepTimeout = 10 * time.Second
+)
+
+// allowWHEP sets the CORS headers that let browser WHEP players on other
+// origins, such as a tablet's player page, negotiate with the server.
+func allowWHEP(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+}
+
+// withWHEPCORS sets the CORS headers before next runs, so that players also
+// read the responses of refused requests.
+func withWHEPCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowWHEP(w)
+		next(w, r)
+	}
+}
+
+// serveWHEP answers the SDP offer of a WHEP player for the named stream with
+// a 201 response carrying the SDP answer and the viewer's resource URL.
+func serveWHEP(w http.ResponseWriter, r *http.Request, f facade.Facade, name string) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/sdp" {
+		http.Error(w, "offer must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxOfferSize))
+	if err != nil {
+		http.Error(w, "failed to read offer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), whepTimeout)
+	defer cancel()
+	viewer, err := f.OpenWHEP(ctx, name, string(offer))
+	if err != nil {
+		http.Error(w, err.Error(), streamErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/streams/"+viewer.Stream+"/whep/"+viewer.ID)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, viewer.Answer)
+}
+
+// closeWHEP ends a WHEP viewer of the named stream.
+func closeWHEP(w http.ResponseWriter, f facade.Facade, name, id string) {
+	if err := f.CloseWHEP(name, id); err != nil {
+		http.Error(w, err.Error(), streamErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,131 @@
+package devices
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cardLinePattern matches the first line of a card in /proc/asound/cards:
+// " 1 [Device         ]: USB-Audio - USB Audio Device".
+var cardLinePattern = regexp.MustCompile(`^\s*(\d+)\s+\[([^\]]*)\]:\s*(.*?)\s+-\s+(.*)$`)
+
+// AudioDevice is a capture PCM of an ALSA sound card.
+type AudioDevice struct {
+	Path string `json:"path"` // ALSA device for FFmpeg's -f alsa, e.g. "hw:1,0"
+	// StablePath names the same device by card ID, e.g. "hw:CARD=Device,DEV=0",
+	// which survives cards being numbered differently after a reboot.
+	StablePath string `json:"stable_path"`
+	Card       int    `json:"card"`
+	Device     int    `json:"device"`
+	CardID     string `json:"card_id"`
+	CardName   string `json:"card_name"`
+	Name       string `json:"name"` // PCM name
+}
+
+// alsaCard is a sound card listed in /proc/asound/cards.
+type alsaCard struct {
+	id   string
+	name string
+}
+
+// AudioDevices lists the ALSA capture PCMs from /proc/asound. A machine
+// without sound cards has none.
+func (d *DiscovererImpl) AudioDevices() ([]AudioDevice, error) {
+	asound := filepath.Join(d.procRoot, "asound")
+	cards, err := readCards(filepath.Join(asound, "cards"))
+	if os.IsNotExist(err) {
+		return []AudioDevice{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(asound, "pcm"))
+	if os.IsNotExist(err) {
+		return []AudioDevice{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	devices := []AudioDevice{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		dev, capture, ok := parsePCM(scanner.Text())
+		if !ok || !capture {
+			continue
+		}
+		card := cards[dev.Card]
+		dev.CardID, dev.CardName = card.id, card.name
+		dev.Path = fmt.Sprintf("hw:%d,%d", dev.Card, dev.Device)
+		dev.StablePath = dev.Path
+		if card.id != "" {
+			dev.StablePath = fmt.Sprintf("hw:CARD=%s,DEV=%d", card.id, dev.Device)
+		}
+		devices = append(devices, dev)
+	}
+	return devices, scanner.Err()
+}
+
+// readCards parses /proc/asound/cards, whose cards take two lines each: the
+// number, ID, driver and name, then the long name.
+func readCards(path string) (map[int]alsaCard, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cards := make(map[int]alsaCard)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := cardLinePattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		cards[n] = alsaCard{id: strings.TrimSpace(m[2]), name: strings.TrimSpace(m[4])}
+	}
+	return cards, scanner.Err()
+}
+
+// parsePCM parses a line of /proc/asound/pcm such as
+// "01-00: USB Audio : USB Audio : capture 1" and reports whether the PCM
+// can capture.
+func parsePCM(line string) (AudioDevice, bool, bool) {
+	address, rest, ok := strings.Cut(line, ": ")
+	if !ok {
+		return AudioDevice{}, false, false
+	}
+	cardNum, devNum, ok := strings.Cut(address, "-")
+	if !ok {
+		return AudioDevice{}, false, false
+	}
+	card, err := strconv.Atoi(cardNum)
+	if err != nil {
+		return AudioDevice{}, false, false
+	}
+	device, err := strconv.Atoi(devNum)
+	if err != nil {
+		return AudioDevice{}, false, false
+	}
+
+	fields := strings.Split(rest, " : ")
+	dev := AudioDevice{Card: card, Device: device}
+	if len(fields) > 1 {
+		dev.Name = strings.TrimSpace(fields[1])
+	}
+	capture := false
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(strings.TrimSpace(field), "capture ") {
+			capture = true
+		}
+	}
+	return dev, capture, true
+}
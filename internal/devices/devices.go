@@ -0,0 +1,70 @@
+package devices
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Discoverer defines the interface for capture device discovery.
+type Discoverer interface {
+	Discover() (Inventory, error)
+	VideoDevices() ([]VideoDevice, error)
+	AudioDevices() ([]AudioDevice, error)
+}
+
+// Inventory lists the capture devices of the machine.
+type Inventory struct {
+	Video []VideoDevice `json:"video"`
+	Audio []AudioDevice `json:"audio"`
+}
+
+// Config holds the file system roots devices are discovered under. They
+// default to the live system and can point at a fixture tree instead.
+type Config struct {
+	SysRoot  string // sysfs, defaults to /sys
+	ProcRoot string // procfs, defaults to /proc
+	DevRoot  string // Device nodes, defaults to /dev
+}
+
+// DiscovererImpl implements the Discoverer interface using sysfs, procfs and
+// V4L2 ioctls.
+type DiscovererImpl struct {
+	sysRoot  string
+	procRoot string
+	devRoot  string
+	querier  deviceQuerier
+	logger   *logrus.Entry
+}
+
+// NewDiscoverer creates a new Discoverer instance.
+func NewDiscoverer(cfg Config, logger *logrus.Entry) *DiscovererImpl {
+	if cfg.SysRoot == "" {
+		cfg.SysRoot = "/sys"
+	}
+	if cfg.ProcRoot == "" {
+		cfg.ProcRoot = "/proc"
+	}
+	if cfg.DevRoot == "" {
+		cfg.DevRoot = "/dev"
+	}
+	return &DiscovererImpl{
+		sysRoot:  cfg.SysRoot,
+		procRoot: cfg.ProcRoot,
+		devRoot:  cfg.DevRoot,
+		querier:  v4l2Querier{},
+		logger:   logger,
+	}
+}
+
+// Discover lists the video and audio capture devices.
+func (d *DiscovererImpl) Discover() (Inventory, error) {
+	video, err := d.VideoDevices()
+	if err != nil {
+		return Inventory{}, err
+	}
+	audio, err := d.AudioDevices()
+	if err != nil {
+		return Inventory{}, err
+	}
+	d.logger.Debugf("Found %d video and %d audio capture devices", len(video), len(audio))
+	return Inventory{Video: video, Audio: audio}, nil
+}
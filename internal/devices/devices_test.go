@@ -0,0 +1,185 @@
+package devices
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeQuerier answers for the nodes of a fixture tree by name; other nodes
+// cannot be opened.
+type fakeQuerier map[string]*deviceInfo
+
+// query implements deviceQuerier.
+func (q fakeQuerier) query(path string) (*deviceInfo, error) {
+	info, ok := q[filepath.Base(path)]
+	if !ok {
+		return nil, os.ErrPermission
+	}
+	return info, nil
+}
+
+// rigQuerier answers for the nodes of testdata/rig: a USB webcam with its
+// metadata node, a camera module, a node that cannot be opened and a codec.
+var rigQuerier = fakeQuerier{
+	"video0": {
+		driver:  "uvcvideo",
+		card:    "HD USB Camera: HD USB Camera",
+		busInfo: "usb-0000:01:00.0-1.2",
+		caps:    capVideoCapture,
+		formats: []PixelFormat{
+			{FourCC: "MJPG", Description: "Motion-JPEG", Compressed: true, Sizes: []FrameSize{{Width: 1920, Height: 1080, Framerates: []float64{30}}}},
+			{FourCC: "YUYV", Description: "YUYV 4:2:2", Sizes: []FrameSize{{Width: 640, Height: 480, Framerates: []float64{30, 15}}}},
+		},
+	},
+	"video1":  {driver: "uvcvideo", card: "HD USB Camera: HD USB Camera", caps: 0x00800000},
+	"video2":  {driver: "unicam", card: "unicam", busInfo: "platform:fe801000.csi", caps: capVideoCapture},
+	"video10": {driver: "bcm2835-codec", card: "bcm2835-codec-decode", caps: capVideoCaptureMPlane | capVideoM2MMPlane},
+}
+
+// testLogger returns a logger that discards its output.
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+// fixtureDiscoverer returns a Discoverer of the fixture tree at root.
+func fixtureDiscoverer(root string, querier deviceQuerier) *DiscovererImpl {
+	d := NewDiscoverer(Config{
+		SysRoot:  filepath.Join(root, "sys"),
+		ProcRoot: filepath.Join(root, "proc"),
+		DevRoot:  filepath.Join(root, "dev"),
+	}, testLogger())
+	d.querier = querier
+	return d
+}
+
+func TestDiscover(t *testing.T) {
+	rig := filepath.Join("testdata", "rig")
+	tests := []struct {
+		name    string
+		root    string
+		querier deviceQuerier
+		want    Inventory
+	}{
+		{
+			name:    "capture rig",
+			root:    rig,
+			querier: rigQuerier,
+			want: Inventory{
+				Video: []VideoDevice{
+					{
+						Path:       filepath.Join(rig, "dev", "video0"),
+						StablePath: filepath.Join(rig, "dev", "v4l", "by-id", "usb-HD_USB_Camera-video-index0"),
+						Name:       "HD USB Camera: HD USB Camera",
+						Driver:     "uvcvideo",
+						BusInfo:    "usb-0000:01:00.0-1.2",
+						Formats:    rigQuerier["video0"].formats,
+					},
+					{
+						Path:    filepath.Join(rig, "dev", "video2"),
+						Name:    "unicam",
+						Driver:  "unicam",
+						BusInfo: "platform:fe801000.csi",
+						Formats: []PixelFormat{},
+					},
+					{
+						Path:    filepath.Join(rig, "dev", "video3"),
+						Name:    "unicam-image",
+						Formats: []PixelFormat{},
+						Error:   os.ErrPermission.Error(),
+					},
+				},
+				Audio: []AudioDevice{{
+					Path:       "hw:1,0",
+					StablePath: "hw:CARD=Device,DEV=0",
+					Card:       1,
+					Device:     0,
+					CardID:     "Device",
+					CardName:   "USB Audio Device",
+					Name:       "USB Audio",
+				}},
+			},
+		},
+		{
+			name:    "no capture devices",
+			root:    filepath.Join("testdata", "hdmi-only"),
+			querier: rigQuerier,
+			want:    Inventory{Video: []VideoDevice{}, Audio: []AudioDevice{}},
+		},
+		{
+			name:    "no sysfs or procfs",
+			root:    filepath.Join("testdata", "missing"),
+			querier: fakeQuerier{},
+			want:    Inventory{Video: []VideoDevice{}, Audio: []AudioDevice{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fixtureDiscoverer(tt.root, tt.querier).Discover()
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Video, tt.want.Video) {
+				t.Errorf("Discover() video = %+v, want %+v", got.Video, tt.want.Video)
+			}
+			if !reflect.DeepEqual(got.Audio, tt.want.Audio) {
+				t.Errorf("Discover() audio = %+v, want %+v", got.Audio, tt.want.Audio)
+			}
+		})
+	}
+}
+
+func TestParsePCM(t *testing.T) {
+	tests := []struct {
+		line        string
+		want        AudioDevice
+		wantCapture bool
+		wantOK      bool
+	}{
+		{"01-00: USB Audio : USB Audio : playback 1 : capture 1", AudioDevice{Card: 1, Device: 0, Name: "USB Audio"}, true, true},
+		{"02-03: bcm2835 HDMI : bcm2835 HDMI 1 : playback 1", AudioDevice{Card: 2, Device: 3, Name: "bcm2835 HDMI 1"}, false, true},
+		{"10-01: Mic : Mic : capture 2", AudioDevice{Card: 10, Device: 1, Name: "Mic"}, true, true},
+		{"01-00 USB Audio", AudioDevice{}, false, false},
+		{"card-00: USB Audio : USB Audio : capture 1", AudioDevice{}, false, false},
+		{"", AudioDevice{}, false, false},
+	}
+	for _, tt := range tests {
+		dev, capture, ok := parsePCM(tt.line)
+		if dev != tt.want || capture != tt.wantCapture || ok != tt.wantOK {
+			t.Errorf("parsePCM(%q) = %+v, %v, %v, want %+v, %v, %v", tt.line, dev, capture, ok, tt.want, tt.wantCapture, tt.wantOK)
+		}
+	}
+}
+
+func TestReadCards(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want map[int]alsaCard
+	}{
+		{"rig", filepath.Join("testdata", "rig", "proc", "asound", "cards"), map[int]alsaCard{
+			0: {id: "vc4hdmi", name: "vc4-hdmi"},
+			1: {id: "Device", name: "USB Audio Device"},
+		}},
+		{"hdmi only", filepath.Join("testdata", "hdmi-only", "proc", "asound", "cards"), map[int]alsaCard{
+			0: {id: "vc4hdmi", name: "vc4-hdmi"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCards(tt.path)
+			if err != nil {
+				t.Fatalf("readCards() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCards() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
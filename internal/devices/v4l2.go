@@ -0,0 +1,188 @@
+package devices
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// V4L2 capability flags, from linux/videodev2.h.
+const (
+	capVideoCapture       = 0x00000001
+	capVideoCaptureMPlane = 0x00001000
+	capVideoM2MMPlane     = 0x00004000
+	capVideoM2M           = 0x00008000
+	capDeviceCaps         = 0x80000000
+)
+
+// VideoDevice is a V4L2 video capture device.
+type VideoDevice struct {
+	Path string `json:"path"` // Device node, e.g. "/dev/video0"
+	// StablePath is the symlink to the node under /dev/v4l/by-id, which
+	// survives devices being numbered differently after a reboot.
+	StablePath string        `json:"stable_path,omitempty"`
+	Name       string        `json:"name"`
+	Driver     string        `json:"driver,omitempty"`
+	BusInfo    string        `json:"bus_info,omitempty"`
+	Formats    []PixelFormat `json:"formats"`
+	// Error tells why the device could not be queried, in which case it is
+	// listed from sysfs alone.
+	Error string `json:"error,omitempty"`
+}
+
+// PixelFormat is a format a device captures in.
+type PixelFormat struct {
+	FourCC      string      `json:"fourcc"` // e.g. "YUYV" or "MJPG"
+	Description string      `json:"description"`
+	Compressed  bool        `json:"compressed,omitempty"`
+	Sizes       []FrameSize `json:"sizes"`
+}
+
+// FrameSize is a resolution a format is captured at. Devices that scale
+// freely report their smallest and largest size with Stepwise set.
+type FrameSize struct {
+	Width    int  `json:"width"`
+	Height   int  `json:"height"`
+	Stepwise bool `json:"stepwise,omitempty"`
+	// Framerates lists the frame rates of the size, or the lowest and highest
+	// of a continuous range.
+	Framerates []float64 `json:"framerates"`
+}
+
+// deviceInfo is what a V4L2 device reports about itself.
+type deviceInfo struct {
+	driver  string
+	card    string
+	busInfo string
+	caps    uint32 // Capabilities of the device node
+	formats []PixelFormat
+}
+
+// deviceQuerier asks a V4L2 device node about itself. Tests replace it, as
+// the nodes of their fixture trees are not devices.
+type deviceQuerier interface {
+	query(path string) (*deviceInfo, error)
+}
+
+// v4l2Querier queries device nodes with V4L2 ioctls.
+type v4l2Querier struct{}
+
+// query implements deviceQuerier.
+func (v4l2Querier) query(path string) (*deviceInfo, error) {
+	return queryDevice(path)
+}
+
+// capture reports whether the node captures video, as opposed to codec,
+// output or metadata nodes.
+func (info *deviceInfo) capture() bool {
+	return info.caps&(capVideoCapture|capVideoCaptureMPlane) != 0 &&
+		info.caps&(capVideoM2M|capVideoM2MMPlane) == 0
+}
+
+// VideoDevices lists the V4L2 capture devices registered in sysfs, in node
+// order. A machine without V4L2 devices has none.
+func (d *DiscovererImpl) VideoDevices() ([]VideoDevice, error) {
+	classDir := filepath.Join(d.sysRoot, "class", "video4linux")
+	entries, err := os.ReadDir(classDir)
+	if os.IsNotExist(err) {
+		return []VideoDevice{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "video") {
+			nodes = append(nodes, e.Name())
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeNumber(nodes[i]) < nodeNumber(nodes[j]) })
+
+	stable := stablePaths(filepath.Join(d.devRoot, "v4l", "by-id"))
+	devices := []VideoDevice{}
+	for _, node := range nodes {
+		dev := VideoDevice{
+			Path:       filepath.Join(d.devRoot, node),
+			StablePath: stable[node],
+			Name:       readAttribute(filepath.Join(classDir, node, "name")),
+			Formats:    []PixelFormat{},
+		}
+		info, err := d.querier.query(dev.Path)
+		if err != nil {
+			d.logger.Warnf("Failed to query video device %s: %v", dev.Path, err)
+			dev.Error = err.Error()
+			devices = append(devices, dev)
+			continue
+		}
+		if !info.capture() {
+			continue
+		}
+		if dev.Name == "" {
+			dev.Name = info.card
+		}
+		dev.Driver, dev.BusInfo = info.driver, info.busInfo
+		if info.formats != nil {
+			dev.Formats = info.formats
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// nodeNumber returns the number of a node such as "video10", so that nodes
+// sort numerically.
+func nodeNumber(node string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(node, "video"))
+	if err != nil {
+		return math.MaxInt32
+	}
+	return n
+}
+
+// stablePaths maps node names to the symlinks in dir pointing at them.
+func stablePaths(dir string) map[string]string {
+	paths := make(map[string]string)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return paths
+	}
+	for _, e := range entries {
+		link := filepath.Join(dir, e.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		if node := filepath.Base(target); paths[node] == "" {
+			paths[node] = link
+		}
+	}
+	return paths
+}
+
+// readAttribute returns the trimmed content of a sysfs attribute, or an
+// empty string when it cannot be read.
+func readAttribute(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// fourCC renders a V4L2 pixel format code such as 0x56595559 as "YUYV".
+func fourCC(code uint32) string {
+	b := []byte{byte(code), byte(code >> 8), byte(code >> 16), byte(code >> 24)}
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// framerate converts a frame interval of numerator/denominator seconds to
+// frames per second, rounded to three decimals.
+func framerate(numerator, denominator uint32) float64 {
+	if numerator == 0 {
+		return 0
+	}
+	return math.Round(float64(denominator)/float64(numerator)*1000) / 1000
+}
@@ -0,0 +1,156 @@
+//go:build linux
+
+package devices
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// V4L2 ioctl requests, from linux/videodev2.h. Their arguments hold no
+// pointers, so that the requests and layouts are the same on every
+// architecture.
+const (
+	vidiocQueryCap           = 0x80685600 // struct v4l2_capability, 104 bytes
+	vidiocEnumFmt            = 0xc0405602 // struct v4l2_fmtdesc, 64 bytes
+	vidiocEnumFrameSizes     = 0xc02c564a // struct v4l2_frmsizeenum, 44 bytes
+	vidiocEnumFrameIntervals = 0xc034564b // struct v4l2_frmivalenum, 52 bytes
+)
+
+// V4L2 buffer types, format flags and enumeration types.
+const (
+	bufTypeVideoCapture       = 1
+	bufTypeVideoCaptureMPlane = 9
+	fmtFlagCompressed         = 0x0001
+	enumTypeDiscrete          = 1
+)
+
+// le decodes the ioctl arguments. Every architecture the server runs on is
+// little-endian.
+var le = binary.LittleEndian
+
+// queryDevice reads the capabilities of the V4L2 node at path and, for
+// capture nodes, the formats, sizes and frame rates it captures.
+func queryDevice(path string) (*deviceInfo, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fd := f.Fd()
+
+	capability := make([]byte, 104)
+	if err := ioctl(fd, vidiocQueryCap, capability); err != nil {
+		return nil, err
+	}
+	info := &deviceInfo{
+		driver:  cString(capability[0:16]),
+		card:    cString(capability[16:48]),
+		busInfo: cString(capability[48:80]),
+		caps:    le.Uint32(capability[84:88]),
+	}
+	if info.caps&capDeviceCaps != 0 {
+		info.caps = le.Uint32(capability[88:92])
+	}
+	if !info.capture() {
+		return info, nil
+	}
+
+	bufType := uint32(bufTypeVideoCapture)
+	if info.caps&capVideoCapture == 0 {
+		bufType = bufTypeVideoCaptureMPlane
+	}
+	for i := uint32(0); ; i++ {
+		desc := make([]byte, 64)
+		le.PutUint32(desc[0:], i)
+		le.PutUint32(desc[4:], bufType)
+		if ioctl(fd, vidiocEnumFmt, desc) != nil {
+			break
+		}
+		code := le.Uint32(desc[44:48])
+		info.formats = append(info.formats, PixelFormat{
+			FourCC:      fourCC(code),
+			Description: cString(desc[12:44]),
+			Compressed:  le.Uint32(desc[8:12])&fmtFlagCompressed != 0,
+			Sizes:       frameSizes(fd, code),
+		})
+	}
+	return info, nil
+}
+
+// frameSizes lists the frame sizes of the pixel format code.
+func frameSizes(fd uintptr, code uint32) []FrameSize {
+	sizes := []FrameSize{}
+	for i := uint32(0); ; i++ {
+		arg := make([]byte, 44)
+		le.PutUint32(arg[0:], i)
+		le.PutUint32(arg[4:], code)
+		if ioctl(fd, vidiocEnumFrameSizes, arg) != nil {
+			return sizes
+		}
+		if le.Uint32(arg[8:12]) == enumTypeDiscrete {
+			sizes = append(sizes, frameSize(fd, code, le.Uint32(arg[12:16]), le.Uint32(arg[16:20]), false))
+			continue
+		}
+		// Continuous and stepwise sizes come as a single range
+		sizes = append(sizes,
+			frameSize(fd, code, le.Uint32(arg[12:16]), le.Uint32(arg[24:28]), true),
+			frameSize(fd, code, le.Uint32(arg[16:20]), le.Uint32(arg[28:32]), true),
+		)
+		return sizes
+	}
+}
+
+// frameSize returns the frame size width x height of the pixel format code
+// with its frame rates.
+func frameSize(fd uintptr, code, width, height uint32, stepwise bool) FrameSize {
+	size := FrameSize{Width: int(width), Height: int(height), Stepwise: stepwise, Framerates: []float64{}}
+	for i := uint32(0); ; i++ {
+		arg := make([]byte, 52)
+		le.PutUint32(arg[0:], i)
+		le.PutUint32(arg[4:], code)
+		le.PutUint32(arg[8:], width)
+		le.PutUint32(arg[12:], height)
+		if ioctl(fd, vidiocEnumFrameIntervals, arg) != nil {
+			return size
+		}
+		if le.Uint32(arg[16:20]) == enumTypeDiscrete {
+			size.Framerates = append(size.Framerates, framerate(le.Uint32(arg[20:24]), le.Uint32(arg[24:28])))
+			continue
+		}
+		// The shortest interval is the highest frame rate
+		size.Framerates = append(size.Framerates,
+			framerate(le.Uint32(arg[28:32]), le.Uint32(arg[32:36])),
+			framerate(le.Uint32(arg[20:24]), le.Uint32(arg[24:28])),
+		)
+		return size
+	}
+}
+
+// ioctl issues the V4L2 request req on fd with the argument arg, retrying
+// when interrupted.
+func ioctl(fd, req uintptr, arg []byte) error {
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(&arg[0])))
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return errno
+		}
+	}
+}
+
+// cString returns the NUL-terminated string in b.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package devices
+
+import "errors"
+
+// errUnsupported is returned when querying V4L2 devices off Linux.
+var errUnsupported = errors.New("V4L2 is only supported on Linux")
+
+// queryDevice reports that V4L2 devices cannot be queried on this platform.
+func queryDevice(path string) (*deviceInfo, error) {
+	return nil, errUnsupported
+}
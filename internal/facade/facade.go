@@ -2,7 +2,11 @@ package facade
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"time"
 
+	"github.com/Cdaprod/multimedia-sys/internal/devices"
 	"github.com/Cdaprod/multimedia-sys/internal/gpio"
 	"github.com/Cdaprod/multimedia-sys/internal/streaming"
 	"github.com/Cdaprod/multimedia-sys/internal/videomanager"
@@ -12,11 +16,40 @@ import (
 
 // Facade defines the interface for interacting with all subsystems.
 type Facade interface {
-	StartStream(ctx context.Context) error
-	StopStream() error
-	IsStreaming() bool
-	ListVideos() ([]string, error)
-	ServeVideo(filename string, w http.ResponseWriter) error
+	StartStream(ctx context.Context, opts streaming.StartOptions) error
+	StopStream(name string) (streaming.StopResult, error)
+	IsStreaming(name string) bool
+	Streams() []streaming.StreamStatus
+	StreamStatus(name string) (streaming.StreamStatus, error)
+	CurrentSession(name string) *streaming.StreamSession
+	Sessions() []streaming.StreamSession
+	Session(id string) (streaming.StreamSession, error)
+	Profiles() []streaming.StreamProfile
+	Encoder() streaming.EncoderInfo
+	Stats(name string) streaming.Stats
+	StartRecording(name string) (streaming.Recording, error)
+	StopRecording(name string) (streaming.Recording, error)
+	Recording(name string) *streaming.Recording
+	Replay(name string, seconds int) (streaming.Replay, error)
+	AwaitPlaylist(ctx context.Context, name string, msn, part int) error
+	AwaitPart(ctx context.Context, name, uri string) error
+	Destinations(name string) ([]streaming.DestinationStatus, error)
+	AddDestination(name string, d streaming.Destination) (streaming.DestinationStatus, error)
+	SetDestinationEnabled(name, id string, enabled bool) (streaming.DestinationStatus, error)
+	RemoveDestination(name, id string) error
+	Snapshot(ctx context.Context, name string, width int) ([]byte, error)
+	Poster(name string) ([]byte, error)
+	SubscribePreview(name string) (<-chan []byte, func(), error)
+	Overlays(profile string) ([]streaming.Overlay, error)
+	SetOverlays(profile string, overlays []streaming.Overlay) ([]streaming.Overlay, error)
+	Levels(name string) (streaming.AudioLevels, error)
+	Key(name string) ([]byte, error)
+	OpenWHEP(ctx context.Context, name, offer string) (streaming.WHEPSession, error)
+	CloseWHEP(name, id string) error
+	MonitorStats(ctx context.Context, interval time.Duration)
+	ListVideos() ([]videomanager.VideoInfo, error)
+	ServeVideo(filename string, w http.ResponseWriter, r *http.Request) error
+	Devices() (devices.Inventory, error)
 	BroadcastMessage(message string)
 	RegisterWebSocket(w http.ResponseWriter, r *http.Request)
 	InitGPIO() error
@@ -25,63 +58,282 @@ type Facade interface {
 
 // facadeImpl implements the Facade interface.
 type facadeImpl struct {
-	streamer    streaming.Streamer
-	wsManager   websocket.WebSocketManager
+	streamer     streaming.Streamer
+	wsManager    websocket.WebSocketManager
 	videoManager videomanager.VideoManager
-	gpioManager gpio.GPIOManager
-	logger      *logrus.Entry
+	gpioManager  gpio.GPIOManager
+	discoverer   devices.Discoverer
+	logger       *logrus.Entry
 }
 
 // NewFacade creates a new Facade instance.
-func NewFacade(streamer streaming.Streamer, wsManager websocket.WebSocketManager, videoManager videomanager.VideoManager, gpioManager gpio.GPIOManager, logger *logrus.Entry) Facade {
-	return &facadeImpl{
-		streamer:    streamer,
-		wsManager:   wsManager,
+func NewFacade(streamer streaming.Streamer, wsManager websocket.WebSocketManager, videoManager videomanager.VideoManager, gpioManager gpio.GPIOManager, discoverer devices.Discoverer, logger *logrus.Entry) Facade {
+	f := &facadeImpl{
+		streamer:     streamer,
+		wsManager:    wsManager,
 		videoManager: videoManager,
-		gpioManager: gpioManager,
-		logger:      logger,
+		gpioManager:  gpioManager,
+		discoverer:   discoverer,
+		logger:       logger,
 	}
+	streamer.SetEventHandler(f.handleStreamEvent)
+	streamer.SetLevelHandler(f.handleLevels)
+	return f
 }
 
-// StartStream initiates the streaming process.
-func (f *facadeImpl) StartStream(ctx context.Context) error {
-	f.logger.Info("Facade: Starting stream")
-	err := f.streamer.StartStream(ctx)
+// handleStreamEvent forwards stream lifecycle events to WebSocket clients.
+// Events carry the name of the stream they belong to.
+func (f *facadeImpl) handleStreamEvent(ev streaming.Event) {
+	f.logger.Infof("Facade: Stream event: %s", ev.Message)
+	f.wsManager.BroadcastJSON("event", ev)
+}
+
+// handleLevels forwards audio meter readings to WebSocket clients.
+func (f *facadeImpl) handleLevels(lv streaming.AudioLevels) {
+	f.wsManager.BroadcastJSON("levels", lv)
+}
+
+// StartStream initiates the stream named in opts with the selected profile and source.
+func (f *facadeImpl) StartStream(ctx context.Context, opts streaming.StartOptions) error {
+	f.logger.Infof("Facade: Starting stream %q (profile %q, source %q)", opts.Stream, opts.Profile, opts.Source)
+	err := f.streamer.StartStream(ctx, opts)
 	if err != nil {
 		f.logger.Errorf("Facade: Failed to start stream: %v", err)
 		return err
 	}
-	f.BroadcastMessage("Stream started")
+	if sess := f.CurrentSession(opts.Stream); sess != nil {
+		f.wsManager.BroadcastJSON("event", streaming.Event{
+			Type:    streaming.EventStarted,
+			Stream:  sess.Stream,
+			Session: sess.ID,
+			Message: fmt.Sprintf("Stream %s started", sess.Stream),
+		})
+	}
 	return nil
 }
 
-// StopStream terminates the streaming process.
-func (f *facadeImpl) StopStream() error {
-	f.logger.Info("Facade: Stopping stream")
-	err := f.streamer.StopStream()
+// StopStream terminates the named stream and reports how FFmpeg exited.
+func (f *facadeImpl) StopStream(name string) (streaming.StopResult, error) {
+	f.logger.Infof("Facade: Stopping stream %q", name)
+	result, err := f.streamer.StopStream(name)
 	if err != nil {
 		f.logger.Errorf("Facade: Failed to stop stream: %v", err)
-		return err
+		return result, err
 	}
-	f.BroadcastMessage("Stream stopped")
-	return nil
+	if result.Session != "" {
+		f.wsManager.BroadcastJSON("event", streaming.Event{
+			Type:    streaming.EventStopped,
+			Stream:  result.Stream,
+			Session: result.Session,
+			Message: result.Message(),
+		})
+	}
+	return result, nil
+}
+
+// IsStreaming checks if the named stream is active.
+func (f *facadeImpl) IsStreaming(name string) bool {
+	return f.streamer.IsStreaming(name)
+}
+
+// Streams returns the status of every known stream.
+func (f *facadeImpl) Streams() []streaming.StreamStatus {
+	return f.streamer.Streams()
 }
 
-// IsStreaming checks if streaming is active.
-func (f *facadeImpl) IsStreaming() bool {
-	return f.streamer.IsStreaming()
+// StreamStatus returns the status of the named stream.
+func (f *facadeImpl) StreamStatus(name string) (streaming.StreamStatus, error) {
+	return f.streamer.StreamStatus(name)
+}
+
+// CurrentSession returns the active session of the named stream, or nil when idle.
+func (f *facadeImpl) CurrentSession(name string) *streaming.StreamSession {
+	return f.streamer.CurrentSession(name)
+}
+
+// Sessions returns the current and past stream sessions, newest first.
+func (f *facadeImpl) Sessions() []streaming.StreamSession {
+	return f.streamer.Sessions()
+}
+
+// Session returns the stream session with the given ID.
+func (f *facadeImpl) Session(id string) (streaming.StreamSession, error) {
+	return f.streamer.Session(id)
+}
+
+// Profiles returns the stream profiles that can be selected when starting a stream.
+func (f *facadeImpl) Profiles() []streaming.StreamProfile {
+	return f.streamer.Profiles()
+}
+
+// Encoder reports which H.264 encoder the streamer selected.
+func (f *facadeImpl) Encoder() streaming.EncoderInfo {
+	return f.streamer.Encoder()
+}
+
+// Stats returns the latest encoder telemetry for the named stream.
+func (f *facadeImpl) Stats(name string) streaming.Stats {
+	return f.streamer.Stats(name)
+}
+
+// MonitorStats pushes encoder telemetry of every running stream to WebSocket clients.
+func (f *facadeImpl) MonitorStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, status := range f.Streams() {
+				if status.Streaming {
+					f.wsManager.BroadcastJSON("stats", f.Stats(status.Name))
+				}
+			}
+		}
+	}
+}
+
+// StartRecording starts recording a take from the named stream.
+func (f *facadeImpl) StartRecording(name string) (streaming.Recording, error) {
+	f.logger.Infof("Facade: Starting recording of stream %q", name)
+	take, err := f.streamer.StartRecording(name)
+	if err != nil {
+		f.logger.Errorf("Facade: Failed to start recording: %v", err)
+		return take, err
+	}
+	return take, nil
+}
+
+// StopRecording stops the current take of the named stream; the file is
+// announced once it is written.
+func (f *facadeImpl) StopRecording(name string) (streaming.Recording, error) {
+	f.logger.Infof("Facade: Stopping recording of stream %q", name)
+	take, err := f.streamer.StopRecording(name)
+	if err != nil {
+		f.logger.Errorf("Facade: Failed to stop recording: %v", err)
+		return take, err
+	}
+	return take, nil
+}
+
+// Replay saves the last seconds of the named stream as a clip; the file is
+// announced once it is written.
+func (f *facadeImpl) Replay(name string, seconds int) (streaming.Replay, error) {
+	f.logger.Infof("Facade: Saving the last %d seconds of stream %q", seconds, name)
+	clip, err := f.streamer.Replay(name, seconds)
+	if err != nil {
+		f.logger.Errorf("Facade: Failed to save replay: %v", err)
+		return clip, err
+	}
+	return clip, nil
+}
+
+// Recording returns the take in progress on the named stream, or nil.
+func (f *facadeImpl) Recording(name string) *streaming.Recording {
+	return f.streamer.Recording(name)
+}
+
+// AwaitPlaylist blocks until the stream's LL-HLS playlist reaches the requested segment or part.
+func (f *facadeImpl) AwaitPlaylist(ctx context.Context, name string, msn, part int) error {
+	return f.streamer.AwaitPlaylist(ctx, name, msn, part)
+}
+
+// AwaitPart blocks until the hinted LL-HLS part of the stream is available.
+func (f *facadeImpl) AwaitPart(ctx context.Context, name, uri string) error {
+	return f.streamer.AwaitPart(ctx, name, uri)
+}
+
+// Destinations returns the restream destinations of the named stream.
+func (f *facadeImpl) Destinations(name string) ([]streaming.DestinationStatus, error) {
+	return f.streamer.Destinations(name)
+}
+
+// AddDestination adds a restream destination to the named stream.
+func (f *facadeImpl) AddDestination(name string, d streaming.Destination) (streaming.DestinationStatus, error) {
+	f.logger.Infof("Facade: Adding restream destination %q to stream %q", d.Name, name)
+	status, err := f.streamer.AddDestination(name, d)
+	if err != nil {
+		f.logger.Errorf("Facade: Failed to add destination: %v", err)
+		return status, err
+	}
+	return status, nil
+}
+
+// SetDestinationEnabled enables or disables a restream destination of the named stream.
+func (f *facadeImpl) SetDestinationEnabled(name, id string, enabled bool) (streaming.DestinationStatus, error) {
+	f.logger.Infof("Facade: Setting destination %s of stream %q enabled=%t", id, name, enabled)
+	return f.streamer.SetDestinationEnabled(name, id, enabled)
+}
+
+// RemoveDestination removes a restream destination from the named stream.
+func (f *facadeImpl) RemoveDestination(name, id string) error {
+	f.logger.Infof("Facade: Removing destination %s from stream %q", id, name)
+	return f.streamer.RemoveDestination(name, id)
+}
+
+// Snapshot returns a JPEG of the latest frame of the named stream.
+func (f *facadeImpl) Snapshot(ctx context.Context, name string, width int) ([]byte, error) {
+	return f.streamer.Snapshot(ctx, name, width)
+}
+
+// Poster returns the latest poster image of the named stream.
+func (f *facadeImpl) Poster(name string) ([]byte, error) {
+	return f.streamer.Poster(name)
+}
+
+// SubscribePreview returns the MJPEG viewfinder frames of the named stream.
+func (f *facadeImpl) SubscribePreview(name string) (<-chan []byte, func(), error) {
+	return f.streamer.SubscribePreview(name)
+}
+
+// Overlays returns the overlays of the named profile.
+func (f *facadeImpl) Overlays(profile string) ([]streaming.Overlay, error) {
+	return f.streamer.Overlays(profile)
+}
+
+// SetOverlays replaces the overlays of the named profile.
+func (f *facadeImpl) SetOverlays(profile string, overlays []streaming.Overlay) ([]streaming.Overlay, error) {
+	return f.streamer.SetOverlays(profile, overlays)
+}
+
+// Levels returns the latest audio meter reading of the named stream.
+func (f *facadeImpl) Levels(name string) (streaming.AudioLevels, error) {
+	return f.streamer.Levels(name)
+}
+
+// Key returns the HLS encryption key named <stream>/<session>/<n>.key.
+func (f *facadeImpl) Key(name string) ([]byte, error) {
+	return f.streamer.Key(name)
+}
+
+// OpenWHEP creates a WebRTC viewer of the named stream from a WHEP offer.
+func (f *facadeImpl) OpenWHEP(ctx context.Context, name, offer string) (streaming.WHEPSession, error) {
+	return f.streamer.OpenWHEP(ctx, name, offer)
+}
+
+// CloseWHEP disconnects a WebRTC viewer of the named stream.
+func (f *facadeImpl) CloseWHEP(name, id string) error {
+	return f.streamer.CloseWHEP(name, id)
 }
 
 // ListVideos retrieves the list of available videos.
-func (f *facadeImpl) ListVideos() ([]string, error) {
+func (f *facadeImpl) ListVideos() ([]videomanager.VideoInfo, error) {
 	f.logger.Info("Facade: Listing videos")
 	return f.videoManager.ListVideos()
 }
 
 // ServeVideo streams the specified video to the client.
-func (f *facadeImpl) ServeVideo(filename string, w http.ResponseWriter) error {
+func (f *facadeImpl) ServeVideo(filename string, w http.ResponseWriter, r *http.Request) error {
 	f.logger.Infof("Facade: Serving video %s", filename)
-	return f.videoManager.ServeVideo(filename, w)
+	return f.videoManager.ServeVideo(filename, w, r)
+}
+
+// Devices lists the video and audio capture devices of the machine.
+func (f *facadeImpl) Devices() (devices.Inventory, error) {
+	f.logger.Info("Facade: Discovering capture devices")
+	return f.discoverer.Discover()
 }
 
 // BroadcastMessage sends a message to all connected WebSocket clients.
@@ -101,18 +353,30 @@ func (f *facadeImpl) InitGPIO() error {
 	return f.gpioManager.Init()
 }
 
-// MonitorGPIO starts monitoring the GPIO button for interactions.
+// MonitorGPIO starts monitoring the GPIO button, which starts and stops
+// takes of the default stream, starting the stream first when it is idle.
 func (f *facadeImpl) MonitorGPIO(ctx context.Context) {
 	f.logger.Info("Facade: Starting GPIO monitoring")
 	f.gpioManager.MonitorButton(ctx, func() {
-		if f.IsStreaming() {
-			if err := f.StopStream(); err != nil {
-				f.logger.Errorf("Facade: Error stopping stream via GPIO: %v", err)
-			}
-		} else {
-			if err := f.StartStream(ctx); err != nil {
-				f.logger.Errorf("Facade: Error starting stream via GPIO: %v", err)
-			}
-		}
+		f.toggleTake(ctx, streaming.DefaultStreamName)
 	})
-}
\ No newline at end of file
+}
+
+// toggleTake stops the take of the named stream, or starts one.
+func (f *facadeImpl) toggleTake(ctx context.Context, name string) {
+	if f.Recording(name) != nil {
+		if _, err := f.StopRecording(name); err != nil {
+			f.logger.Errorf("Facade: Error stopping recording via GPIO: %v", err)
+		}
+		return
+	}
+	if !f.IsStreaming(name) {
+		if err := f.StartStream(ctx, streaming.StartOptions{Stream: name}); err != nil {
+			f.logger.Errorf("Facade: Error starting stream via GPIO: %v", err)
+			return
+		}
+	}
+	if _, err := f.StartRecording(name); err != nil {
+		f.logger.Errorf("Facade: Error starting recording via GPIO: %v", err)
+	}
+}
@@ -82,4 +82,4 @@ func (g *GPIOManagerImpl) Close() error {
 	rpio.Close()
 	g.logger.Info("GPIO resources closed")
 	return nil
-}
\ No newline at end of file
+}
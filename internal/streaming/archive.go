@@ -0,0 +1,263 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ArchiveMetadata is the name of the metadata file of an archived session,
+	// written last so that only complete archives carry one.
+	ArchiveMetadata = "session.json"
+	// archiveRemuxTimeout bounds the remux of an archived session to MP4.
+	archiveRemuxTimeout = 30 * time.Minute
+)
+
+// SessionArchive describes a finished session archived as VOD.
+type SessionArchive struct {
+	Session    StreamSession `json:"session"`
+	Playlist   string        `json:"playlist"`       // VOD playlist, relative to the archive directory
+	File       string        `json:"file,omitempty"` // MP4 in the recording directory, when remuxed
+	Duration   float64       `json:"duration"`       // Seconds of media in the archive
+	Segments   int           `json:"segments"`
+	ArchivedAt time.Time     `json:"archived_at"`
+	// Complete is set when the archive covers the whole session. Without a
+	// DVR window only the segments still in the live playlist when the
+	// session ended are archived, and a DVR window keeps only its last
+	// DVRWindow or DVRMaxSize of a longer session.
+	Complete bool `json:"complete"`
+}
+
+// archiveJob is a session whose segments wait in a staging directory to be
+// archived.
+type archiveJob struct {
+	session  StreamSession
+	staging  string
+	segments []PlaylistSegment // URIs relative to staging
+	mapURI   string            // Initialization segment of fMP4 output, relative to staging
+	complete bool              // The segments cover the whole session
+}
+
+// stageArchive archives the segments of st's ended session in the
+// background. dvr is the session's DVR, if any, whose segments are archived
+// instead of the live playlist's. The segments are first moved into a
+// staging directory, without holding the mutex; the stream does not start
+// again before they are. Callers must hold the mutex.
+func (s *FFmpegStreamer) stageArchive(st *stream, sess *StreamSession, dvr *dvrArchive) {
+	if s.archiveDir == "" {
+		return
+	}
+
+	dir, segments, mapURI, complete := st.profile.archiveSegments(st.hlsDir, sess, dvr)
+	if len(segments) == 0 {
+		s.logger.Infof("Session %s has no segments to archive", sess.ID)
+		return
+	}
+	if !complete {
+		s.logger.Warnf("Archiving only the last %d segments of session %s; keep a DVR window to archive whole sessions", len(segments), sess.ID)
+	}
+	// Stage next to the HLS directory, on the same file system, so that the
+	// segments are usually renamed rather than copied.
+	job := &archiveJob{session: *sess, staging: filepath.Join(s.hlsDir, ".archive-"+sess.ID), complete: complete}
+	staged := make(chan struct{})
+	st.staging = staged
+	go func() {
+		err := s.stage(job, dir, segments, mapURI)
+		close(staged)
+		if err != nil {
+			s.logger.Errorf("Failed to stage archive of session %s: %v", sess.ID, err)
+			os.RemoveAll(job.staging)
+			return
+		}
+		s.writeArchive(job)
+	}()
+}
+
+// stage moves the segments in dir, and the initialization segment mapURI,
+// into the staging directory of job.
+func (s *FFmpegStreamer) stage(job *archiveJob, dir string, segments []PlaylistSegment, mapURI string) error {
+	if err := os.MkdirAll(job.staging, 0755); err != nil {
+		return err
+	}
+	if mapURI != "" {
+		job.mapURI = filepath.Base(mapURI)
+		if err := moveFile(filepath.Join(dir, mapURI), filepath.Join(job.staging, job.mapURI)); err != nil {
+			return err
+		}
+	}
+	for _, seg := range segments {
+		name := filepath.Base(seg.URI)
+		if err := moveFile(filepath.Join(dir, seg.URI), filepath.Join(job.staging, name)); err != nil {
+			s.logger.Warnf("Failed to stage segment %s of session %s: %v", seg.URI, job.session.ID, err)
+			job.complete = false
+			continue
+		}
+		seg.URI = name
+		job.segments = append(job.segments, seg)
+	}
+	return nil
+}
+
+// archiveSegments returns the directory and segments of p's output in
+// hlsDir to archive for sess, with the initialization segment of fMP4
+// output, and whether they cover the whole session. fMP4 segments are only
+// archived since the last discontinuity, where the initialization segment
+// changes.
+func (p StreamProfile) archiveSegments(hlsDir string, sess *StreamSession, dvr *dvrArchive) (string, []PlaylistSegment, string, bool) {
+	if dvr != nil {
+		dir, segments, complete := dvr.release()
+		return dir, segments, "", complete
+	}
+	playlistPath := p.restreamInput(hlsDir)
+	pl, err := readMediaPlaylist(playlistPath)
+	if err != nil {
+		return "", nil, "", false
+	}
+	segments := pl.Segments
+	if pl.MapURI != "" {
+		for i := len(segments) - 1; i > 0; i-- {
+			if segments[i].Discontinuity {
+				segments = segments[i:]
+				break
+			}
+		}
+	}
+	// Every FFmpeg run rewrites the live playlist from its first segment
+	complete := sess.Restarts == 0 && len(segments) > 0 && segments[0].Sequence == 0
+	return filepath.Dir(playlistPath), segments, pl.MapURI, complete
+}
+
+// writeArchive moves a staged session into the archive directory, writes
+// its VOD playlist and MP4, and announces the result.
+func (s *FFmpegStreamer) writeArchive(job *archiveJob) {
+	defer os.RemoveAll(job.staging)
+
+	archive, err := s.archive(job)
+	if err != nil {
+		s.logger.Errorf("Failed to archive session %s: %v", job.session.ID, err)
+		s.emit(Event{Type: EventArchiveFailed, Stream: job.session.Stream, Session: job.session.ID, Message: fmt.Sprintf("Failed to archive session %s: %v", job.session.ID, err), Error: err.Error()})
+		return
+	}
+	s.logger.Infof("Session %s archived to %s", job.session.ID, filepath.Join(s.archiveDir, archive.Playlist))
+	s.emit(Event{Type: EventSessionArchived, Stream: job.session.Stream, Session: job.session.ID, Message: fmt.Sprintf("Session %s archived (%.0f s)", job.session.ID, archive.Duration)})
+}
+
+// archive writes the archive of job and returns its metadata.
+func (s *FFmpegStreamer) archive(job *archiveJob) (SessionArchive, error) {
+	dir := filepath.Join(s.archiveDir, job.session.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return SessionArchive{}, err
+	}
+	archive := SessionArchive{
+		Session:  job.session,
+		Playlist: filepath.ToSlash(filepath.Join(job.session.ID, LivePlaylist)),
+		Segments: len(job.segments),
+		Complete: job.complete,
+	}
+
+	files := make([]string, 0, len(job.segments)+1)
+	if job.mapURI != "" {
+		files = append(files, job.mapURI)
+	}
+	for _, seg := range job.segments {
+		files = append(files, seg.URI)
+		archive.Duration += seg.Duration
+	}
+	for _, name := range files {
+		if err := moveFile(filepath.Join(job.staging, name), filepath.Join(dir, name)); err != nil {
+			return SessionArchive{}, err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(dir, LivePlaylist), vodPlaylist(job.segments, job.mapURI)); err != nil {
+		return SessionArchive{}, err
+	}
+
+	if s.archiveMP4 && s.recordingDir != "" {
+		file := filepath.Join(s.recordingDir, fmt.Sprintf("session-%s-%s.mp4", job.session.Stream, job.session.ID))
+		ctx, cancel := context.WithTimeout(context.Background(), archiveRemuxTimeout)
+		err := s.remuxSegments(ctx, dir, job.segments, job.mapURI, file)
+		cancel()
+		if err != nil {
+			// The VOD playlist is still worth keeping without its MP4
+			s.logger.Errorf("Failed to remux session %s to MP4: %v", job.session.ID, err)
+		} else {
+			archive.File = filepath.Base(file)
+		}
+	}
+
+	archive.ArchivedAt = time.Now()
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return SessionArchive{}, err
+	}
+	return archive, writeFileAtomic(filepath.Join(dir, ArchiveMetadata), data)
+}
+
+// vodPlaylist renders the closed VOD playlist of an archived session.
+func vodPlaylist(segments []PlaylistSegment, mapURI string) []byte {
+	var b strings.Builder
+	target := 1
+	for _, seg := range segments {
+		if d := int(math.Ceil(seg.Duration)); d > target {
+			target = d
+		}
+	}
+	version := 3
+	if mapURI != "" {
+		version = 7
+	}
+
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	if mapURI != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", mapURI)
+	}
+	key := ""
+	for i, seg := range segments {
+		if seg.Discontinuity && i > 0 {
+			fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.Key != key {
+			fmt.Fprintf(&b, "%s\n", keyTag(seg.Key))
+			key = seg.Key
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", seg.Duration, seg.URI)
+	}
+	fmt.Fprintf(&b, "#EXT-X-ENDLIST\n")
+	return []byte(b.String())
+}
+
+// moveFile renames src to dst, copying it when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
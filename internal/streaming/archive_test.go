@@ -0,0 +1,199 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePlaylist writes a live media playlist of n segments starting at
+// sequence first into dir.
+func writePlaylist(t *testing.T, dir string, first, n int) {
+	t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	for i := first; i < first+n; i++ {
+		fmt.Fprintf(&b, "#EXTINF:2.000000,\nlive%d.ts\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, LivePlaylist), []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveSegmentsWithoutDVR(t *testing.T) {
+	tests := []struct {
+		name         string
+		first        int
+		restarts     int
+		wantComplete bool
+	}{
+		{"whole session in the playlist", 0, 0, true},
+		{"older segments deleted", 12, 0, false},
+		{"earlier runs overwritten", 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePlaylist(t, dir, tt.first, 5)
+			sess := newSession("main", DefaultProfileName, "libx264")
+			sess.Restarts = tt.restarts
+
+			got, segments, _, complete := DefaultProfile().archiveSegments(dir, sess, nil)
+			if got != dir || len(segments) != 5 {
+				t.Fatalf("archiveSegments() = %s with %d segments, want %s with 5", got, len(segments), dir)
+			}
+			if complete != tt.wantComplete {
+				t.Errorf("archiveSegments() complete = %v, want %v", complete, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestArchiveRecordsPartialSessions(t *testing.T) {
+	cfg := fakeConfig(t, DefaultProfile())
+	cfg.ArchiveDir = t.TempDir()
+	s := NewFFmpegStreamer(cfg, testLogger())
+
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	sess := s.CurrentSession("")
+	// The fake keeps five segments in its live playlist
+	time.Sleep(10 * fakeSegmentInterval)
+	if _, err := s.StopStream(""); err != nil {
+		t.Fatalf("StopStream() error = %v", err)
+	}
+	// Starting again waits for the segments of the last session to be staged
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	metadata := filepath.Join(cfg.ArchiveDir, sess.ID, ArchiveMetadata)
+	waitFor(t, 5*time.Second, "the archive metadata", func() bool {
+		_, err := os.Stat(metadata)
+		return err == nil
+	})
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive SessionArchive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Complete {
+		t.Errorf("archive of the live playlist window is marked complete")
+	}
+	if archive.Segments != 5 {
+		t.Errorf("archive has %d segments, want 5", archive.Segments)
+	}
+	pl, err := readMediaPlaylist(filepath.Join(cfg.ArchiveDir, archive.Playlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range pl.Segments {
+		if _, err := os.Stat(filepath.Join(cfg.ArchiveDir, sess.ID, seg.URI)); err != nil {
+			t.Errorf("archived segment %s: %v", seg.URI, err)
+		}
+	}
+}
+
+func TestVODPlaylist(t *testing.T) {
+	key1 := `METHOD=AES-128,URI="/keys/main/s1/1.key",IV=0x01`
+	key2 := `METHOD=AES-128,URI="/keys/main/s1/2.key",IV=0x02`
+	tests := []struct {
+		name     string
+		segments []PlaylistSegment
+		mapURI   string
+		want     string
+	}{
+		{
+			name:     "MPEG-TS",
+			segments: []PlaylistSegment{{URI: "live0.ts", Duration: 4}, {URI: "live1.ts", Duration: 4.2}},
+			want: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:4.000000,
+live0.ts
+#EXTINF:4.200000,
+live1.ts
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			name:     "fMP4 across a restart",
+			segments: []PlaylistSegment{{URI: "seg0.m4s", Duration: 2, Discontinuity: true}, {URI: "seg1.m4s", Duration: 2, Discontinuity: true}},
+			mapURI:   "init.mp4",
+			want: `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.000000,
+seg0.m4s
+#EXT-X-DISCONTINUITY
+#EXTINF:2.000000,
+seg1.m4s
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			name: "rotating keys",
+			segments: []PlaylistSegment{
+				{URI: "live0.ts", Duration: 4, Key: key1},
+				{URI: "live1.ts", Duration: 4, Key: key1},
+				{URI: "live2.ts", Duration: 4, Key: key2},
+				{URI: "live3.ts", Duration: 4},
+			},
+			want: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-KEY:` + key1 + `
+#EXTINF:4.000000,
+live0.ts
+#EXTINF:4.000000,
+live1.ts
+#EXT-X-KEY:` + key2 + `
+#EXTINF:4.000000,
+live2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.000000,
+live3.ts
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			name: "empty",
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-ENDLIST\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := vodPlaylist(tt.segments, tt.mapURI)
+			if string(got) != tt.want {
+				t.Errorf("vodPlaylist() =\n%s\nwant\n%s", got, tt.want)
+			}
+			// The playlist reads back as the archived segments
+			pl := parseMediaPlaylist(got)
+			if !pl.EndList || pl.MapURI != tt.mapURI || len(pl.Segments) != len(tt.segments) {
+				t.Fatalf("parsed playlist = %+v", pl)
+			}
+			for i, seg := range pl.Segments {
+				if seg.URI != tt.segments[i].URI || seg.Key != tt.segments[i].Key || seg.Sequence != i {
+					t.Errorf("segment %d parsed as %+v, want %+v", i, seg, tt.segments[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInvalidDestination is returned when a restream destination fails validation.
+	ErrInvalidDestination = errors.New("invalid destination")
+	// ErrDestinationNotFound is returned when a destination ID is unknown.
+	ErrDestinationNotFound = errors.New("destination not found")
+)
+
+// destinationSchemes lists the URL schemes a destination may use.
+var destinationSchemes = []string{"rtmp", "rtmps", "srt"}
+
+// Destination is an RTMP or SRT endpoint a stream is restreamed to. Key is
+// the stream key; it is stored but never returned by the API.
+type Destination struct {
+	ID      string `json:"id"`
+	Stream  string `json:"stream"`
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Key     string `json:"key,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// validate checks the destination's URL and fills in a default name.
+func (d *Destination) validate() error {
+	u, err := url.Parse(d.URL)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: invalid url: %v", ErrInvalidDestination, err)
+	case !containsString(destinationSchemes, u.Scheme):
+		return fmt.Errorf("%w: url scheme must be one of %s", ErrInvalidDestination, strings.Join(destinationSchemes, ", "))
+	case u.Host == "":
+		return fmt.Errorf("%w: url needs a host", ErrInvalidDestination)
+	}
+	if d.Name == "" {
+		d.Name = u.Host
+	}
+	return nil
+}
+
+// keyArgs returns the FFmpeg options that read the stream key of d from
+// keyFile, keeping the key off the command line where any local user could
+// read it. Loading option values from files needs FFmpeg 7.0 or later.
+func (d Destination) keyArgs(keyFile string) []string {
+	if d.Key == "" {
+		return nil
+	}
+	if d.format() == "mpegts" {
+		return []string{"-/streamid", keyFile}
+	}
+	return []string{"-/rtmp_playpath", keyFile}
+}
+
+// writeKey writes the stream key of d to a temporary file only its owner can
+// read and returns the file's path, or an empty path when d has no key.
+func (d Destination) writeKey() (string, error) {
+	if d.Key == "" {
+		return "", nil
+	}
+	f, err := os.CreateTemp("", "relay-key-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.WriteString(d.Key); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// removeKey removes a key file written by writeKey.
+func removeKey(keyFile string) {
+	if keyFile != "" {
+		os.Remove(keyFile)
+	}
+}
+
+// format returns the FFmpeg muxer for the destination.
+func (d Destination) format() string {
+	if strings.HasPrefix(d.URL, "srt:") {
+		return "mpegts"
+	}
+	return "flv"
+}
+
+// redacted returns a copy of the destination without its stream key.
+func (d Destination) redacted() Destination {
+	d.Key = ""
+	return d
+}
+
+// newDestinationID returns a random destination identifier.
+func newDestinationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "dst-" + hex.EncodeToString(b)
+}
+
+// destinationStore keeps the configured destinations, persisting them to a
+// file readable only by the server's user when a path is set.
+type destinationStore struct {
+	mutex        sync.Mutex
+	path         string
+	destinations []Destination
+}
+
+// loadDestinationStore reads the destinations stored at path. A missing file
+// yields an empty store; an empty path keeps destinations in memory only.
+func loadDestinationStore(path string) (*destinationStore, error) {
+	ds := &destinationStore{path: path}
+	if path == "" {
+		return ds, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ds.destinations); err != nil {
+		return nil, fmt.Errorf("failed to parse destinations file %s: %w", path, err)
+	}
+	return ds, nil
+}
+
+// list returns the destinations of stream, or of every stream when stream is empty.
+func (ds *destinationStore) list(stream string) []Destination {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	var out []Destination
+	for _, d := range ds.destinations {
+		if stream == "" || d.Stream == stream {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// get returns the destination with the given ID on stream.
+func (ds *destinationStore) get(stream, id string) (Destination, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	for _, d := range ds.destinations {
+		if d.ID == id && d.Stream == stream {
+			return d, nil
+		}
+	}
+	return Destination{}, ErrDestinationNotFound
+}
+
+// add stores a new destination.
+func (ds *destinationStore) add(d Destination) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	ds.destinations = append(ds.destinations, d)
+	return ds.save()
+}
+
+// update applies change to the destination with the given ID on stream.
+func (ds *destinationStore) update(stream, id string, change func(*Destination)) (Destination, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	for i := range ds.destinations {
+		if d := &ds.destinations[i]; d.ID == id && d.Stream == stream {
+			change(d)
+			return *d, ds.save()
+		}
+	}
+	return Destination{}, ErrDestinationNotFound
+}
+
+// remove deletes the destination with the given ID on stream.
+func (ds *destinationStore) remove(stream, id string) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	for i, d := range ds.destinations {
+		if d.ID == id && d.Stream == stream {
+			ds.destinations = append(ds.destinations[:i], ds.destinations[i+1:]...)
+			return ds.save()
+		}
+	}
+	return ErrDestinationNotFound
+}
+
+// save writes the destinations with owner-only permissions, since the file
+// holds stream keys. Callers must hold the mutex.
+func (ds *destinationStore) save() error {
+	if ds.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ds.destinations, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(ds.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// CreateTemp opens the file with mode 0600
+	tmp, err := os.CreateTemp(dir, ".destinations-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ds.path)
+}
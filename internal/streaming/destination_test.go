@@ -0,0 +1,79 @@
+package streaming
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRelayArgsKeepKeyOffCommandLine(t *testing.T) {
+	const key = "live_1234_secret"
+	tests := []struct {
+		name    string
+		dest    Destination
+		wantKey string
+		wantURL string
+	}{
+		{"rtmp", Destination{URL: "rtmp://a.rtmp.youtube.com/live2", Key: key}, "-/rtmp_playpath", "rtmp://a.rtmp.youtube.com/live2"},
+		{"rtmps", Destination{URL: "rtmps://live-api-s.facebook.com:443/rtmp/", Key: key}, "-/rtmp_playpath", "rtmps://live-api-s.facebook.com:443/rtmp/"},
+		{"srt", Destination{URL: "srt://ingest.example.com:9000", Key: key}, "-/streamid", "srt://ingest.example.com:9000"},
+		{"no key", Destination{URL: "rtmp://ingest.example.com/live/cam"}, "", "rtmp://ingest.example.com/live/cam"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile, err := tt.dest.writeKey()
+			if err != nil {
+				t.Fatalf("writeKey() error = %v", err)
+			}
+			defer removeKey(keyFile)
+
+			args := relayArgs("/tmp/hls/live.m3u8", tt.dest, keyFile)
+			line := strings.Join(args, " ")
+			if strings.Contains(line, key) {
+				t.Fatalf("relayArgs() puts the stream key on the command line: %s", line)
+			}
+			if args[len(args)-1] != tt.wantURL {
+				t.Errorf("relayArgs() publishes to %q, want %q", args[len(args)-1], tt.wantURL)
+			}
+			if !strings.Contains(line, "-map 0:a:0?") {
+				t.Errorf("relayArgs() does not map the audio optionally: %s", line)
+			}
+			if tt.wantKey == "" {
+				if keyFile != "" {
+					t.Errorf("writeKey() = %q for a destination without a key", keyFile)
+				}
+				return
+			}
+			if !strings.Contains(line, tt.wantKey+" "+keyFile) {
+				t.Errorf("relayArgs() does not read the key with %s from %s: %s", tt.wantKey, keyFile, line)
+			}
+
+			info, err := os.Stat(keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("key file mode = %v, want 0600", mode)
+			}
+			data, err := os.ReadFile(keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != key {
+				t.Errorf("key file holds %q, want %q", data, key)
+			}
+		})
+	}
+}
+
+func TestRemoveKey(t *testing.T) {
+	keyFile, err := Destination{URL: "rtmp://ingest.example.com/live", Key: "secret"}.writeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeKey(keyFile)
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("key file still exists after removeKey: %v", err)
+	}
+	removeKey("")
+}
@@ -0,0 +1,323 @@
+package streaming
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DVRPlaylist is the name of the media playlist listing every retained
+	// segment, next to each live media playlist.
+	DVRPlaylist = "dvr.m3u8"
+	// DVRMasterPlaylist is the master playlist pointing at the DVR playlists.
+	DVRMasterPlaylist = "master_dvr.m3u8"
+	// dvrPollInterval is how often the live playlists are checked for new segments.
+	dvrPollInterval = time.Second
+	// dvrLiveMargin is how many segments beyond the live playlist are never
+	// pruned, since FFmpeg or a player may still read them.
+	dvrLiveMargin = 2
+)
+
+// dvrSegment is a segment retained in the DVR window.
+type dvrSegment struct {
+	PlaylistSegment
+	size  int64
+	added time.Time
+}
+
+// dvrRendition retains the segments of one live media playlist.
+type dvrRendition struct {
+	dir             string // Directory of the live media playlist
+	segments        []dvrSegment
+	lastSequence    int  // Media sequence of the newest retained segment
+	discontinuity   bool // Set when the next segment starts a new FFmpeg run
+	discontinuities int  // Discontinuity tags pruned from the playlist
+	target          int  // Target duration, which must not decrease
+	pruned          bool // Set once segments were pruned; the playlist is no longer an EVENT
+	ended           bool // Set when the session ended
+}
+
+// dvrArchive keeps the segments of a session's live playlists for a DVR
+// window and lists them in a playlist per rendition, so that viewers can
+// scrub back further than the live playlist reaches. FFmpeg numbers the
+// segments from the epoch, so media sequences keep increasing across runs.
+type dvrArchive struct {
+	mutex         sync.Mutex
+	hlsDir        string
+	renditions    []*dvrRendition
+	window        time.Duration // Maximum age of a retained segment
+	maxSize       int64         // Maximum size of all retained segments, 0 for no limit
+	keep          int           // Newest segments of a rendition that are never pruned
+	size          int64
+	masterWritten bool // Set once the DVR master playlist of the current run exists
+	closed        bool
+	logger        *logrus.Entry
+}
+
+// newDVRArchive prepares the DVR of profile p's output in hlsDir, removing
+// the segments and DVR playlists of earlier sessions.
+func newDVRArchive(hlsDir string, p StreamProfile, window time.Duration, maxSize int64, logger *logrus.Entry) (*dvrArchive, error) {
+	dirs := []string{hlsDir}
+	if len(p.Renditions) > 0 {
+		dirs = dirs[:0]
+		for _, r := range p.Renditions {
+			dirs = append(dirs, filepath.Join(hlsDir, r.Name))
+		}
+	}
+
+	d := &dvrArchive{
+		hlsDir:  hlsDir,
+		window:  window,
+		maxSize: maxSize,
+		keep:    p.HLSListSize + dvrLiveMargin,
+		logger:  logger,
+	}
+	os.Remove(filepath.Join(hlsDir, DVRMasterPlaylist))
+	for _, dir := range dirs {
+		stale, err := filepath.Glob(filepath.Join(dir, "*.ts"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range append(stale, filepath.Join(dir, DVRPlaylist)) {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		d.renditions = append(d.renditions, &dvrRendition{dir: dir, target: p.HLSTime})
+	}
+	return d, nil
+}
+
+// nextRun prepares the archive for a new FFmpeg run, whose segments follow
+// a discontinuity.
+func (d *dvrArchive) nextRun() {
+	d.collect()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, r := range d.renditions {
+		r.discontinuity = len(r.segments) > 0
+	}
+	d.masterWritten = false
+}
+
+// watch collects segments until the process exits.
+func (d *dvrArchive) watch(proc *process) {
+	ticker := time.NewTicker(dvrPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-proc.done:
+			d.collect()
+			return
+		case <-ticker.C:
+			d.collect()
+		}
+	}
+}
+
+// collect retains the segments FFmpeg listed since the last poll, prunes
+// the window and rewrites the DVR playlists that changed.
+func (d *dvrArchive) collect() {
+	playlists := make([]*MediaPlaylist, len(d.renditions))
+	for i, r := range d.renditions {
+		playlists[i], _ = readMediaPlaylist(filepath.Join(r.dir, LivePlaylist))
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return
+	}
+
+	now := time.Now()
+	changed := make([]bool, len(d.renditions))
+	for i, r := range d.renditions {
+		if pl := playlists[i]; pl != nil {
+			changed[i] = d.add(r, pl, now)
+		}
+	}
+	for i, r := range d.renditions {
+		if d.prune(r, now) {
+			changed[i] = true
+		}
+	}
+	for d.maxSize > 0 && d.size > d.maxSize {
+		i := d.oldest()
+		if i < 0 {
+			break
+		}
+		d.drop(d.renditions[i])
+		changed[i] = true
+	}
+
+	for i, r := range d.renditions {
+		if !changed[i] {
+			continue
+		}
+		if err := r.writePlaylist(); err != nil {
+			d.logger.Errorf("Failed to write DVR playlist in %s: %v", r.dir, err)
+		}
+	}
+	if !d.masterWritten {
+		d.masterWritten = d.writeMaster() == nil
+	}
+}
+
+// add retains the segments of pl newer than those of r and reports whether
+// there were any. Callers must hold the mutex.
+func (d *dvrArchive) add(r *dvrRendition, pl *MediaPlaylist, now time.Time) bool {
+	added := false
+	for _, seg := range pl.Segments {
+		if len(r.segments) > 0 && seg.Sequence <= r.lastSequence {
+			continue
+		}
+		var size int64
+		if info, err := os.Stat(filepath.Join(r.dir, seg.URI)); err == nil {
+			size = info.Size()
+		}
+		seg.Discontinuity = seg.Discontinuity || r.discontinuity
+		r.discontinuity = false
+		r.segments = append(r.segments, dvrSegment{PlaylistSegment: seg, size: size, added: now})
+		r.lastSequence = seg.Sequence
+		if t := int(math.Ceil(seg.Duration)); t > r.target {
+			r.target = t
+		}
+		d.size += size
+		added = true
+	}
+	return added
+}
+
+// prune drops the segments of r that are older than the window and reports
+// whether there were any. Callers must hold the mutex.
+func (d *dvrArchive) prune(r *dvrRendition, now time.Time) bool {
+	pruned := false
+	for len(r.segments) > d.keep && now.Sub(r.segments[0].added) > d.window {
+		d.drop(r)
+		pruned = true
+	}
+	return pruned
+}
+
+// oldest returns the index of the rendition whose oldest prunable segment
+// was retained first, or -1 when no segment can be pruned. Callers must hold
+// the mutex.
+func (d *dvrArchive) oldest() int {
+	oldest := -1
+	for i, r := range d.renditions {
+		if len(r.segments) > d.keep && (oldest < 0 || r.segments[0].added.Before(d.renditions[oldest].segments[0].added)) {
+			oldest = i
+		}
+	}
+	return oldest
+}
+
+// drop deletes the oldest segment of r. Callers must hold the mutex.
+func (d *dvrArchive) drop(r *dvrRendition) {
+	seg := r.segments[0]
+	if err := os.Remove(filepath.Join(r.dir, seg.URI)); err != nil && !os.IsNotExist(err) {
+		d.logger.Warnf("Failed to remove DVR segment %s: %v", seg.URI, err)
+	}
+	if seg.Discontinuity {
+		r.discontinuities++
+	}
+	r.segments = r.segments[1:]
+	r.pruned = true
+	d.size -= seg.size
+}
+
+// writePlaylist renders the DVR playlist of r. It is an EVENT playlist until
+// the first segment is pruned, and a live playlist with a long window after.
+func (r *dvrRendition) writePlaylist() error {
+	var b strings.Builder
+	first := 0
+	if len(r.segments) > 0 {
+		first = r.segments[0].Sequence
+	}
+
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", r.target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	if r.discontinuities > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", r.discontinuities)
+	}
+	if !r.pruned {
+		fmt.Fprintf(&b, "#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	key := ""
+	for _, seg := range r.segments {
+		if seg.Discontinuity {
+			fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.Key != key {
+			fmt.Fprintf(&b, "%s\n", keyTag(seg.Key))
+			key = seg.Key
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", seg.Duration, seg.URI)
+	}
+	if r.ended {
+		fmt.Fprintf(&b, "#EXT-X-ENDLIST\n")
+	}
+	return writeFileAtomic(filepath.Join(r.dir, DVRPlaylist), []byte(b.String()))
+}
+
+// writeMaster writes a copy of FFmpeg's master playlist pointing at the DVR
+// playlists. Callers must hold the mutex.
+func (d *dvrArchive) writeMaster() error {
+	data, err := os.ReadFile(filepath.Join(d.hlsDir, MasterPlaylist))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		uri := strings.TrimSpace(line)
+		if uri != "" && !strings.HasPrefix(uri, "#") && path.Base(uri) == LivePlaylist {
+			lines[i] = path.Join(path.Dir(uri), DVRPlaylist)
+		}
+	}
+	return writeFileAtomic(filepath.Join(d.hlsDir, DVRMasterPlaylist), []byte(strings.Join(lines, "\n")))
+}
+
+// close ends the DVR playlists once the session has ended. Unless the
+// session is archived, the segments stay until the stream starts again, so
+// the whole event can still be watched.
+func (d *dvrArchive) close() {
+	d.collect()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.closed = true
+	for _, r := range d.renditions {
+		r.ended = true
+		if err := r.writePlaylist(); err != nil {
+			d.logger.Errorf("Failed to end DVR playlist in %s: %v", r.dir, err)
+		}
+	}
+}
+
+// release hands the retained segments of the first rendition over to the
+// caller once the session has ended, returning their directory and whether
+// none were pruned, and removes the DVR playlists that list them.
+func (d *dvrArchive) release() (string, []PlaylistSegment, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	r := d.renditions[0]
+	segments := make([]PlaylistSegment, len(r.segments))
+	for i, seg := range r.segments {
+		segments[i] = seg.PlaylistSegment
+	}
+	r.segments = nil
+	for _, r := range d.renditions {
+		os.Remove(filepath.Join(r.dir, DVRPlaylist))
+	}
+	os.Remove(filepath.Join(d.hlsDir, DVRMasterPlaylist))
+	return d.renditions[0].dir, segments, !d.renditions[0].pruned
+}
@@ -0,0 +1,69 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTestSourceEndToEnd streams the test pattern through the FFmpeg on
+// PATH, which the fake stands in for in the other tests.
+func TestTestSourceEndToEnd(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg is not on PATH")
+	}
+	if testing.Short() {
+		t.Skip("skipping FFmpeg end-to-end test in short mode")
+	}
+
+	p := DefaultProfile()
+	p.Source = SourceTest
+	p.HLSTime = 1
+	cfg := Config{
+		HLSDir:         t.TempDir(),
+		PosterDir:      t.TempDir(),
+		Profiles:       []StreamProfile{p},
+		FFmpegPath:     ffmpeg,
+		StartupTimeout: 30 * time.Second,
+	}
+	s := NewFFmpegStreamer(cfg, testLogger())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	s.ProbeEncoders(ctx) // Falls back to libx264
+	cancel()
+
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	playlist := p.restreamInput(filepath.Join(cfg.HLSDir, DefaultStreamName))
+	waitFor(t, 30*time.Second, "three segments", func() bool {
+		pl, err := readMediaPlaylist(playlist)
+		return err == nil && len(pl.Segments) >= 3
+	})
+	if stats := s.Stats(""); stats.Frame == 0 {
+		t.Errorf("Stats() = %+v, want encoded frames", stats)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	jpeg, err := s.Snapshot(ctx, "", 320)
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if !bytes.HasPrefix(jpeg, []byte{0xFF, 0xD8}) {
+		t.Errorf("Snapshot() returned %d bytes that are not a JPEG", len(jpeg))
+	}
+
+	id := s.CurrentSession("").ID
+	if _, err := s.StopStream(""); err != nil {
+		t.Fatalf("StopStream() error = %v", err)
+	}
+	if sess, err := s.Session(id); err != nil || sess.State != SessionStopped {
+		t.Errorf("Session() after stop = %+v, %v, want a stopped session", sess, err)
+	}
+}
@@ -0,0 +1,204 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EncoderAuto lets the streamer pick the best probed H.264 encoder.
+const EncoderAuto = "auto"
+
+// DefaultEncoderChain lists H.264 encoders in order of preference.
+var DefaultEncoderChain = []string{"h264_v4l2m2m", "h264_omx", "h264_vaapi", "libx264"}
+
+// EncoderInfo reports the result of encoder probing.
+type EncoderInfo struct {
+	Selected  string   `json:"selected"`
+	Available []string `json:"available"`
+	Chain     []string `json:"chain"`
+	Probed    bool     `json:"probed"`
+}
+
+// encoderSpec translates profile settings into options for one encoder.
+type encoderSpec struct {
+	globalArgs   func(p StreamProfile) []string // placed before the inputs
+	videoFilters []string                       // appended to the video filter chain
+	args         func(p StreamProfile) []string
+}
+
+// encoderSpecs holds the option translation for every supported encoder.
+var encoderSpecs = map[string]encoderSpec{
+	"h264_v4l2m2m": {
+		args: func(p StreamProfile) []string {
+			return []string{
+				"-b:v", kbps(p.MaxrateKbps),
+				"-pix_fmt", "yuv420p",
+				"-g", strconv.Itoa(p.GOP),
+			}
+		},
+	},
+	"h264_omx": {
+		args: func(p StreamProfile) []string {
+			return []string{
+				"-b:v", kbps(p.MaxrateKbps),
+				"-zerocopy", "1",
+				"-pix_fmt", "yuv420p",
+				"-g", strconv.Itoa(p.GOP),
+			}
+		},
+	},
+	"h264_vaapi": {
+		globalArgs: func(p StreamProfile) []string {
+			return []string{"-vaapi_device", "/dev/dri/renderD128"}
+		},
+		videoFilters: []string{"format=nv12", "hwupload"},
+		args: func(p StreamProfile) []string {
+			return []string{
+				"-b:v", kbps(p.MaxrateKbps),
+				"-maxrate", kbps(p.MaxrateKbps),
+				"-bufsize", kbps(p.BufsizeKbps),
+				"-g", strconv.Itoa(p.GOP),
+			}
+		},
+	},
+	"libx264": {
+		args: func(p StreamProfile) []string {
+			preset := p.Preset
+			if preset == "" {
+				preset = "veryfast"
+			}
+			return []string{
+				"-preset", preset,
+				"-tune", "zerolatency",
+				"-maxrate", kbps(p.MaxrateKbps),
+				"-bufsize", kbps(p.BufsizeKbps),
+				"-pix_fmt", "yuv420p",
+				"-g", strconv.Itoa(p.GOP),
+			}
+		},
+	},
+}
+
+// isKnownEncoder reports whether name can be used as a profile encoder.
+func isKnownEncoder(name string) bool {
+	if name == "" || name == EncoderAuto {
+		return true
+	}
+	_, ok := encoderSpecs[name]
+	return ok
+}
+
+// knownEncoders returns the encoders of chain that have an option
+// translation, dropping the others with a warning.
+func knownEncoders(chain []string, logger *logrus.Entry) []string {
+	var known []string
+	for _, name := range chain {
+		if _, ok := encoderSpecs[name]; !ok {
+			logger.Warnf("Ignoring unsupported encoder %s in the encoder chain", name)
+			continue
+		}
+		known = append(known, name)
+	}
+	return known
+}
+
+// ProbeEncoders runs `ffmpeg -encoders` and returns the names of the encoders it lists.
+func ProbeEncoders(ctx context.Context, ffmpegPath string) (map[string]bool, error) {
+	out, err := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe ffmpeg encoders: %w", err)
+	}
+	return parseEncoderList(out), nil
+}
+
+// parseEncoderList extracts encoder names from `ffmpeg -encoders` output.
+func parseEncoderList(out []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	inList := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !inList {
+			// The encoder table starts after the " ------" separator line.
+			inList = strings.HasPrefix(line, "------")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// selectEncoder returns the first encoder in chain that is available.
+func selectEncoder(available map[string]bool, chain []string) (string, bool) {
+	for _, name := range chain {
+		if available[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ProbeEncoders probes FFmpeg for available encoders and selects the best one from the chain.
+// It should be called once at startup; without a successful probe the last encoder in the
+// chain is used.
+func (s *FFmpegStreamer) ProbeEncoders(ctx context.Context) error {
+	available, err := ProbeEncoders(ctx, s.ffmpegPath)
+	if err != nil {
+		s.logger.Errorf("Encoder probing failed: %v", err)
+		return err
+	}
+
+	selected, ok := selectEncoder(available, s.encoderChain)
+	if !ok {
+		err := fmt.Errorf("none of the H.264 encoders %v are available", s.encoderChain)
+		s.logger.Error(err)
+		return err
+	}
+
+	s.mutex.Lock()
+	s.availableEncoders = available
+	s.selectedEncoder = selected
+	s.mutex.Unlock()
+
+	s.logger.Infof("Selected H.264 encoder %s", selected)
+	return nil
+}
+
+// Encoder returns the encoder probing result.
+func (s *FFmpegStreamer) Encoder() EncoderInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	info := EncoderInfo{
+		Selected: s.selectedEncoder,
+		Chain:    append([]string(nil), s.encoderChain...),
+		Probed:   s.availableEncoders != nil,
+	}
+	for _, name := range s.encoderChain {
+		if s.availableEncoders[name] {
+			info.Available = append(info.Available, name)
+		}
+	}
+	return info
+}
+
+// resolveEncoder picks the encoder for a profile. Callers must hold the mutex.
+func (s *FFmpegStreamer) resolveEncoder(p StreamProfile) string {
+	if p.Encoder != "" && p.Encoder != EncoderAuto {
+		if s.availableEncoders == nil || s.availableEncoders[p.Encoder] {
+			return p.Encoder
+		}
+		s.logger.Warnf("Encoder %s requested by profile %q is not available, falling back to %s", p.Encoder, p.Name, s.selectedEncoder)
+	}
+	return s.selectedEncoder
+}
@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testLogger returns a logger that discards its output.
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+func TestParseEncoderList(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_v4l2m2m         V4L2 mem2mem H.264 encoder wrapper (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+`)
+	got := parseEncoderList(out)
+	want := map[string]bool{"libx264": true, "h264_v4l2m2m": true, "aac": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEncoderList() = %v, want %v", got, want)
+	}
+}
+
+func TestSelectEncoder(t *testing.T) {
+	tests := []struct {
+		name      string
+		available map[string]bool
+		chain     []string
+		want      string
+		wantOK    bool
+	}{
+		{"first available", map[string]bool{"libx264": true, "h264_omx": true}, DefaultEncoderChain, "h264_omx", true},
+		{"software fallback", map[string]bool{"libx264": true}, DefaultEncoderChain, "libx264", true},
+		{"none available", map[string]bool{"aac": true}, DefaultEncoderChain, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectEncoder(tt.available, tt.chain)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("selectEncoder() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKnownEncoders(t *testing.T) {
+	got := knownEncoders([]string{"h264_nvenc", "h264_vaapi", "libx264"}, testLogger())
+	want := []string{"h264_vaapi", "libx264"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("knownEncoders() = %v, want %v", got, want)
+	}
+}
+
+func TestNewFFmpegStreamerIgnoresUnknownEncoders(t *testing.T) {
+	s := NewFFmpegStreamer(Config{EncoderChain: []string{"h264_nvenc"}}, testLogger())
+	if !reflect.DeepEqual(s.encoderChain, DefaultEncoderChain) {
+		t.Errorf("encoder chain = %v, want %v", s.encoderChain, DefaultEncoderChain)
+	}
+	if s.selectedEncoder != "libx264" {
+		t.Errorf("selected encoder = %q, want libx264", s.selectedEncoder)
+	}
+}
@@ -0,0 +1,302 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultKeyRotation is how many segments share a key when
+	// Config.KeyRotation is zero.
+	DefaultKeyRotation = 10
+	// keyInfoFile names the current key for FFmpeg, which re-reads it before
+	// every segment.
+	keyInfoFile = "keyinfo"
+	// localPlaylist mirrors the live media playlist for FFmpeg readers on this
+	// machine, naming the key files instead of their URIs.
+	localPlaylist = "local.m3u8"
+	// keyPollInterval is how often the live playlist is checked for new segments.
+	keyPollInterval = 500 * time.Millisecond
+	// keySize is the size of an AES-128 key and IV.
+	keySize = 16
+)
+
+// ErrUnknownKey is returned when a requested encryption key does not exist.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// keyNamePattern matches the names of keys under the key directory:
+// <stream>/<session>/<n>.key.
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}/[A-Za-z0-9][A-Za-z0-9-]*/[0-9]+\.key$`)
+
+// keyRing encrypts the HLS output of a session with AES-128, switching to a
+// fresh key and IV every rotation segments. The keys live in a directory of
+// their own, outside the HLS directory, and playlists name them by a URI
+// under which the server hands them to authenticated viewers.
+type keyRing struct {
+	mutex    sync.Mutex
+	dir      string // Key directory of the session
+	uri      string // URI of the key directory in playlists
+	playlist string // Live media playlist whose segments are counted
+	rotation int
+	index    int // Number of the current key
+	last     int // Media sequence of the newest segment seen, -1 before the first
+	count    int // Segments seen since the current key was written
+	logger   *logrus.Entry
+}
+
+// newKeyRing creates the key directory of a session with its first key.
+func newKeyRing(dir, uri, playlist string, rotation int, logger *logrus.Entry) (*keyRing, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	kr := &keyRing{
+		dir:      dir,
+		uri:      uri,
+		playlist: playlist,
+		rotation: rotation,
+		last:     -1,
+		logger:   logger,
+	}
+	if err := kr.rotate(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// infoFile returns the key info file to pass to FFmpeg.
+func (kr *keyRing) infoFile() string {
+	return filepath.Join(kr.dir, keyInfoFile)
+}
+
+// localPlaylist returns the local copy of the live media playlist.
+func (kr *keyRing) localPlaylist() string {
+	return filepath.Join(kr.dir, localPlaylist)
+}
+
+// rotate writes a fresh key and IV and points the key info file at them.
+// Callers must hold the mutex unless the ring is not shared yet.
+func (kr *keyRing) rotate() error {
+	secret := make([]byte, 2*keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	key, iv := secret[:keySize], secret[keySize:]
+
+	name := fmt.Sprintf("%d.key", kr.index+1)
+	keyPath := filepath.Join(kr.dir, name)
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+	// The IV is written to the playlists, so that segments can be listed
+	// under other media sequence numbers by the DVR and archive playlists.
+	info := fmt.Sprintf("%s/%s\n%s\n%s\n", kr.uri, name, keyPath, hex.EncodeToString(iv))
+	if err := writeFileAtomic(kr.infoFile(), []byte(info)); err != nil {
+		return err
+	}
+	kr.index++
+	kr.count = 0
+	return nil
+}
+
+// watch rotates keys and refreshes the local playlist until the process exits.
+func (kr *keyRing) watch(proc *process) {
+	ticker := time.NewTicker(keyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-proc.done:
+			return
+		case <-ticker.C:
+			kr.collect()
+		}
+	}
+}
+
+// collect counts the segments FFmpeg listed since the last poll, rotating the
+// key once rotation segments used it, and copies the playlist for local
+// readers.
+func (kr *keyRing) collect() {
+	data, err := os.ReadFile(kr.playlist)
+	if err != nil {
+		return
+	}
+	pl := parseMediaPlaylist(data)
+
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if n := len(pl.Segments); n > 0 {
+		newest := pl.Segments[n-1].Sequence
+		if newest < kr.last {
+			// A restarted FFmpeg run numbers its segments from zero again
+			kr.last = -1
+		}
+		for _, seg := range pl.Segments {
+			if seg.Sequence > kr.last {
+				kr.count++
+			}
+		}
+		kr.last = newest
+	}
+	if kr.count >= kr.rotation {
+		if err := kr.rotate(); err != nil {
+			kr.logger.Errorf("Failed to rotate HLS key in %s: %v", kr.dir, err)
+		}
+	}
+	if err := writeFileAtomic(kr.localPlaylist(), kr.localCopy(data)); err != nil {
+		kr.logger.Warnf("Failed to copy playlist %s: %v", kr.playlist, err)
+	}
+}
+
+// localCopy rewrites the live playlist data so that it can be read from the
+// key directory: media URIs become absolute paths and key URIs the paths of
+// the key files.
+func (kr *keyRing) localCopy(data []byte) []byte {
+	dir := filepath.Dir(kr.playlist)
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := strings.TrimPrefix(line, "#EXT-X-KEY:")
+			if uri := attributeValue(attrs, "URI"); uri != "" {
+				lines[i] = "#EXT-X-KEY:" + setAttribute(attrs, "URI", filepath.Join(kr.dir, path.Base(uri)))
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := strings.TrimPrefix(line, "#EXT-X-MAP:")
+			lines[i] = "#EXT-X-MAP:" + setAttribute(attrs, "URI", filepath.Join(dir, attributeValue(attrs, "URI")))
+		case line != "" && !strings.HasPrefix(line, "#"):
+			lines[i] = filepath.Join(dir, line)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// newKeyRing prepares the encryption of a session of st, removing the keys
+// of its earlier sessions unless they were archived.
+func (s *FFmpegStreamer) newKeyRing(st *stream, session string) (*keyRing, error) {
+	s.pruneKeys(st.name, session)
+	return newKeyRing(
+		filepath.Join(s.keyDir, st.name, session),
+		s.keyURI+"/"+st.name+"/"+session,
+		st.profile.restreamInput(st.hlsDir),
+		s.keyRotation,
+		s.logger,
+	)
+}
+
+// pruneKeys removes the key directories of the named stream's sessions other
+// than keep, whose segments are gone. Archived sessions and sessions being
+// archived keep their keys.
+func (s *FFmpegStreamer) pruneKeys(name, keep string) {
+	entries, err := os.ReadDir(filepath.Join(s.keyDir, name))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() || id == keep {
+			continue
+		}
+		if s.archiveDir != "" {
+			if _, err := os.Stat(filepath.Join(s.archiveDir, id)); err == nil {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(s.hlsDir, ".archive-"+id)); err == nil {
+				continue
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(s.keyDir, name, id)); err != nil {
+			s.logger.Warnf("Failed to remove keys of session %s: %v", id, err)
+		}
+	}
+}
+
+// keyPath returns the file of the key named <stream>/<session>/<n>.key.
+func (s *FFmpegStreamer) keyPath(name string) (string, error) {
+	if s.keyDir == "" || !keyNamePattern.MatchString(name) {
+		return "", ErrUnknownKey
+	}
+	return filepath.Join(s.keyDir, filepath.FromSlash(name)), nil
+}
+
+// keyFile returns the file of the key a playlist names by uri.
+func (s *FFmpegStreamer) keyFile(uri string) (string, error) {
+	name, ok := strings.CutPrefix(uri, s.keyURI+"/")
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, uri)
+	}
+	return s.keyPath(name)
+}
+
+// Key returns the AES-128 key named <stream>/<session>/<n>.key, as listed in
+// the playlists of encrypted streams under the configured key URI.
+func (s *FFmpegStreamer) Key(name string) ([]byte, error) {
+	keyPath, err := s.keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownKey
+	}
+	return key, err
+}
+
+// localVOD writes a VOD playlist of segments in dir, with the initialization
+// segment mapURI of fMP4 output, that FFmpeg can read from anywhere on this
+// machine: media URIs are absolute and key URIs name the key files. The
+// caller removes the returned playlist.
+func (s *FFmpegStreamer) localVOD(dir string, segments []PlaylistSegment, mapURI string) (string, error) {
+	local := make([]PlaylistSegment, len(segments))
+	for i, seg := range segments {
+		seg.URI = filepath.Join(dir, seg.URI)
+		if seg.Key != "" {
+			keyPath, err := s.keyFile(attributeValue(seg.Key, "URI"))
+			if err != nil {
+				return "", err
+			}
+			seg.Key = setAttribute(seg.Key, "URI", keyPath)
+		}
+		local[i] = seg
+	}
+	if mapURI != "" {
+		mapURI = filepath.Join(dir, mapURI)
+	}
+
+	list, err := os.CreateTemp("", "multimedia-sys-*.m3u8")
+	if err != nil {
+		return "", err
+	}
+	if _, err := list.Write(vodPlaylist(local, mapURI)); err != nil {
+		list.Close()
+		os.Remove(list.Name())
+		return "", err
+	}
+	if err := list.Close(); err != nil {
+		os.Remove(list.Name())
+		return "", err
+	}
+	return list.Name(), nil
+}
+
+// encrypted reports whether any of segments is encrypted.
+func encrypted(segments []PlaylistSegment) bool {
+	for _, seg := range segments {
+		if seg.Key != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package streaming
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyRingCollect(t *testing.T) {
+	hlsDir := t.TempDir()
+	keyDir := filepath.Join(t.TempDir(), "main", "s1")
+	kr, err := newKeyRing(keyDir, "/keys/main/s1", filepath.Join(hlsDir, LivePlaylist), 3, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each step is the live playlist FFmpeg lists at a poll
+	steps := []struct {
+		name      string
+		first, n  int
+		wantKey   int
+		wantCount int
+	}{
+		{"first segments", 0, 2, 1, 2},
+		{"rotation reached", 0, 3, 2, 0},
+		{"window slides", 1, 4, 2, 2},
+		{"no new segment", 1, 4, 2, 2},
+		{"restarted run", 0, 1, 3, 0},
+		{"after the restart", 0, 2, 3, 1},
+	}
+	for _, step := range steps {
+		writePlaylist(t, hlsDir, step.first, step.n)
+		kr.collect()
+		if kr.index != step.wantKey || kr.count != step.wantCount {
+			t.Errorf("%s: key %d with %d segments, want key %d with %d", step.name, kr.index, kr.count, step.wantKey, step.wantCount)
+		}
+	}
+
+	for i := 1; i <= kr.index; i++ {
+		if info, err := os.Stat(filepath.Join(keyDir, fmt.Sprintf("%d.key", i))); err != nil || info.Size() != keySize {
+			t.Errorf("key %d: %v", i, err)
+		}
+	}
+	info, err := os.ReadFile(kr.infoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(string(info), "\n"); lines[0] != "/keys/main/s1/3.key" || lines[1] != filepath.Join(keyDir, "3.key") {
+		t.Errorf("key info = %q, want the URI and file of key 3", info)
+	}
+	local, err := readMediaPlaylist(kr.localPlaylist())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local.Segments) != 2 || local.Segments[0].URI != filepath.Join(hlsDir, "live0.ts") {
+		t.Errorf("local playlist segments = %+v, want the segments by path", local.Segments)
+	}
+}
+
+func TestKeyRingLocalCopy(t *testing.T) {
+	kr := &keyRing{dir: "/var/lib/keys/main/s1", playlist: "/tmp/hls/main/live.m3u8"}
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"segment", "live7.ts", "/tmp/hls/main/live7.ts"},
+		{"key", `#EXT-X-KEY:METHOD=AES-128,URI="/keys/main/s1/2.key",IV=0x0a`, `#EXT-X-KEY:METHOD=AES-128,URI="/var/lib/keys/main/s1/2.key",IV=0x0a`},
+		{"no key", "#EXT-X-KEY:METHOD=NONE", "#EXT-X-KEY:METHOD=NONE"},
+		{"init section", `#EXT-X-MAP:URI="init.mp4"`, `#EXT-X-MAP:URI="/tmp/hls/main/init.mp4"`},
+		{"tag", "#EXTINF:4.000000,", "#EXTINF:4.000000,"},
+		{"blank", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(kr.localCopy([]byte(tt.line))); got != tt.want {
+				t.Errorf("localCopy(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package streaming
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary stands in for FFmpeg when FAKE_FFMPEG is set, so that the
+// streamer can be run end to end without FFmpeg or capture hardware. The
+// fake writes an HLS playlist with a new segment every fakeSegmentInterval
+// until it reads "q" on stdin, and answers snapshot invocations with
+// fakeJPEG. It is steered by further variables:
+//
+//	FAKE_FFMPEG_LOG       file every invocation appends its arguments to
+//	FAKE_FFMPEG_NO_AUDIO  the network input has no audio track
+//	FAKE_FFMPEG_SENDER    file whose creation stands in for a sender connecting
+//	FAKE_FFMPEG_STALL     number of segments after which no more are written,
+//	                      unless the file FAKE_FFMPEG_STALL_ONCE exists, or
+//	                      until the file FAKE_FFMPEG_STALL_RESTARTS exists
+const fakeSegmentInterval = 100 * time.Millisecond
+
+// fakeJPEG is the image the fake FFmpeg writes for a snapshot.
+var fakeJPEG = []byte{0xFF, 0xD8, 'f', 'a', 'k', 'e', 0xFF, 0xD9}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("FAKE_FFMPEG") != "" {
+		os.Exit(fakeFFmpeg(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeConfig returns a streamer configuration running the fake FFmpeg in
+// a temporary HLS directory.
+func fakeConfig(t *testing.T, profiles ...StreamProfile) Config {
+	t.Helper()
+	t.Setenv("FAKE_FFMPEG", "1")
+	t.Setenv("FAKE_FFMPEG_LOG", filepath.Join(t.TempDir(), "invocations"))
+	// Race-enabled fakes would otherwise linger for a second after exiting
+	t.Setenv("GORACE", "atexit_sleep_ms=0")
+	return Config{
+		HLSDir:         t.TempDir(),
+		PosterDir:      t.TempDir(),
+		Profiles:       profiles,
+		FFmpegPath:     os.Args[0],
+		EncoderChain:   []string{"libx264"},
+		StartupTimeout: 5 * time.Second,
+	}
+}
+
+// fakeInvocations returns the argument lists the fake FFmpeg was run with.
+func fakeInvocations(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile(os.Getenv("FAKE_FFMPEG_LOG"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// waitFor polls cond until it holds or the timeout elapses.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// fakeFFmpeg runs the fake FFmpeg with args and returns its exit code.
+func fakeFFmpeg(args []string) int {
+	if path := os.Getenv("FAKE_FFMPEG_LOG"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintln(f, strings.Join(args, " "))
+			f.Close()
+		}
+	}
+	silence := false
+	for _, arg := range args {
+		if arg == "-sseof" {
+			os.Stdout.Write(fakeJPEG)
+			return 0
+		}
+		silence = silence || strings.HasPrefix(arg, "anullsrc")
+	}
+	if os.Getenv("FAKE_FFMPEG_NO_AUDIO") != "" && !silence {
+		for i, arg := range args {
+			if arg == "-map" && args[i+1] == "0:a:0" {
+				fmt.Fprintf(os.Stderr, "Stream map '0:a:0' matches no streams.\n")
+				return 1
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Output file #2 (pipe:5) does not contain any stream")
+		return 1
+	}
+
+	var master, playlist string
+	for i, arg := range args {
+		switch {
+		case arg == "-master_pl_name" && i+2 < len(args):
+			master, playlist = args[i+1], args[i+2]
+		case arg == "tee" && args[i-1] == "-f" && i+1 < len(args):
+			for _, slave := range parseTee(args[i+1]) {
+				if slave.options["f"] == "hls" && slave.options["master_pl_name"] != "" {
+					master, playlist = slave.options["master_pl_name"], slave.output
+				}
+			}
+		}
+	}
+	if playlist == "" {
+		fmt.Fprintln(os.Stderr, "no HLS output")
+		return 1
+	}
+
+	quit := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "q" {
+				break
+			}
+		}
+		close(quit)
+	}()
+
+	if sender := os.Getenv("FAKE_FFMPEG_SENDER"); sender != "" {
+		for {
+			if _, err := os.Stat(sender); err == nil {
+				break
+			}
+			select {
+			case <-quit:
+				return 0
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}
+
+	stallAfter := -1
+	if n, err := strconv.Atoi(os.Getenv("FAKE_FFMPEG_STALL")); err == nil {
+		stallAfter = n
+		if once := os.Getenv("FAKE_FFMPEG_STALL_ONCE"); once != "" {
+			if _, err := os.Stat(once); err == nil {
+				stallAfter = -1
+			} else {
+				os.WriteFile(once, nil, 0644)
+			}
+		}
+		if restarts := os.Getenv("FAKE_FFMPEG_STALL_RESTARTS"); restarts != "" {
+			if _, err := os.Stat(restarts); err != nil {
+				stallAfter = -1
+				os.WriteFile(restarts, nil, 0644)
+			}
+		}
+	}
+
+	dir := filepath.Dir(playlist)
+	base := strings.TrimSuffix(filepath.Base(playlist), ".m3u8")
+	ticker := time.NewTicker(fakeSegmentInterval)
+	defer ticker.Stop()
+	for seq := 0; ; seq++ {
+		select {
+		case <-quit:
+			return 0
+		case <-ticker.C:
+		}
+		if stallAfter >= 0 && seq >= stallAfter {
+			continue
+		}
+		segment := fmt.Sprintf("%s%d.ts", base, seq)
+		os.WriteFile(filepath.Join(dir, segment), []byte("segment"), 0644)
+		var b strings.Builder
+		first := seq - 4
+		if first < 0 {
+			first = 0
+		}
+		fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+		for i := first; i <= seq; i++ {
+			fmt.Fprintf(&b, "#EXTINF:0.100000,\n%s%d.ts\n", base, i)
+		}
+		writeFileAtomic(playlist, []byte(b.String()))
+		if seq == 0 {
+			writeFileAtomic(filepath.Join(dir, master), []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n"+filepath.Base(playlist)+"\n"))
+		}
+	}
+}
+
+// teeOutput is one slave of the tee muxer.
+type teeOutput struct {
+	options map[string]string
+	output  string
+}
+
+// parseTee parses the slave list of the tee muxer the way FFmpeg does: the
+// list is split on "|", then each slave's "[key=value:...]" options are
+// split on ":", each step removing one level of quoting and escaping.
+func parseTee(spec string) []teeOutput {
+	var slaves []teeOutput
+	for _, slave := range splitUnquoted(spec, "|") {
+		out := teeOutput{options: make(map[string]string), output: slave}
+		if strings.HasPrefix(slave, "[") {
+			end := closingBracket(slave)
+			for _, option := range splitUnquoted(slave[1:end], ":") {
+				key, value, _ := strings.Cut(option, "=")
+				out.options[key] = value
+			}
+			out.output = slave[end+1:]
+		}
+		slaves = append(slaves, out)
+	}
+	return slaves
+}
+
+// splitUnquoted splits s on the unquoted, unescaped characters of seps,
+// removing the quotes and escapes.
+func splitUnquoted(s, seps string) []string {
+	var parts []string
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\'':
+			quoted = false
+		case quoted:
+			b.WriteByte(c)
+		case c == '\'':
+			quoted = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case strings.IndexByte(seps, c) >= 0:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String())
+}
+
+// closingBracket returns the index of the "]" ending the options of a
+// slave, skipping quoted and escaped characters.
+func closingBracket(slave string) int {
+	quoted := false
+	for i := 1; i < len(slave); i++ {
+		switch c := slave[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '\\':
+			i++
+		case c == ']':
+			return i
+		}
+	}
+	return len(slave) - 1
+}
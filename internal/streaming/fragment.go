@@ -0,0 +1,198 @@
+package streaming
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Flags of the fMP4 boxes read by fragmentIndependent, from ISO/IEC 14496-12.
+const (
+	tfhdBaseDataOffset    = 0x000001
+	tfhdSampleDescription = 0x000002
+	tfhdDefaultDuration   = 0x000008
+	tfhdDefaultSize       = 0x000010
+	tfhdDefaultFlags      = 0x000020
+	trunDataOffset        = 0x000001
+	trunFirstSampleFlags  = 0x000004
+	trunSampleDuration    = 0x000100
+	trunSampleSize        = 0x000200
+	trunSampleFlags       = 0x000400
+	sampleIsNonSyncSample = 0x00010000
+)
+
+// maxFragmentHeaderLength bounds the moof boxes fragmentIndependent reads.
+const maxFragmentHeaderLength = 1 << 20
+
+// errNoFragment is returned for files without a movie fragment.
+var errNoFragment = errors.New("no movie fragment found")
+
+// fragmentIndependent reports whether the fMP4 fragment at path can be
+// decoded on its own, i.e. the first sample of every track fragment is a
+// sync sample. Audio samples always are, so this tells whether the video of
+// the fragment starts on a keyframe.
+func fragmentIndependent(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	for {
+		kind, body, err := nextBox(f)
+		if err == io.EOF {
+			return false, errNoFragment
+		}
+		if err != nil {
+			return false, err
+		}
+		if kind != "moof" {
+			continue
+		}
+		data := make([]byte, body)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return false, err
+		}
+		return moofIndependent(data)
+	}
+}
+
+// nextBox reads the header of the next box of r, skipping the bodies of
+// the boxes other than moof, and returns its type and body length.
+func nextBox(r io.ReadSeeker) (string, int64, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header))
+	kind := string(header[4:8])
+	headerLen := int64(8)
+	if size == 1 {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(header))
+		headerLen = 16
+	}
+	if size == 0 {
+		// The box extends to the end of the file, which no moof does
+		return "", 0, io.EOF
+	}
+	if size < headerLen {
+		return "", 0, errors.New("invalid box size")
+	}
+	body := size - headerLen
+	if kind == "moof" {
+		if body > maxFragmentHeaderLength {
+			return "", 0, errors.New("movie fragment header too large")
+		}
+		return kind, body, nil
+	}
+	if _, err := r.Seek(body, io.SeekCurrent); err != nil {
+		return "", 0, err
+	}
+	return kind, body, nil
+}
+
+// moofIndependent reports whether every track fragment in the body of a
+// moof box starts with a sync sample.
+func moofIndependent(moof []byte) (bool, error) {
+	found := false
+	for _, traf := range childBoxes(moof, "traf") {
+		var defaultFlags uint32
+		hasDefault := false
+		for _, tfhd := range childBoxes(traf, "tfhd") {
+			defaultFlags, hasDefault = tfhdDefaultSampleFlags(tfhd)
+		}
+		for _, trun := range childBoxes(traf, "trun") {
+			flags, ok := trunFirstFlags(trun)
+			if !ok {
+				flags, ok = defaultFlags, hasDefault
+			}
+			if ok && flags&sampleIsNonSyncSample != 0 {
+				return false, nil
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false, errNoFragment
+	}
+	return true, nil
+}
+
+// childBoxes returns the bodies of the boxes of type kind in data.
+func childBoxes(data []byte, kind string) [][]byte {
+	var boxes [][]byte
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+		if string(data[4:8]) == kind {
+			boxes = append(boxes, data[8:size])
+		}
+		data = data[size:]
+	}
+	return boxes
+}
+
+// tfhdDefaultSampleFlags returns the default sample flags of a tfhd box body.
+func tfhdDefaultSampleFlags(tfhd []byte) (uint32, bool) {
+	if len(tfhd) < 8 {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint32(tfhd) & 0xffffff
+	if flags&tfhdDefaultFlags == 0 {
+		return 0, false
+	}
+	offset := 8 // Version, flags and track ID
+	if flags&tfhdBaseDataOffset != 0 {
+		offset += 8
+	}
+	for _, field := range []uint32{tfhdSampleDescription, tfhdDefaultDuration, tfhdDefaultSize} {
+		if flags&field != 0 {
+			offset += 4
+		}
+	}
+	if len(tfhd) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(tfhd[offset:]), true
+}
+
+// trunFirstFlags returns the flags of the first sample of a trun box body
+// when the box lists them.
+func trunFirstFlags(trun []byte) (uint32, bool) {
+	if len(trun) < 8 {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint32(trun) & 0xffffff
+	if binary.BigEndian.Uint32(trun[4:]) == 0 {
+		return 0, false
+	}
+	offset := 8 // Version, flags and sample count
+	if flags&trunDataOffset != 0 {
+		offset += 4
+	}
+	if flags&trunFirstSampleFlags != 0 {
+		if len(trun) < offset+4 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(trun[offset:]), true
+	}
+	if flags&trunSampleFlags == 0 {
+		return 0, false
+	}
+	for _, field := range []uint32{trunSampleDuration, trunSampleSize} {
+		if flags&field != 0 {
+			offset += 4
+		}
+	}
+	if len(trun) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(trun[offset:]), true
+}
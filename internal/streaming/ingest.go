@@ -0,0 +1,250 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// listenerStartupDelay is how long a listening source must keep running
+	// before StartStream reports it as waiting for a sender.
+	listenerStartupDelay = time.Second
+	// outputTailSize is how much of FFmpeg's log output is kept.
+	outputTailSize = 8192
+)
+
+// sourceSchemes lists the URL schemes accepted by each network source.
+var sourceSchemes = map[string][]string{
+	SourceRTMP: {"rtmp", "rtmps"},
+	SourceSRT:  {"srt"},
+	SourceRTSP: {"rtsp", "rtsps"},
+}
+
+// isNetworkSource reports whether the profile reads from a network feed.
+func (p StreamProfile) isNetworkSource() bool {
+	_, ok := sourceSchemes[p.source()]
+	return ok
+}
+
+// listens reports whether FFmpeg waits for a sender to connect.
+func (p StreamProfile) listens() bool {
+	return p.isNetworkSource() && p.Listen
+}
+
+// validateNetworkSource checks the URL of a network source.
+func (p StreamProfile) validateNetworkSource() error {
+	if !p.isNetworkSource() {
+		return nil
+	}
+	if p.URL == "" {
+		return fmt.Errorf("%w %q: url is required for %s sources", ErrInvalidProfile, p.Name, p.source())
+	}
+	u, err := url.Parse(p.URL)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w %q: invalid url: %v", ErrInvalidProfile, p.Name, err)
+	case !containsString(sourceSchemes[p.source()], u.Scheme):
+		return fmt.Errorf("%w %q: url scheme %q does not match source %s", ErrInvalidProfile, p.Name, u.Scheme, p.source())
+	case u.Host == "":
+		return fmt.Errorf("%w %q: url needs a host and port", ErrInvalidProfile, p.Name)
+	}
+	return nil
+}
+
+// networkInputArgs returns the FFmpeg arguments for a network source,
+// followed by generated silence when the source has no audio.
+func (p StreamProfile) networkInputArgs() []string {
+	var args []string
+	switch p.source() {
+	case SourceRTMP:
+		args = []string{"-f", "flv"}
+		if p.Listen {
+			args = append(args, "-listen", "1")
+		}
+		args = append(args, "-i", p.URL)
+	case SourceSRT:
+		input := p.URL
+		if p.Listen {
+			input = withQuery(input, "mode", "listener")
+		}
+		args = []string{"-f", "mpegts", "-i", input}
+	default:
+		args = []string{"-rtsp_transport", "tcp"}
+		if p.Listen {
+			args = append(args, "-rtsp_flags", "listen")
+		}
+		args = append(args, "-i", p.URL)
+	}
+	if p.silentAudio {
+		silence := "anullsrc=channel_layout=stereo:sample_rate=" + strconv.Itoa(p.AudioSampleRate)
+		args = append(args, "-re", "-f", "lavfi", "-i", silence)
+	}
+	return args
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// withQuery sets a query parameter on rawURL unless it is already present.
+func withQuery(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	if q.Has(key) {
+		return rawURL
+	}
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// outputTail keeps the end of FFmpeg's log output.
+type outputTail struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+// Write appends p, dropping the oldest output beyond outputTailSize.
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.data = append(t.data, p...)
+	if over := len(t.data) - outputTailSize; over > 0 {
+		t.data = append(t.data[:0], t.data[over:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output.
+func (t *outputTail) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return string(t.data)
+}
+
+// missingStream reports whether the exited process failed because a stream
+// it maps does not exist, as when a network source has no audio track.
+func (p *process) missingStream() bool {
+	select {
+	case <-p.done:
+	default:
+		return false
+	}
+	log := p.log.String()
+	return strings.Contains(log, "matches no streams") || strings.Contains(log, "does not contain any stream")
+}
+
+// useSilence switches st to generated silence when proc exited because the
+// network source of st has no audio track, and reports whether it did.
+func (s *FFmpegStreamer) useSilence(st *stream, proc *process) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if st.silentAudio || !st.profile.isNetworkSource() || !proc.missingStream() {
+		return false
+	}
+	st.silentAudio = true
+	s.logger.Warnf("Source of stream %s has no audio track, generating silence", st.name)
+	return true
+}
+
+// awaitStartup waits until proc is live, or listening for a sender. When the
+// network source has no audio track FFmpeg exits, and the stream is launched
+// once more with generated silence. It returns the last process waited for.
+func (s *FFmpegStreamer) awaitStartup(ctx context.Context, st *stream, sess *StreamSession, p StreamProfile, encoder string, proc *process, stop <-chan struct{}) (*process, error) {
+	masterPath := filepath.Join(st.hlsDir, MasterPlaylist)
+	for {
+		var err error
+		if p.listens() {
+			err = s.awaitListening(ctx, proc)
+		} else {
+			err = s.awaitLive(ctx, proc, masterPath, sess.StartedAt)
+		}
+		if err == nil || !s.useSilence(st, proc) {
+			return proc, err
+		}
+
+		s.mutex.Lock()
+		select {
+		case <-stop:
+			s.mutex.Unlock()
+			return proc, err
+		default:
+		}
+		next, err := s.launch(st, sess, p, encoder)
+		if err != nil {
+			s.mutex.Unlock()
+			return proc, err
+		}
+		st.proc = next
+		s.mutex.Unlock()
+		proc = next
+	}
+}
+
+// awaitSender marks a listening session live once media from the sender
+// makes the run proc write the master playlist, and starts its relays.
+func (s *FFmpegStreamer) awaitSender(st *stream, sess *StreamSession, proc *process, since time.Time, stop <-chan struct{}) {
+	masterPath := filepath.Join(st.hlsDir, MasterPlaylist)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-proc.done:
+			return
+		case <-ticker.C:
+		}
+		if info, err := os.Stat(masterPath); err != nil || info.ModTime().Before(since) {
+			continue
+		}
+
+		s.mutex.Lock()
+		live := st.session == sess && st.proc == proc && sess.State == SessionListening
+		if live {
+			sess.setState(SessionLive)
+			s.startRelays(st)
+		}
+		s.mutex.Unlock()
+		if live {
+			s.logger.Infof("Stream %s session %s is receiving media and live", sess.Stream, sess.ID)
+			s.emit(Event{Type: EventLive, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Stream %s is receiving media", sess.Stream)})
+		}
+		return
+	}
+}
+
+// awaitListening checks that FFmpeg is up and waiting for a sender. A
+// listening source produces no playlist until the sender connects, so the
+// stream counts as listening once FFmpeg has survived listenerStartupDelay.
+func (s *FFmpegStreamer) awaitListening(ctx context.Context, proc *process) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("stream did not start listening: %w", ctx.Err())
+	case <-proc.done:
+		if proc.err != nil {
+			return fmt.Errorf("ffmpeg exited during startup: %w", proc.err)
+		}
+		return errors.New("ffmpeg exited during startup")
+	case <-time.After(listenerStartupDelay):
+		return nil
+	}
+}
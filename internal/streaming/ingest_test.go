@@ -0,0 +1,140 @@
+package streaming
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// networkProfile returns a valid profile reading from a network source.
+func networkProfile(source, url string, listen bool) StreamProfile {
+	p := DefaultProfile()
+	p.Source = source
+	p.URL = url
+	p.Listen = listen
+	return p
+}
+
+func TestNetworkSourceAudio(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     StreamProfile
+		silent      bool
+		wantStream  string
+		wantSilence bool
+	}{
+		{"device", DefaultProfile(), false, "1:a", false},
+		{"rtsp with audio", networkProfile(SourceRTSP, "rtsp://camera:554/live", false), false, "0:a:0?", false},
+		{"rtsp without audio", networkProfile(SourceRTSP, "rtsp://camera:554/live", false), true, "1:a", true},
+		{"srt listener without audio", networkProfile(SourceSRT, "srt://0.0.0.0:9000", true), true, "1:a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.profile
+			p.silentAudio = tt.silent
+			if got := p.audioStream(); got != tt.wantStream {
+				t.Errorf("audioStream() = %q, want %q", got, tt.wantStream)
+			}
+			args := strings.Join(p.inputArgs(), " ")
+			if got := strings.Contains(args, "anullsrc"); got != tt.wantSilence {
+				t.Errorf("inputArgs() = %s, generates silence %v, want %v", args, got, tt.wantSilence)
+			}
+		})
+	}
+}
+
+func TestOutputTailKeepsTheEnd(t *testing.T) {
+	var tail outputTail
+	tail.Write([]byte(strings.Repeat("x", outputTailSize)))
+	tail.Write([]byte("Output file #2 does not contain any stream\n"))
+	got := tail.String()
+	if len(got) != outputTailSize || !strings.HasSuffix(got, "does not contain any stream\n") {
+		t.Errorf("tail of %d bytes does not end with the last write", len(got))
+	}
+}
+
+// recordEvents collects the events of s.
+func recordEvents(s *FFmpegStreamer) func() []Event {
+	var (
+		mutex  sync.Mutex
+		events []Event
+	)
+	s.SetEventHandler(func(ev Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, ev)
+	})
+	return func() []Event {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]Event(nil), events...)
+	}
+}
+
+func TestNetworkSourceWithoutAudioFallsBackToSilence(t *testing.T) {
+	cfg := fakeConfig(t, networkProfile(SourceRTSP, "rtsp://camera:554/live", false))
+	t.Setenv("FAKE_FFMPEG_NO_AUDIO", "1")
+	s := NewFFmpegStreamer(cfg, testLogger())
+
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	if sess := s.CurrentSession(""); sess == nil || sess.State != SessionLive {
+		t.Fatalf("session = %+v, want a live session", sess)
+	}
+	runs := fakeInvocations(t)
+	if len(runs) != 2 {
+		t.Fatalf("FFmpeg ran %d times, want 2", len(runs))
+	}
+	if !strings.Contains(runs[0], "-map 0:a:0?") {
+		t.Errorf("first run does not map the source audio optionally: %s", runs[0])
+	}
+	if !strings.Contains(runs[1], "anullsrc") || !strings.Contains(runs[1], "-map 1:a") {
+		t.Errorf("second run does not map generated silence: %s", runs[1])
+	}
+}
+
+func TestListeningSourceGoesLiveWhenSenderConnects(t *testing.T) {
+	cfg := fakeConfig(t, networkProfile(SourceRTMP, "rtmp://0.0.0.0:1935/live/cam", true))
+	sender := filepath.Join(t.TempDir(), "sender")
+	t.Setenv("FAKE_FFMPEG_SENDER", sender)
+	s := NewFFmpegStreamer(cfg, testLogger())
+	events := recordEvents(s)
+
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	sess := s.CurrentSession("")
+	if sess == nil || sess.State != SessionListening || sess.LiveAt != nil {
+		t.Fatalf("session = %+v, want a session listening for a sender", sess)
+	}
+	time.Sleep(3 * fakeSegmentInterval)
+	if sess := s.CurrentSession(""); sess.State != SessionListening {
+		t.Fatalf("session went %s without a sender", sess.State)
+	}
+
+	// The stand-in sender connects
+	if err := os.WriteFile(sender, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the session to go live", func() bool {
+		sess := s.CurrentSession("")
+		return sess != nil && sess.State == SessionLive && sess.LiveAt != nil
+	})
+	waitFor(t, time.Second, "the live event", func() bool {
+		for _, ev := range events() {
+			if ev.Type == EventLive {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,128 @@
+package streaming
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MasterPlaylist is the name of the HLS master playlist written under the HLS directory.
+	MasterPlaylist = "master.m3u8"
+	// LivePlaylist is the name of the live media playlist of a stream, or of
+	// each rendition in its subdirectory.
+	LivePlaylist = "playlist.m3u8"
+)
+
+// Rendition is one variant of an adaptive bitrate HLS ladder.
+type Rendition struct {
+	Name        string `json:"name"` // Also the subdirectory of the variant under the HLS directory
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	MaxrateKbps int    `json:"maxrate_kbps"`
+}
+
+// DefaultLadder returns a 1080p/720p/480p ladder.
+func DefaultLadder() []Rendition {
+	return []Rendition{
+		{Name: "1080p", Width: 1920, Height: 1080, MaxrateKbps: 5000},
+		{Name: "720p", Width: 1280, Height: 720, MaxrateKbps: 2800},
+		{Name: "480p", Width: 854, Height: 480, MaxrateKbps: 1400},
+	}
+}
+
+// validateRenditions checks the ladder of a profile.
+func (p StreamProfile) validateRenditions() error {
+	seen := make(map[string]bool, len(p.Renditions))
+	for _, r := range p.Renditions {
+		switch {
+		case r.Name == "" || strings.ContainsAny(r.Name, `/\ `):
+			return fmt.Errorf("%w %q: rendition name %q must be a non-empty path segment", ErrInvalidProfile, p.Name, r.Name)
+		case seen[r.Name]:
+			return fmt.Errorf("%w %q: duplicate rendition %q", ErrInvalidProfile, p.Name, r.Name)
+		case r.Width <= 0 || r.Height <= 0:
+			return fmt.Errorf("%w %q: rendition %q needs a positive width and height", ErrInvalidProfile, p.Name, r.Name)
+		case r.MaxrateKbps <= 0:
+			return fmt.Errorf("%w %q: rendition %q needs a positive maxrate_kbps", ErrInvalidProfile, p.Name, r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return nil
+}
+
+// ladderArgs returns the output arguments that encode every rendition in one
+// FFmpeg invocation and write a variant playlist per rendition plus a master
+// playlist into hlsDir.
+func (p StreamProfile) ladderArgs(encoder, hlsDir, keyInfo string, retain bool) []string {
+	spec := encoderSpecs[encoder]
+	n := len(p.Renditions)
+
+	// Split the captured video once, after the overlays, and scale a copy for
+	// every rendition.
+	var graph strings.Builder
+	source := p.videoStream()
+	if len(p.Overlays) > 0 {
+		graph.WriteString(p.overlayGraph(source, "overlaid") + ";")
+		source = "overlaid"
+	}
+	fmt.Fprintf(&graph, "[%s]split=%d", source, n)
+	for i := range p.Renditions {
+		fmt.Fprintf(&graph, "[v%d]", i)
+	}
+	for i, r := range p.Renditions {
+		filters := append([]string{fmt.Sprintf("scale=w=%d:h=%d", r.Width, r.Height)}, spec.videoFilters...)
+		fmt.Fprintf(&graph, ";[v%d]%s[v%dout]", i, strings.Join(filters, ","), i)
+	}
+
+	args := []string{"-filter_complex", graph.String()}
+	var streamMap []string
+	for i, r := range p.Renditions {
+		variant := p
+		variant.MaxrateKbps = r.MaxrateKbps
+		variant.BufsizeKbps = 2 * r.MaxrateKbps
+
+		args = append(args, "-map", fmt.Sprintf("[v%dout]", i))
+		args = append(args, streamSpecific([]string{"-c:v", encoder}, i)...)
+		args = append(args, streamSpecific(spec.args(variant), i)...)
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name))
+	}
+	for range p.Renditions {
+		args = append(args, "-map", p.audioStream())
+	}
+	args = append(args, p.audioArgs()...)
+
+	muxer := []string{
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(p.HLSTime),
+		"-hls_list_size", strconv.Itoa(p.HLSListSize),
+	}
+	encryption, flags := encryptionArgs(keyInfo, []string{"independent_segments"})
+	muxer = append(muxer, encryption...)
+	muxer = append(muxer, segmentLifetimeArgs(flags, retain)...)
+	muxer = append(muxer,
+		"-master_pl_name", MasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-hls_segment_filename", filepath.Join(hlsDir, "%v", "segment_%05d.ts"),
+		filepath.Join(hlsDir, "%v", LivePlaylist),
+	)
+	return append(args, p.programOutput(muxer)...)
+}
+
+// streamSpecific rewrites per-stream options such as "-b:v" or "-preset" so
+// that they only apply to the idx-th video stream of the output.
+func streamSpecific(args []string, idx int) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if i%2 == 1 || !strings.HasPrefix(arg, "-") {
+			out[i] = arg
+			continue
+		}
+		if strings.HasSuffix(arg, ":v") {
+			out[i] = fmt.Sprintf("%s:%d", arg, idx)
+		} else {
+			out[i] = fmt.Sprintf("%s:v:%d", arg, idx)
+		}
+	}
+	return out
+}
@@ -0,0 +1,252 @@
+package streaming
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// levelFloor replaces levels of digital silence, which FFmpeg reports as
+	// -inf and JSON cannot represent.
+	levelFloor = -120.0
+	// levelSampleRate and levelWindow make the meter measure 100 ms blocks,
+	// giving about ten readings per second.
+	levelSampleRate = 48000
+	levelWindow     = levelSampleRate / 10
+	// maxLevelChannels bounds the channel numbers accepted from astats.
+	maxLevelChannels = 64
+)
+
+// ChannelLevel is the level of one audio channel in dBFS.
+type ChannelLevel struct {
+	Peak float64 `json:"peak_db"`
+	RMS  float64 `json:"rms_db"`
+}
+
+// AudioLevels is one reading of the audio meter of a stream. Loudness
+// values are in LUFS over the whole program.
+type AudioLevels struct {
+	Stream     string         `json:"stream"`
+	Session    string         `json:"session"`
+	Channels   []ChannelLevel `json:"channels"`
+	Momentary  float64        `json:"momentary_lufs"`
+	ShortTerm  float64        `json:"short_term_lufs"`
+	Integrated float64        `json:"integrated_lufs"`
+	Silent     bool           `json:"silent"` // Set once the silence alert fired
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// loudest returns the highest RMS level over all channels.
+func (lv AudioLevels) loudest() float64 {
+	loudest := levelFloor
+	for _, ch := range lv.Channels {
+		loudest = math.Max(loudest, ch.RMS)
+	}
+	return loudest
+}
+
+// levelArgs returns the FFmpeg arguments for the audio meter output. astats
+// and ebur128 attach their measurements to every 100 ms block, which
+// ametadata prints to output.
+func (p StreamProfile) levelArgs(output string) []string {
+	graph := []string{
+		"aresample=" + strconv.Itoa(levelSampleRate),
+		"asetnsamples=n=" + strconv.Itoa(levelWindow) + ":p=0",
+		"astats=metadata=1:reset=1",
+		"ebur128=metadata=1",
+		"ametadata=mode=print:file=" + filterValue(output),
+	}
+	return []string{"-map", p.audioStream(), "-af", strings.Join(graph, ","), "-f", "null", "-"}
+}
+
+// parseLevels reads the blocks ametadata prints, each starting with a
+// "frame:" line, and calls report for every complete block. It returns the
+// read error that ended the output, dropping the block cut short by it.
+func parseLevels(r io.Reader, report func(AudioLevels)) error {
+	var lv AudioLevels
+	started, measured := false, false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "frame:") {
+			if measured {
+				report(lv)
+			}
+			lv, started, measured = AudioLevels{}, true, false
+			continue
+		}
+		if !started {
+			// The rest of a block cut by dropped data
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "lavfi.r128.M":
+			lv.Momentary = parseLevel(value)
+		case "lavfi.r128.S":
+			lv.ShortTerm = parseLevel(value)
+		case "lavfi.r128.I":
+			lv.Integrated = parseLevel(value)
+		default:
+			channel, stat, ok := strings.Cut(strings.TrimPrefix(key, "lavfi.astats."), ".")
+			n, err := strconv.Atoi(channel)
+			if !ok || err != nil || n < 1 || n > maxLevelChannels {
+				continue
+			}
+			for len(lv.Channels) < n {
+				lv.Channels = append(lv.Channels, ChannelLevel{Peak: levelFloor, RMS: levelFloor})
+			}
+			switch stat {
+			case "Peak_level":
+				lv.Channels[n-1].Peak = parseLevel(value)
+			case "RMS_level":
+				lv.Channels[n-1].RMS = parseLevel(value)
+			default:
+				continue
+			}
+		}
+		measured = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if measured {
+		report(lv)
+	}
+	return nil
+}
+
+// parseLevel parses a level, clamping silence and invalid values to levelFloor.
+func parseLevel(value string) float64 {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || v < levelFloor {
+		return levelFloor
+	}
+	return v
+}
+
+// audioMeter keeps the latest levels of a session and detects silence.
+type audioMeter struct {
+	mutex      sync.Mutex
+	stream     string
+	session    string
+	threshold  float64       // RMS level in dBFS below which audio counts as silent
+	duration   time.Duration // How long audio must stay silent before the alert
+	quietSince time.Time
+	silent     bool
+	latest     AudioLevels
+}
+
+// newAudioMeter creates the meter of a session.
+func newAudioMeter(stream, session string, threshold float64, duration time.Duration) *audioMeter {
+	return &audioMeter{
+		stream:    stream,
+		session:   session,
+		threshold: threshold,
+		duration:  duration,
+		latest:    AudioLevels{Stream: stream, Session: session},
+	}
+}
+
+// update records a reading taken at now and returns the silence event it
+// triggers, if any.
+func (m *audioMeter) update(lv *AudioLevels, now time.Time) *Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var ev *Event
+	if level := lv.loudest(); level < m.threshold {
+		if m.quietSince.IsZero() {
+			m.quietSince = now
+		}
+		if !m.silent && now.Sub(m.quietSince) >= m.duration {
+			m.silent = true
+			ev = &Event{
+				Type:    EventAudioSilent,
+				Message: fmt.Sprintf("Audio of stream %s has been below %.0f dBFS for %s", m.stream, m.threshold, m.duration),
+			}
+		}
+	} else {
+		m.quietSince = time.Time{}
+		if m.silent {
+			m.silent = false
+			ev = &Event{Type: EventAudioRestored, Message: fmt.Sprintf("Audio of stream %s is back", m.stream)}
+		}
+	}
+	if ev != nil {
+		ev.Stream, ev.Session = m.stream, m.session
+	}
+
+	lv.Stream, lv.Session = m.stream, m.session
+	lv.Silent = m.silent
+	lv.UpdatedAt = now
+	m.latest = *lv
+	return ev
+}
+
+// current returns the latest reading.
+func (m *audioMeter) current() AudioLevels {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	lv := m.latest
+	lv.Channels = append([]ChannelLevel(nil), lv.Channels...)
+	return lv
+}
+
+// consumeLevels publishes the meter readings FFmpeg writes to r until the
+// pipe closes, resuming at the next block after dropped data.
+func (s *FFmpegStreamer) consumeLevels(m *audioMeter, r io.ReadCloser) {
+	defer r.Close()
+	report := func(lv AudioLevels) {
+		ev := m.update(&lv, time.Now())
+		s.eventMutex.RLock()
+		handler := s.levelHandler
+		s.eventMutex.RUnlock()
+		if handler != nil {
+			handler(lv)
+		}
+		if ev == nil {
+			return
+		}
+		if ev.Type == EventAudioSilent {
+			s.logger.Warn(ev.Message)
+		} else {
+			s.logger.Info(ev.Message)
+		}
+		s.emit(*ev)
+	}
+	for {
+		if err := parseLevels(r, report); !errors.Is(err, errSideOutputGap) {
+			return
+		}
+	}
+}
+
+// SetLevelHandler registers a callback receiving every audio meter reading,
+// about ten per second for each running stream.
+func (s *FFmpegStreamer) SetLevelHandler(handler func(AudioLevels)) {
+	s.eventMutex.Lock()
+	defer s.eventMutex.Unlock()
+	s.levelHandler = handler
+}
+
+// Levels returns the latest audio meter reading of the named stream.
+func (s *FFmpegStreamer) Levels(name string) (AudioLevels, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil || st.meter == nil {
+		return AudioLevels{}, ErrNotStreaming
+	}
+	return st.meter.current(), nil
+}
@@ -0,0 +1,97 @@
+package streaming
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []AudioLevels
+	}{
+		{
+			name: "stereo blocks",
+			output: `frame:0    pts:0       pts_time:0
+lavfi.astats.1.Peak_level=-6.020600
+lavfi.astats.1.RMS_level=-18.500000
+lavfi.astats.2.Peak_level=-7.000000
+lavfi.astats.2.RMS_level=-19.250000
+lavfi.astats.Overall.RMS_level=-18.800000
+lavfi.r128.M=-20.100
+lavfi.r128.S=-21.200
+lavfi.r128.I=-22.300
+frame:1    pts:4800    pts_time:0.1
+lavfi.astats.1.RMS_level=-30.000000
+`,
+			want: []AudioLevels{
+				{
+					Channels:   []ChannelLevel{{Peak: -6.0206, RMS: -18.5}, {Peak: -7, RMS: -19.25}},
+					Momentary:  -20.1,
+					ShortTerm:  -21.2,
+					Integrated: -22.3,
+				},
+				{Channels: []ChannelLevel{{Peak: levelFloor, RMS: -30}}},
+			},
+		},
+		{
+			name: "digital silence",
+			output: `frame:0    pts:0       pts_time:0
+lavfi.astats.1.Peak_level=-inf
+lavfi.astats.1.RMS_level=-inf
+lavfi.r128.M=-120.7
+lavfi.r128.I=nan
+`,
+			want: []AudioLevels{{
+				Channels:   []ChannelLevel{{Peak: levelFloor, RMS: levelFloor}},
+				Momentary:  levelFloor,
+				Integrated: levelFloor,
+			}},
+		},
+		{
+			name: "unusable channels",
+			output: `frame:0    pts:0       pts_time:0
+lavfi.astats.0.RMS_level=-10.000000
+lavfi.astats.65.RMS_level=-10.000000
+lavfi.astats.x.RMS_level=-10.000000
+frame:1    pts:4800    pts_time:0.1
+`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []AudioLevels
+			parseLevels(strings.NewReader(tt.output), func(lv AudioLevels) {
+				got = append(got, lv)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLevels() reported %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLevelsAfterGap(t *testing.T) {
+	so := gappedOutput(
+		sideChunk{data: []byte("frame:0\nlavfi.astats.1.RMS_level=-10.0\nframe:1\nlavfi.astats.1.RMS_lev")},
+		sideChunk{data: []byte("el=-5.0\nlavfi.r128.M=-3.0\nframe:2\nlavfi.astats.1.RMS_level=-20.0\n"), gap: true},
+	)
+	var got []AudioLevels
+	report := func(lv AudioLevels) { got = append(got, lv) }
+	if err := parseLevels(so, report); err != errSideOutputGap {
+		t.Fatalf("parseLevels() error = %v, want %v", err, errSideOutputGap)
+	}
+	if err := parseLevels(so, report); err != nil {
+		t.Fatalf("parseLevels() after the gap error = %v", err)
+	}
+	want := []AudioLevels{
+		{Channels: []ChannelLevel{{Peak: levelFloor, RMS: -10}}},
+		{Channels: []ChannelLevel{{Peak: levelFloor, RMS: -20}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLevels() reported %+v, want %+v", got, want)
+	}
+}
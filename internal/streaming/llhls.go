@@ -0,0 +1,476 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LowLatencyDir is the subdirectory of the HLS directory used in low-latency mode.
+	LowLatencyDir = "ll"
+	// defaultPartDurationMs is the partial segment duration when a profile does not set one.
+	defaultPartDurationMs = 500
+	// llPartWindow is the number of most recent segments whose parts are listed.
+	llPartWindow = 3
+	// llMaxSegmentFactor bounds segments, in target durations, when no part
+	// starting on a keyframe arrives.
+	llMaxSegmentFactor = 2
+)
+
+var (
+	// ErrLowLatencyInactive is returned for blocking requests when no LL-HLS stream is running.
+	ErrLowLatencyInactive = errors.New("low-latency HLS is not active")
+	// ErrPlaylistTooFarAhead is returned when a blocking reload asks for a segment
+	// more than two segments beyond the live edge.
+	ErrPlaylistTooFarAhead = errors.New("requested media sequence is too far ahead of the live edge")
+)
+
+// partDuration returns the LL-HLS part duration of the profile in seconds.
+func (p StreamProfile) partDuration() float64 {
+	ms := p.PartDurationMs
+	if ms <= 0 {
+		ms = defaultPartDurationMs
+	}
+	return float64(ms) / 1000
+}
+
+// validateLowLatency checks the LL-HLS settings of a profile.
+func (p StreamProfile) validateLowLatency() error {
+	if !p.LowLatency {
+		return nil
+	}
+	switch {
+	case len(p.Renditions) > 0:
+		return fmt.Errorf("%w %q: low_latency cannot be combined with renditions", ErrInvalidProfile, p.Name)
+	case p.PartDurationMs < 0 || p.partDuration() > float64(p.HLSTime)/2:
+		return fmt.Errorf("%w %q: part_duration_ms must be at most half of hls_time", ErrInvalidProfile, p.Name)
+	}
+	return nil
+}
+
+// llHLSArgs returns the output arguments for low-latency mode. FFmpeg writes
+// every part as an independent fMP4 fragment into runDir; the packager groups
+// them into segments and writes the LL-HLS playlist.
+func (p StreamProfile) llHLSArgs(encoder, runDir string) []string {
+	spec := encoderSpecs[encoder]
+	part := strconv.FormatFloat(p.partDuration(), 'f', 3, 64)
+	partsPerSegment := int(math.Ceil(float64(p.HLSTime) / p.partDuration()))
+
+	args := []string{"-map", p.videoStream(), "-map", p.audioStream()}
+	args = append(args, p.programVideoArgs(spec)...)
+	args = append(args, "-c:v", encoder)
+	args = append(args, spec.args(p)...)
+	// Segments must start on a keyframe, parts need not: parts are cut by
+	// time and only those starting on a keyframe are marked independent.
+	args = append(args, "-force_key_frames", "expr:gte(t,n_forced*"+strconv.Itoa(p.HLSTime)+")")
+	args = append(args, p.audioArgs()...)
+	return append(args, p.programOutput([]string{
+		"-f", "hls",
+		"-hls_time", part,
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_list_size", strconv.Itoa((llPartWindow + 2) * partsPerSegment),
+		"-hls_flags", "delete_segments+split_by_time",
+		"-hls_segment_filename", filepath.Join(runDir, "part_%06d.m4s"),
+		filepath.Join(runDir, "parts.m3u8"),
+	})...)
+}
+
+// llPart is one partial segment written by FFmpeg.
+type llPart struct {
+	URI         string // Relative to the low-latency directory
+	Duration    float64
+	Independent bool // Starts on a keyframe
+}
+
+// llSegment is a segment assembled from consecutive parts.
+type llSegment struct {
+	MSN           int
+	URI           string // Set once the segment is complete
+	MapURI        string
+	Duration      float64
+	Discontinuity bool
+	Parts         []llPart
+}
+
+// llPackager turns FFmpeg's stream of parts into an LL-HLS playlist with
+// partial segments, preload hints and blocking reload support.
+type llPackager struct {
+	mutex         sync.Mutex
+	dir           string
+	hlsDir        string
+	partTarget    float64
+	segmentTarget float64
+	windowSize    int
+	bandwidth     int
+	runs          int
+	runDir        string // Relative directory of the current run
+	lastPart      int    // Sequence of the last part seen in the current run
+	segments      []*llSegment
+	discontinuity int  // Discontinuity sequence of the first listed segment
+	masterWritten bool // Set once the master playlist exists
+	changed       chan struct{}
+	logger        *logrus.Entry
+}
+
+// newLLPackager prepares the low-latency directory under hlsDir for profile p.
+func newLLPackager(hlsDir string, p StreamProfile, logger *logrus.Entry) (*llPackager, error) {
+	dir := filepath.Join(hlsDir, LowLatencyDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &llPackager{
+		dir:           dir,
+		hlsDir:        hlsDir,
+		partTarget:    p.partDuration(),
+		segmentTarget: float64(p.HLSTime),
+		windowSize:    p.HLSListSize,
+		bandwidth:     (p.MaxrateKbps + p.AudioBitrateKbps) * 1000,
+		changed:       make(chan struct{}),
+		logger:        logger,
+	}, nil
+}
+
+// nextRun creates the directory for a new FFmpeg run. Parts of a new run
+// start a new segment preceded by a discontinuity.
+func (lp *llPackager) nextRun() (string, error) {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+
+	lp.runs++
+	lp.runDir = fmt.Sprintf("run-%03d", lp.runs)
+	lp.lastPart = -1
+	if cur := lp.current(); cur != nil {
+		if len(cur.Parts) > 0 {
+			lp.completeSegment(cur)
+		} else {
+			lp.segments = lp.segments[:len(lp.segments)-1]
+		}
+	}
+	dir := filepath.Join(lp.dir, lp.runDir)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// watch polls the run's part playlist until the process exits.
+func (lp *llPackager) watch(runDir string, proc *process) {
+	interval := time.Duration(lp.partTarget * float64(time.Second) / 5)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	run := filepath.Base(runDir)
+	for {
+		select {
+		case <-proc.done:
+			lp.collect(run)
+			return
+		case <-ticker.C:
+			lp.collect(run)
+		}
+	}
+}
+
+// collect adds the parts FFmpeg listed since the last poll.
+func (lp *llPackager) collect(run string) {
+	pl, err := readMediaPlaylist(filepath.Join(lp.dir, run, "parts.m3u8"))
+	if err != nil {
+		return
+	}
+
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	if run != lp.runDir {
+		return
+	}
+
+	added := false
+	for _, seg := range pl.Segments {
+		if seg.Sequence <= lp.lastPart {
+			continue
+		}
+		part := llPart{URI: run + "/" + seg.URI, Duration: seg.Duration}
+		independent, err := fragmentIndependent(filepath.Join(lp.dir, run, seg.URI))
+		if err != nil {
+			lp.logger.Warnf("Failed to read LL-HLS part %s: %v", part.URI, err)
+		}
+		part.Independent = independent
+		lp.addPart(part, run+"/"+pl.MapURI)
+		lp.lastPart = seg.Sequence
+		added = true
+	}
+	if !added {
+		return
+	}
+
+	if err := lp.writePlaylist(); err != nil {
+		lp.logger.Errorf("Failed to write LL-HLS playlist: %v", err)
+	}
+	close(lp.changed)
+	lp.changed = make(chan struct{})
+}
+
+// current returns the incomplete segment, if any. Callers must hold the mutex.
+func (lp *llPackager) current() *llSegment {
+	if n := len(lp.segments); n > 0 && lp.segments[n-1].URI == "" {
+		return lp.segments[n-1]
+	}
+	return nil
+}
+
+// addPart appends a part to the current segment. A part starting on a
+// keyframe completes the current segment first once that reaches the target
+// duration, so that every segment starts on a keyframe. Callers must hold the
+// mutex.
+func (lp *llPackager) addPart(part llPart, mapURI string) {
+	cur := lp.current()
+	if cur != nil {
+		switch {
+		case part.Independent && cur.Duration >= lp.segmentTarget-lp.partTarget/2:
+			lp.completeSegment(cur)
+			cur = nil
+		case cur.Duration >= llMaxSegmentFactor*lp.segmentTarget:
+			lp.logger.Warnf("No keyframe in LL-HLS segment %d after %.1fs, cutting it", cur.MSN, cur.Duration)
+			lp.completeSegment(cur)
+			cur = nil
+		}
+	}
+	if cur == nil {
+		cur = &llSegment{MSN: lp.nextMSN(), MapURI: mapURI}
+		if n := len(lp.segments); n > 0 && lp.segments[n-1].MapURI != mapURI {
+			cur.Discontinuity = true
+		}
+		lp.segments = append(lp.segments, cur)
+	}
+	cur.Parts = append(cur.Parts, part)
+	cur.Duration += part.Duration
+}
+
+// nextMSN returns the media sequence number of the next segment. Callers must hold the mutex.
+func (lp *llPackager) nextMSN() int {
+	if n := len(lp.segments); n > 0 {
+		return lp.segments[n-1].MSN + 1
+	}
+	return 0
+}
+
+// completeSegment concatenates the segment's parts into one file and drops
+// segments that fell out of the window. Callers must hold the mutex.
+func (lp *llPackager) completeSegment(seg *llSegment) {
+	uri := fmt.Sprintf("segment_%06d.m4s", seg.MSN)
+	if err := lp.concatParts(seg.Parts, filepath.Join(lp.dir, uri)); err != nil {
+		lp.logger.Errorf("Failed to assemble LL-HLS segment %d: %v", seg.MSN, err)
+	}
+	seg.URI = uri
+
+	for len(lp.segments) > lp.windowSize+1 {
+		if lp.segments[1].Discontinuity {
+			lp.discontinuity++
+		}
+		os.Remove(filepath.Join(lp.dir, lp.segments[0].URI))
+		lp.segments = lp.segments[1:]
+	}
+
+	if !lp.masterWritten {
+		if err := lp.writeMaster(); err != nil {
+			lp.logger.Errorf("Failed to write master playlist: %v", err)
+		} else {
+			lp.masterWritten = true
+		}
+	}
+}
+
+// concatParts writes the parts back to back into path.
+func (lp *llPackager) concatParts(parts []llPart, path string) error {
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		in, err := os.Open(filepath.Join(lp.dir, part.URI))
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// writePlaylist renders the LL-HLS media playlist. Callers must hold the mutex.
+func (lp *llPackager) writePlaylist() error {
+	var b strings.Builder
+	target := int(math.Ceil(lp.segmentTarget))
+	for _, seg := range lp.segments {
+		if d := int(math.Ceil(seg.Duration)); d > target {
+			target = d
+		}
+	}
+	first := 0
+	if len(lp.segments) > 0 {
+		first = lp.segments[0].MSN
+	}
+
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*lp.partTarget)
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", lp.partTarget)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", lp.discontinuity)
+
+	mapURI := ""
+	for i, seg := range lp.segments {
+		if seg.Discontinuity && i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.MapURI != mapURI {
+			mapURI = seg.MapURI
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", mapURI)
+		}
+		if i >= len(lp.segments)-llPartWindow {
+			for _, part := range seg.Parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.5f,URI=\"%s\"", part.Duration, part.URI)
+				if part.Independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.URI != "" {
+			fmt.Fprintf(&b, "#EXTINF:%.5f,\n%s\n", seg.Duration, seg.URI)
+		}
+	}
+	fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", lp.hintURI())
+
+	return writeFileAtomic(filepath.Join(lp.dir, LivePlaylist), []byte(b.String()))
+}
+
+// hintURI returns the URI of the part FFmpeg will write next. Callers must hold the mutex.
+func (lp *llPackager) hintURI() string {
+	return fmt.Sprintf("%s/part_%06d.m4s", lp.runDir, lp.lastPart+1)
+}
+
+// writeMaster writes a master playlist pointing at the LL-HLS media playlist.
+func (lp *llPackager) writeMaster() error {
+	content := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s/playlist.m3u8\n",
+		lp.bandwidth, LowLatencyDir)
+	return writeFileAtomic(filepath.Join(lp.hlsDir, MasterPlaylist), []byte(content))
+}
+
+// has reports whether the playlist contains part of segment msn, or the
+// complete segment when part is negative. Callers must hold the mutex.
+func (lp *llPackager) has(msn, part int) bool {
+	for _, seg := range lp.segments {
+		if seg.MSN > msn || (seg.MSN == msn && (seg.URI != "" || (part >= 0 && len(seg.Parts) > part))) {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitPlaylist blocks until the playlist contains the requested segment or part.
+func (lp *llPackager) awaitPlaylist(ctx context.Context, msn, part int) error {
+	for {
+		lp.mutex.Lock()
+		if lp.has(msn, part) {
+			lp.mutex.Unlock()
+			return nil
+		}
+		if msn > lp.nextMSN()+1 {
+			lp.mutex.Unlock()
+			return ErrPlaylistTooFarAhead
+		}
+		changed := lp.changed
+		lp.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// awaitPart blocks until the part at uri (relative to the LL directory) exists.
+// Only the hinted next part of the current run is waited for.
+func (lp *llPackager) awaitPart(ctx context.Context, uri string) error {
+	for {
+		if _, err := os.Stat(filepath.Join(lp.dir, filepath.FromSlash(uri))); err == nil {
+			return nil
+		}
+		lp.mutex.Lock()
+		hinted := uri == lp.hintURI()
+		changed := lp.changed
+		lp.mutex.Unlock()
+		if !hinted {
+			return os.ErrNotExist
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path so
+// that readers never see a partially written playlist.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// AwaitPlaylist blocks until the LL-HLS playlist contains part of media
+// sequence msn (or the whole segment when part is negative), implementing
+// _HLS_msn/_HLS_part blocking playlist reloads.
+func (s *FFmpegStreamer) AwaitPlaylist(ctx context.Context, name string, msn, part int) error {
+	s.mutex.RLock()
+	var lp *llPackager
+	if st := s.lookupStream(name); st != nil {
+		lp = st.llPackager
+	}
+	s.mutex.RUnlock()
+	if lp == nil {
+		return ErrLowLatencyInactive
+	}
+	return lp.awaitPlaylist(ctx, msn, part)
+}
+
+// AwaitPart blocks until the hinted part at uri, relative to the stream's
+// LowLatencyDir, is written.
+func (s *FFmpegStreamer) AwaitPart(ctx context.Context, name, uri string) error {
+	s.mutex.RLock()
+	var lp *llPackager
+	if st := s.lookupStream(name); st != nil {
+		lp = st.llPackager
+	}
+	s.mutex.RUnlock()
+	if lp == nil {
+		return ErrLowLatencyInactive
+	}
+	return lp.awaitPart(ctx, uri)
+}
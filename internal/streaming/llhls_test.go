@@ -0,0 +1,240 @@
+package streaming
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// box encodes an ISO BMFF box of type kind around the body parts.
+func box(kind string, body ...[]byte) []byte {
+	size := 8
+	for _, b := range body {
+		size += len(b)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(size))
+	out = append(out, kind...)
+	for _, b := range body {
+		out = append(out, b...)
+	}
+	return out
+}
+
+// u32s encodes values as big-endian 32-bit integers.
+func u32s(values ...uint32) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+// testFragment returns an fMP4 fragment with a video track fragment whose
+// samples default to non-sync and an audio track fragment of sync samples.
+// keyframe marks the first video sample as a sync sample, through the first
+// sample flags or, with perSample, the sample table.
+func testFragment(keyframe, perSample bool) []byte {
+	const nonSync = sampleIsNonSyncSample | 0x01000000
+	first := uint32(nonSync)
+	if keyframe {
+		first = 0x02000000
+	}
+	var videoRun []byte
+	if perSample {
+		videoRun = box("trun", u32s(trunDataOffset|trunSampleSize|trunSampleFlags, 2, 100, 1000, first, 200, nonSync))
+	} else {
+		videoRun = box("trun", u32s(trunDataOffset|trunFirstSampleFlags, 2, 100, first))
+	}
+	video := box("traf",
+		box("tfhd", u32s(tfhdDefaultDuration|tfhdDefaultFlags, 1, 3000, nonSync)),
+		videoRun,
+	)
+	audio := box("traf",
+		box("tfhd", u32s(tfhdDefaultFlags, 2, 0x02000000)),
+		box("trun", u32s(trunDataOffset, 4, 100)),
+	)
+	return append(append(box("styp", []byte("msdh")), box("moof", box("mfhd", u32s(0, 1)), video, audio)...),
+		box("mdat", make([]byte, 64))...)
+}
+
+func TestFragmentIndependent(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    bool
+		wantErr bool
+	}{
+		{"keyframe in first sample flags", testFragment(true, false), true, false},
+		{"non-sync first sample", testFragment(false, false), false, false},
+		{"keyframe in sample table", testFragment(true, true), true, false},
+		{"non-sync sample table", testFragment(false, true), false, false},
+		{"no fragment", box("mdat", make([]byte, 16)), false, true},
+		{"truncated", testFragment(true, false)[:20], false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "part.m4s")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := fragmentIndependent(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fragmentIndependent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fragmentIndependent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestPackager returns a packager for 2 s segments of 500 ms parts with
+// its first run started.
+func newTestPackager(t *testing.T) (*llPackager, string) {
+	t.Helper()
+	p := DefaultProfile()
+	p.LowLatency = true
+	p.HLSTime = 2
+	p.PartDurationMs = 500
+	p.HLSListSize = 5
+	lp, err := newLLPackager(t.TempDir(), p, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runDir, err := lp.nextRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lp, runDir
+}
+
+// writeParts writes n parts to runDir, keyframes every keyframeEvery parts,
+// and lists them in the run's part playlist.
+func writeParts(t *testing.T, runDir string, n, keyframeEvery int) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-MAP:URI=\"init.mp4\"\n")
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("part_%06d.m4s", i)
+		if err := os.WriteFile(filepath.Join(runDir, name), testFragment(i%keyframeEvery == 0, false), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "#EXTINF:0.500000,\n%s\n", name)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "parts.m3u8"), []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLLPackagerStartsSegmentsOnKeyframes(t *testing.T) {
+	lp, runDir := newTestPackager(t)
+	// Keyframes every 1.5 s: segments run from keyframe to keyframe once
+	// they reach the 2 s target
+	writeParts(t, runDir, 13, 3)
+	lp.collect(filepath.Base(runDir))
+
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	var got []int
+	for _, seg := range lp.segments {
+		got = append(got, len(seg.Parts))
+		if !seg.Parts[0].Independent {
+			t.Errorf("segment %d does not start on a keyframe", seg.MSN)
+		}
+	}
+	if want := []int{6, 6, 1}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("parts per segment = %v, want %v", got, want)
+	}
+	if lp.segments[1].URI == "" || lp.segments[2].URI != "" {
+		t.Errorf("only the last segment should be incomplete")
+	}
+
+	data, err := os.ReadFile(filepath.Join(lp.dir, LivePlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist := string(data)
+	if n := strings.Count(playlist, "#EXT-X-PART:"); n != 13 {
+		t.Errorf("playlist lists %d parts, want 13", n)
+	}
+	if n := strings.Count(playlist, "INDEPENDENT=YES"); n != 5 {
+		t.Errorf("playlist marks %d parts independent, want 5", n)
+	}
+	if !strings.Contains(playlist, `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="run-001/part_000013.m4s"`) {
+		t.Errorf("playlist lacks the preload hint of the next part:\n%s", playlist)
+	}
+}
+
+func TestLLPackagerCutsSegmentsWithoutKeyframes(t *testing.T) {
+	lp, runDir := newTestPackager(t)
+	writeParts(t, runDir, 10, 100)
+	lp.collect(filepath.Base(runDir))
+
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	if len(lp.segments) != 2 || len(lp.segments[0].Parts) != 8 {
+		t.Errorf("got %d segments, want a complete one of 8 parts and a current one", len(lp.segments))
+	}
+}
+
+func TestAwaitPlaylist(t *testing.T) {
+	lp, runDir := newTestPackager(t)
+	run := filepath.Base(runDir)
+
+	if err := lp.awaitPlaylist(context.Background(), 3, 0); !errors.Is(err, ErrPlaylistTooFarAhead) {
+		t.Errorf("awaitPlaylist() far ahead error = %v, want ErrPlaylistTooFarAhead", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lp.awaitPlaylist(ctx, 0, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("awaitPlaylist() before any part error = %v, want DeadlineExceeded", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result <- lp.awaitPlaylist(ctx, 0, 1)
+	}()
+	writeParts(t, runDir, 1, 1)
+	lp.collect(run)
+	select {
+	case err := <-result:
+		t.Fatalf("awaitPlaylist() returned %v before part 1 was listed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	writeParts(t, runDir, 2, 1)
+	lp.collect(run)
+	if err := <-result; err != nil {
+		t.Errorf("awaitPlaylist() error = %v", err)
+	}
+}
+
+func TestAwaitPart(t *testing.T) {
+	lp, runDir := newTestPackager(t)
+	run := filepath.Base(runDir)
+
+	if err := lp.awaitPart(context.Background(), run+"/part_000005.m4s"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("awaitPart() of a part that is not hinted error = %v, want ErrNotExist", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result <- lp.awaitPart(ctx, run+"/part_000000.m4s")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	writeParts(t, runDir, 1, 1)
+	lp.collect(run)
+	if err := <-result; err != nil {
+		t.Errorf("awaitPart() error = %v", err)
+	}
+}
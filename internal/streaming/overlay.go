@@ -0,0 +1,414 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidOverlay is returned when an overlay fails validation.
+var ErrInvalidOverlay = errors.New("invalid overlay")
+
+// OverlayType selects what an overlay draws.
+type OverlayType string
+
+const (
+	OverlayText      OverlayType = "text"
+	OverlayTimestamp OverlayType = "timestamp" // Wall clock time of the encoder
+	OverlayImage     OverlayType = "image"     // PNG watermark
+)
+
+// Overlay positions, anchoring the overlay to a corner or the center of the frame.
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+// Overlay defaults when the corresponding field is left unset.
+const (
+	defaultOverlayFontSize = 36
+	defaultOverlayColor    = "white"
+	defaultOverlayBoxColor = "black@0.5"
+	defaultTimestampFormat = "%Y-%m-%d %T"
+	defaultOverlayPosition = PositionTopLeft
+)
+
+// Overlay is a text, timestamp or image burnt into the program video. It
+// applies to the HLS, restream and WebRTC outputs; the recording and the
+// viewfinder preview stay clean.
+type Overlay struct {
+	Type     OverlayType `json:"type"`
+	Text     string      `json:"text,omitempty"`     // Text overlays only
+	Format   string      `json:"format,omitempty"`   // strftime format of timestamp overlays
+	Image    string      `json:"image,omitempty"`    // Path of the watermark image in the assets directory
+	Width    int         `json:"width,omitempty"`    // Scales the image; 0 keeps its size
+	Opacity  float64     `json:"opacity,omitempty"`  // Image opacity in 0..1; 0 is opaque
+	Position string      `json:"position,omitempty"` // top-left (default), top-right, bottom-left, bottom-right or center
+	Margin   int         `json:"margin,omitempty"`   // Distance in pixels from the anchored edges
+	Font     string      `json:"font,omitempty"`     // Path of a font file in the assets directory; FFmpeg's default when empty
+	FontSize int         `json:"font_size,omitempty"`
+	Color    string      `json:"color,omitempty"`
+	Box      bool        `json:"box,omitempty"` // Draws a background box behind text
+	BoxColor string      `json:"box_color,omitempty"`
+}
+
+// validate checks the overlay and fills in its defaults.
+func (o *Overlay) validate() error {
+	switch o.Type {
+	case OverlayText:
+		if o.Text == "" {
+			return fmt.Errorf("%w: text overlay needs text", ErrInvalidOverlay)
+		}
+	case OverlayTimestamp:
+		if o.Format == "" {
+			o.Format = defaultTimestampFormat
+		}
+		// Colons and braces would end the %{localtime} expansion early
+		if strings.ContainsAny(o.Format, ":{}\\") {
+			return fmt.Errorf("%w: timestamp format must not contain ':', '{', '}' or '\\'; use %%T for the time", ErrInvalidOverlay)
+		}
+	case OverlayImage:
+		if o.Image == "" {
+			return fmt.Errorf("%w: image overlay needs an image path", ErrInvalidOverlay)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidOverlay, o.Type)
+	}
+
+	if o.Position == "" {
+		o.Position = defaultOverlayPosition
+	}
+	switch o.Position {
+	case PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionCenter:
+	default:
+		return fmt.Errorf("%w: unsupported position %q", ErrInvalidOverlay, o.Position)
+	}
+	switch {
+	case o.Margin < 0:
+		return fmt.Errorf("%w: margin must not be negative", ErrInvalidOverlay)
+	case o.Width < 0:
+		return fmt.Errorf("%w: width must not be negative", ErrInvalidOverlay)
+	case o.Opacity < 0 || o.Opacity > 1:
+		return fmt.Errorf("%w: opacity must be between 0 and 1", ErrInvalidOverlay)
+	case o.FontSize < 0:
+		return fmt.Errorf("%w: font_size must not be negative", ErrInvalidOverlay)
+	}
+	if o.Type != OverlayImage {
+		if o.FontSize == 0 {
+			o.FontSize = defaultOverlayFontSize
+		}
+		if o.Color == "" {
+			o.Color = defaultOverlayColor
+		}
+		if o.Box && o.BoxColor == "" {
+			o.BoxColor = defaultOverlayBoxColor
+		}
+	}
+	return nil
+}
+
+// validateOverlays validates every overlay of a list in place.
+func validateOverlays(overlays []Overlay) error {
+	for i := range overlays {
+		if err := overlays[i].validate(); err != nil {
+			return fmt.Errorf("overlay %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// assetPath resolves name, relative to dir or absolute, to the file it
+// names. The file must exist and, once symbolic links are followed, lie
+// within dir, so that overlays cannot read arbitrary files of the server.
+func assetPath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("%w: no assets directory is configured for %s", ErrInvalidOverlay, name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: assets directory: %v", ErrInvalidOverlay, err)
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s not found in the assets directory", ErrInvalidOverlay, name)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s is outside the assets directory", ErrInvalidOverlay, name)
+	}
+	return path, nil
+}
+
+// resolveAssets returns a copy of overlays with their images and fonts
+// resolved within the assets directory.
+func (s *FFmpegStreamer) resolveAssets(overlays []Overlay) ([]Overlay, error) {
+	resolved := append([]Overlay(nil), overlays...)
+	for i := range resolved {
+		o := &resolved[i]
+		var err error
+		if o.Type == OverlayImage {
+			o.Image, err = assetPath(s.assetsDir, o.Image)
+		} else if o.Font != "" {
+			o.Font, err = assetPath(s.assetsDir, o.Font)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("overlay %d: %w", i+1, err)
+		}
+	}
+	return resolved, nil
+}
+
+// position returns the x and y expressions placing an object of size
+// objW x objH inside a frame of size frameW x frameH.
+func (o Overlay) position(frameW, frameH, objW, objH string) (string, string) {
+	m := strconv.Itoa(o.Margin)
+	x, y := m, m
+	switch o.Position {
+	case PositionTopRight:
+		x = frameW + "-" + objW + "-" + m
+	case PositionBottomLeft:
+		y = frameH + "-" + objH + "-" + m
+	case PositionBottomRight:
+		x = frameW + "-" + objW + "-" + m
+		y = frameH + "-" + objH + "-" + m
+	case PositionCenter:
+		x = "(" + frameW + "-" + objW + ")/2"
+		y = "(" + frameH + "-" + objH + ")/2"
+	}
+	return x, y
+}
+
+// drawtext returns the drawtext filter of a text or timestamp overlay.
+func (o Overlay) drawtext() string {
+	opts := []string{}
+	if o.Type == OverlayTimestamp {
+		opts = append(opts, "text="+filterValue("%{localtime:"+o.Format+"}"))
+	} else {
+		opts = append(opts, "expansion=none", "text="+filterValue(o.Text))
+	}
+	if o.Font != "" {
+		opts = append(opts, "fontfile="+filterValue(o.Font))
+	}
+	opts = append(opts,
+		"fontsize="+strconv.Itoa(o.FontSize),
+		"fontcolor="+filterValue(o.Color),
+	)
+	if o.Box {
+		opts = append(opts,
+			"box=1",
+			"boxcolor="+filterValue(o.BoxColor),
+			"boxborderw="+strconv.Itoa(o.FontSize/4),
+		)
+	}
+	x, y := o.position("w", "h", "tw", "th")
+	opts = append(opts, "x="+x, "y="+y)
+	return "drawtext=" + strings.Join(opts, ":")
+}
+
+// watermark returns the filter chain loading the image of an image overlay.
+func (o Overlay) watermark() string {
+	filters := []string{"movie=" + filterValue(o.Image)}
+	if o.Width > 0 {
+		filters = append(filters, "scale="+strconv.Itoa(o.Width)+":-1")
+	}
+	if o.Opacity > 0 && o.Opacity < 1 {
+		filters = append(filters, "format=rgba", "colorchannelmixer=aa="+strconv.FormatFloat(o.Opacity, 'f', 2, 64))
+	}
+	return strings.Join(filters, ",")
+}
+
+// filterValue escapes v for use as a filter option value inside a
+// filtergraph: once for the option parser and once for the graph parser.
+func filterValue(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(v)
+	return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+}
+
+// overlayGraph returns the filtergraph drawing the profile's overlays on the
+// video labelled in, producing the label out. It is empty without overlays.
+func (p StreamProfile) overlayGraph(in, out string) string {
+	var graph []string
+	label := in
+	for i, o := range p.Overlays {
+		next := fmt.Sprintf("ov%d", i)
+		if i == len(p.Overlays)-1 {
+			next = out
+		}
+		if o.Type == OverlayImage {
+			x, y := o.position("W", "H", "w", "h")
+			graph = append(graph,
+				fmt.Sprintf("%s[wm%d]", o.watermark(), i),
+				fmt.Sprintf("[%s][wm%d]overlay=x=%s:y=%s[%s]", label, i, x, y, next),
+			)
+		} else {
+			graph = append(graph, fmt.Sprintf("[%s]%s[%s]", label, o.drawtext(), next))
+		}
+		label = next
+	}
+	return strings.Join(graph, ";")
+}
+
+// programVideoArgs returns the -vf option of a program output using spec:
+// the overlays followed by the encoder's own filters.
+func (p StreamProfile) programVideoArgs(spec encoderSpec) []string {
+	if len(p.Overlays) == 0 {
+		if len(spec.videoFilters) == 0 {
+			return nil
+		}
+		return []string{"-vf", strings.Join(spec.videoFilters, ",")}
+	}
+	if len(spec.videoFilters) == 0 {
+		return []string{"-vf", p.overlayGraph("in", "out")}
+	}
+	return []string{"-vf", p.overlayGraph("in", "overlaid") + ";[overlaid]" + strings.Join(spec.videoFilters, ",") + "[out]"}
+}
+
+// overlayStore keeps the overlays configured per profile, persisting them
+// to a file when a path is set.
+type overlayStore struct {
+	mutex    sync.Mutex
+	path     string
+	profiles map[string][]Overlay
+}
+
+// loadOverlayStore reads the overlays stored at path. A missing file yields
+// an empty store; an empty path keeps overlays in memory only.
+func loadOverlayStore(path string) (*overlayStore, error) {
+	ovs := &overlayStore{path: path, profiles: make(map[string][]Overlay)}
+	if path == "" {
+		return ovs, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ovs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ovs.profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse overlays file %s: %w", path, err)
+	}
+	for profile, overlays := range ovs.profiles {
+		if err := validateOverlays(overlays); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	return ovs, nil
+}
+
+// get returns the overlays stored for profile and whether any were set.
+func (ovs *overlayStore) get(profile string) ([]Overlay, bool) {
+	ovs.mutex.Lock()
+	defer ovs.mutex.Unlock()
+	overlays, ok := ovs.profiles[profile]
+	return append([]Overlay(nil), overlays...), ok
+}
+
+// set replaces the overlays of profile.
+func (ovs *overlayStore) set(profile string, overlays []Overlay) error {
+	ovs.mutex.Lock()
+	defer ovs.mutex.Unlock()
+	ovs.profiles[profile] = overlays
+	if ovs.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ovs.profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ovs.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(ovs.path, data)
+}
+
+// LoadOverlays reads the overlays stored at path and persists later changes
+// there. Stored overlays replace those of the profiles file.
+func (s *FFmpegStreamer) LoadOverlays(path string) error {
+	ovs, err := loadOverlayStore(path)
+	if err != nil {
+		return err
+	}
+	for profile, overlays := range ovs.profiles {
+		if _, err := s.resolveAssets(overlays); err != nil {
+			return fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.overlays = ovs
+	return nil
+}
+
+// withOverlays returns p with the overlays stored for it, if any.
+func (s *FFmpegStreamer) withOverlays(p StreamProfile) StreamProfile {
+	if overlays, ok := s.overlays.get(p.Name); ok {
+		p.Overlays = overlays
+	}
+	return p
+}
+
+// profileName resolves an empty profile name to the default profile and
+// checks that the profile exists. Callers must hold the mutex.
+func (s *FFmpegStreamer) profileName(name string) (string, error) {
+	if name == "" {
+		name = s.defaultProfile
+	}
+	if _, ok := s.profiles[name]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	return name, nil
+}
+
+// Overlays returns the overlays of the named profile, or of the default
+// profile when name is empty.
+func (s *FFmpegStreamer) Overlays(profile string) ([]Overlay, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	name, err := s.profileName(profile)
+	if err != nil {
+		return nil, err
+	}
+	overlays := s.withOverlays(s.profiles[name]).Overlays
+	if overlays == nil {
+		overlays = []Overlay{}
+	}
+	return overlays, nil
+}
+
+// SetOverlays replaces the overlays of the named profile. Running streams
+// keep their overlays; the change applies from their next session.
+func (s *FFmpegStreamer) SetOverlays(profile string, overlays []Overlay) ([]Overlay, error) {
+	if overlays == nil {
+		overlays = []Overlay{}
+	}
+	if err := validateOverlays(overlays); err != nil {
+		return nil, err
+	}
+	if _, err := s.resolveAssets(overlays); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name, err := s.profileName(profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.overlays.set(name, overlays); err != nil {
+		return nil, err
+	}
+	s.logger.Infof("Set %d overlays on profile %q", len(overlays), name)
+	return overlays, nil
+}
@@ -0,0 +1,137 @@
+package streaming
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// assetsDir returns an assets directory holding logo.png and fonts/mono.ttf,
+// plus a link escaping it and a secret file outside it.
+func assetsDir(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "assets")
+	secret := filepath.Join(root, "secret.txt")
+	for _, path := range []string{filepath.Join(dir, "logo.png"), filepath.Join(dir, "fonts", "mono.ttf"), secret} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "escape.png")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, secret
+}
+
+func TestAssetPath(t *testing.T) {
+	dir, secret := assetsDir(t)
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		asset   string
+		want    string
+		wantErr bool
+	}{
+		{"relative", dir, "logo.png", filepath.Join(root, "logo.png"), false},
+		{"nested", dir, "fonts/mono.ttf", filepath.Join(root, "fonts", "mono.ttf"), false},
+		{"absolute inside", dir, filepath.Join(dir, "logo.png"), filepath.Join(root, "logo.png"), false},
+		{"parent", dir, "../secret.txt", "", true},
+		{"absolute outside", dir, secret, "", true},
+		{"system file", dir, "/etc/passwd", "", true},
+		{"link outside", dir, "escape.png", "", true},
+		{"missing", dir, "missing.png", "", true},
+		{"no assets directory", "", "logo.png", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := assetPath(tt.dir, tt.asset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("assetPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidOverlay) {
+				t.Errorf("assetPath() error = %v, want ErrInvalidOverlay", err)
+			}
+			if got != tt.want {
+				t.Errorf("assetPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetOverlaysRestrictsAssets(t *testing.T) {
+	dir, secret := assetsDir(t)
+	s := NewFFmpegStreamer(Config{HLSDir: t.TempDir(), AssetsDir: dir}, testLogger())
+
+	tests := []struct {
+		name    string
+		overlay Overlay
+		wantErr bool
+	}{
+		{"image in assets", Overlay{Type: OverlayImage, Image: "logo.png"}, false},
+		{"font in assets", Overlay{Type: OverlayText, Text: "Live", Font: "fonts/mono.ttf"}, false},
+		{"default font", Overlay{Type: OverlayTimestamp}, false},
+		{"image outside assets", Overlay{Type: OverlayImage, Image: secret}, true},
+		{"font outside assets", Overlay{Type: OverlayText, Text: "Live", Font: "/etc/passwd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SetOverlays("", []Overlay{tt.overlay})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SetOverlays() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamResolvesOverlayAssets(t *testing.T) {
+	dir, _ := assetsDir(t)
+	s := NewFFmpegStreamer(Config{HLSDir: t.TempDir(), AssetsDir: dir}, testLogger())
+	if _, err := s.SetOverlays("", []Overlay{{Type: OverlayImage, Image: "logo.png"}}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.resolveProfile("main", StartOptions{})
+	if err != nil {
+		t.Fatalf("resolveProfile() error = %v", err)
+	}
+	if want, _ := filepath.EvalSymlinks(filepath.Join(dir, "logo.png")); p.Overlays[0].Image != want {
+		t.Errorf("overlay image = %q, want %q", p.Overlays[0].Image, want)
+	}
+
+	// An asset replaced by a link leaving the directory is refused at start
+	if err := os.Remove(filepath.Join(dir, "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.resolveProfile("main", StartOptions{}); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("resolveProfile() error = %v, want ErrInvalidProfile", err)
+	}
+}
+
+func TestFilterValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/var/lib/assets/logo.png", `'/var/lib/assets/logo.png'`},
+		{"C:/fonts/mono.ttf", `'C\:/fonts/mono.ttf'`},
+		{`back\slash`, `'back\\slash'`},
+		{"it's live", `'it\'\''s live'`},
+		{"a,b;c[d]", `'a,b;c[d]'`},
+	}
+	for _, tt := range tests {
+		if got := filterValue(tt.value); got != tt.want {
+			t.Errorf("filterValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
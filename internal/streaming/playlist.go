@@ -0,0 +1,139 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PlaylistSegment is one media segment of an HLS media playlist.
+type PlaylistSegment struct {
+	URI           string
+	Duration      float64
+	Sequence      int    // Media sequence number of the segment
+	Discontinuity bool   // Preceded by #EXT-X-DISCONTINUITY
+	Key           string // Attribute list of the EXT-X-KEY in effect, empty when unencrypted
+}
+
+// MediaPlaylist is the subset of an HLS media playlist the streamer needs.
+type MediaPlaylist struct {
+	TargetDuration int
+	MediaSequence  int
+	PlaylistType   string
+	MapURI         string
+	EndList        bool
+	Segments       []PlaylistSegment
+}
+
+// readMediaPlaylist reads and parses the media playlist at path.
+func readMediaPlaylist(path string) (*MediaPlaylist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMediaPlaylist(data), nil
+}
+
+// parseMediaPlaylist parses an HLS media playlist. Unknown tags are ignored.
+func parseMediaPlaylist(data []byte) *MediaPlaylist {
+	pl := &MediaPlaylist{}
+	var (
+		duration      float64
+		discontinuity bool
+		key           string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			pl.TargetDuration, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			pl.MediaSequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+			pl.PlaylistType = strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:")
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			pl.MapURI = attributeValue(strings.TrimPrefix(line, "#EXT-X-MAP:"), "URI")
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			key = strings.TrimPrefix(line, "#EXT-X-KEY:")
+			if attributeValue(key, "METHOD") == "NONE" {
+				key = ""
+			}
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case line == "#EXT-X-ENDLIST":
+			pl.EndList = true
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#"):
+		default:
+			pl.Segments = append(pl.Segments, PlaylistSegment{
+				URI:           line,
+				Duration:      duration,
+				Sequence:      pl.MediaSequence + len(pl.Segments),
+				Discontinuity: discontinuity,
+				Key:           key,
+			})
+			duration, discontinuity = 0, false
+		}
+	}
+	return pl
+}
+
+// attributeValue returns the value of name in an HLS attribute list, unquoted.
+func attributeValue(attrs, name string) string {
+	for _, attr := range splitAttributes(attrs) {
+		key, value, ok := strings.Cut(attr, "=")
+		if ok && key == name {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// setAttribute returns attrs with the quoted-string attribute name set to value.
+func setAttribute(attrs, name, value string) string {
+	parts := splitAttributes(attrs)
+	attr := name + `="` + value + `"`
+	for i, part := range parts {
+		if key, _, ok := strings.Cut(part, "="); ok && key == name {
+			parts[i] = attr
+			return strings.Join(parts, ",")
+		}
+	}
+	return strings.Join(append(parts, attr), ",")
+}
+
+// keyTag returns the EXT-X-KEY tag that switches a playlist to key, the
+// attribute list of a segment's key.
+func keyTag(key string) string {
+	if key == "" {
+		return "#EXT-X-KEY:METHOD=NONE"
+	}
+	return "#EXT-X-KEY:" + key
+}
+
+// splitAttributes splits an attribute list on commas outside quoted strings.
+func splitAttributes(attrs string) []string {
+	var (
+		out     []string
+		start   int
+		inQuote bool
+	)
+	for i, r := range attrs {
+		switch r {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				out = append(out, attrs[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, attrs[start:])
+}
@@ -0,0 +1,99 @@
+package streaming
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMediaPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		want     *MediaPlaylist
+	}{
+		{
+			name: "live MPEG-TS",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:41
+#EXTINF:4.000000,
+live41.ts
+#EXTINF:3.960000,
+live42.ts
+`,
+			want: &MediaPlaylist{
+				TargetDuration: 4,
+				MediaSequence:  41,
+				Segments: []PlaylistSegment{
+					{URI: "live41.ts", Duration: 4, Sequence: 41},
+					{URI: "live42.ts", Duration: 3.96, Sequence: 42},
+				},
+			},
+		},
+		{
+			name: "fMP4 VOD with a discontinuity",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.000000,
+seg0.m4s
+
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:00Z
+#EXTINF:2.000000,
+seg1.m4s
+#EXT-X-ENDLIST
+`,
+			want: &MediaPlaylist{
+				TargetDuration: 2,
+				PlaylistType:   "VOD",
+				MapURI:         "init.mp4",
+				EndList:        true,
+				Segments: []PlaylistSegment{
+					{URI: "seg0.m4s", Duration: 2, Sequence: 0},
+					{URI: "seg1.m4s", Duration: 2, Sequence: 1, Discontinuity: true},
+				},
+			},
+		},
+		{
+			name: "rotating keys",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:9
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/main/s1/1.key",IV=0x01
+#EXTINF:4.000000,
+live9.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/main/s1/2.key",IV=0x02
+#EXTINF:4.000000,
+live10.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.000000,
+live11.ts
+`,
+			want: &MediaPlaylist{
+				TargetDuration: 4,
+				MediaSequence:  9,
+				Segments: []PlaylistSegment{
+					{URI: "live9.ts", Duration: 4, Sequence: 9, Key: `METHOD=AES-128,URI="/keys/main/s1/1.key",IV=0x01`},
+					{URI: "live10.ts", Duration: 4, Sequence: 10, Key: `METHOD=AES-128,URI="/keys/main/s1/2.key",IV=0x02`},
+					{URI: "live11.ts", Duration: 4, Sequence: 11},
+				},
+			},
+		},
+		{
+			name:     "empty",
+			playlist: "",
+			want:     &MediaPlaylist{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMediaPlaylist([]byte(tt.playlist)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMediaPlaylist() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Defaults of the MJPEG preview when the profile leaves them unset.
+const (
+	defaultPreviewFPS   = 10
+	defaultPreviewWidth = 640
+	maxPreviewFrameSize = 4 << 20
+)
+
+// previewFPS returns the frame rate of the MJPEG preview.
+func (p StreamProfile) previewFPS() int {
+	if p.PreviewFPS > 0 {
+		return p.PreviewFPS
+	}
+	return defaultPreviewFPS
+}
+
+// previewWidth returns the width of the MJPEG preview.
+func (p StreamProfile) previewWidth() int {
+	if p.PreviewWidth > 0 {
+		return p.PreviewWidth
+	}
+	return defaultPreviewWidth
+}
+
+// previewArgs returns the FFmpeg arguments for the MJPEG preview output,
+// written as back to back JPEGs to output. It taps the captured video before
+// the HLS encoder so that the viewfinder does not wait for segments.
+func (p StreamProfile) previewArgs(output string) []string {
+	return []string{
+		"-map", p.videoStream(),
+		"-vf", "fps=" + strconv.Itoa(p.previewFPS()) + ",scale=" + strconv.Itoa(p.previewWidth()) + ":-2",
+		"-c:v", "mjpeg", "-pix_fmt", "yuvj420p", "-q:v", "7",
+		"-f", "image2pipe", output,
+	}
+}
+
+// previewHub fans the latest preview frame out to viewers. Slow viewers skip
+// frames rather than holding up the others.
+type previewHub struct {
+	mutex   sync.Mutex
+	viewers map[chan []byte]struct{}
+	closed  bool
+}
+
+// newPreviewHub creates a hub without viewers.
+func newPreviewHub() *previewHub {
+	return &previewHub{viewers: make(map[chan []byte]struct{})}
+}
+
+// subscribe registers a viewer. The channel is closed when the stream stops
+// or cancel is called.
+func (h *previewHub) subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, 1)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.viewers[ch] = struct{}{}
+	return ch, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if _, ok := h.viewers[ch]; ok {
+			delete(h.viewers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands frame to every viewer, replacing a frame not yet taken.
+func (h *previewHub) publish(frame []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for ch := range h.viewers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- frame
+	}
+}
+
+// close disconnects every viewer.
+func (h *previewHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for ch := range h.viewers {
+		delete(h.viewers, ch)
+		close(ch)
+	}
+}
+
+// consume publishes the JPEGs FFmpeg writes to r until the pipe closes. A
+// frame larger than maxPreviewFrameSize or cut by dropped data is skipped:
+// scanning resumes at the next start marker.
+func (h *previewHub) consume(r io.ReadCloser) {
+	defer r.Close()
+	for {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), maxPreviewFrameSize)
+		scanner.Split(splitJPEG)
+		for scanner.Scan() {
+			h.publish(append([]byte(nil), scanner.Bytes()...))
+		}
+		if err := scanner.Err(); err != bufio.ErrTooLong && !errors.Is(err, errSideOutputGap) {
+			return
+		}
+	}
+}
+
+// splitJPEG is a bufio.SplitFunc returning complete JPEG images. Byte stuffing
+// in the entropy coded data guarantees that the end marker is unambiguous.
+func splitJPEG(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := bytes.Index(data, []byte{0xFF, 0xD8})
+	if start < 0 {
+		if atEOF || len(data) == 0 {
+			return len(data), nil, nil
+		}
+		// Keep a trailing 0xFF that may begin the next start marker
+		return len(data) - 1, nil, nil
+	}
+	end := bytes.Index(data[start+2:], []byte{0xFF, 0xD9})
+	if end < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return start, nil, nil
+	}
+	end += start + 4
+	return end, data[start:end], nil
+}
+
+// SubscribePreview returns the MJPEG preview frames of the named stream. The
+// channel is closed when the stream stops; cancel releases the subscription.
+func (s *FFmpegStreamer) SubscribePreview(name string) (<-chan []byte, func(), error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil || st.preview == nil {
+		return nil, nil, ErrNotStreaming
+	}
+	frames, cancel := st.preview.subscribe()
+	return frames, cancel, nil
+}
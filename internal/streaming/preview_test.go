@@ -0,0 +1,71 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// jpegFrame returns a minimal JPEG stream carrying payload.
+func jpegFrame(payload ...byte) []byte {
+	return append(append([]byte{0xFF, 0xD8}, payload...), 0xFF, 0xD9)
+}
+
+func TestSplitJPEG(t *testing.T) {
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		name   string
+		stream []byte
+		want   [][]byte
+	}{
+		{"one frame", jpegFrame(1, 2, 3), [][]byte{jpegFrame(1, 2, 3)}},
+		{"back to back", join(jpegFrame(1), jpegFrame(2)), [][]byte{jpegFrame(1), jpegFrame(2)}},
+		{"leading garbage", join([]byte{0, 0xFF, 7}, jpegFrame(1)), [][]byte{jpegFrame(1)}},
+		{"stuffed bytes", jpegFrame(0xFF, 0x00, 0xFF, 0x00), [][]byte{jpegFrame(0xFF, 0x00, 0xFF, 0x00)}},
+		{"truncated last frame", join(jpegFrame(1), []byte{0xFF, 0xD8, 2, 3}), [][]byte{jpegFrame(1)}},
+		{"no frame", []byte{1, 2, 3, 0xFF}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time splits the markers across reads
+			scanner := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(tt.stream)))
+			scanner.Split(splitJPEG)
+			var got [][]byte
+			for scanner.Scan() {
+				got = append(got, append([]byte(nil), scanner.Bytes()...))
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatalf("scan error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("frames = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewResyncsAfterGap(t *testing.T) {
+	hub := newPreviewHub()
+	frames, cancel := hub.subscribe()
+	defer cancel()
+
+	cut := jpegFrame(2, 2, 2, 2)
+	go hub.consume(gappedOutput(
+		sideChunk{data: append(jpegFrame(1), cut[:3]...)},
+		sideChunk{data: append(append([]byte(nil), cut[4:]...), jpegFrame(3)...), gap: true},
+	))
+
+	for _, want := range [][]byte{jpegFrame(1), jpegFrame(3)} {
+		select {
+		case frame := <-frames:
+			if !bytes.Equal(frame, want) {
+				t.Errorf("got frame %x, want %x", frame, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no frame %x", want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultProfileName is the profile used when a start call does not name one.
+const DefaultProfileName = "default"
+
+var (
+	// ErrInvalidProfile is returned when a StreamProfile fails validation.
+	ErrInvalidProfile = errors.New("invalid stream profile")
+	// ErrUnknownProfile is returned when a start call names a profile that is not configured.
+	ErrUnknownProfile = errors.New("unknown stream profile")
+)
+
+// StreamProfile describes how a stream is captured, encoded and packaged.
+type StreamProfile struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"` // "device" (default), "test", "rtmp", "srt" or "rtsp"
+	// URL is the address of a network source. With Listen set the server
+	// waits for a sender on that address instead of connecting to it.
+	URL              string `json:"url,omitempty"`
+	Listen           bool   `json:"listen,omitempty"`
+	VideoDevice      string `json:"video_device"`
+	AudioDevice      string `json:"audio_device"`
+	Width            int    `json:"width,omitempty"`     // 0 keeps the device default
+	Height           int    `json:"height,omitempty"`    // 0 keeps the device default
+	Framerate        int    `json:"framerate,omitempty"` // 0 keeps the device default
+	Encoder          string `json:"encoder"`             // "auto" uses the probed encoder
+	Preset           string `json:"preset,omitempty"`    // Only used by libx264
+	MaxrateKbps      int    `json:"maxrate_kbps"`
+	BufsizeKbps      int    `json:"bufsize_kbps"`
+	GOP              int    `json:"gop"`
+	AudioBitrateKbps int    `json:"audio_bitrate_kbps"`
+	AudioSampleRate  int    `json:"audio_sample_rate"`
+	HLSTime          int    `json:"hls_time"`      // Segment duration in seconds
+	HLSListSize      int    `json:"hls_list_size"` // Number of segments kept in the playlist
+	// RecordMaxrateKbps is the video bitrate of the full-quality recording output.
+	RecordMaxrateKbps int `json:"record_maxrate_kbps"`
+	// Renditions turns the output into an adaptive bitrate ladder. When empty a
+	// single variant is written at the profile's resolution and bitrate.
+	Renditions []Rendition `json:"renditions,omitempty"`
+	// LowLatency switches the output to LL-HLS with partial segments of
+	// PartDurationMs (500 ms when zero).
+	LowLatency     bool `json:"low_latency,omitempty"`
+	PartDurationMs int  `json:"part_duration_ms,omitempty"`
+	// PreviewFPS and PreviewWidth size the MJPEG viewfinder preview
+	// (10 fps and 640 pixels wide when zero).
+	PreviewFPS   int `json:"preview_fps,omitempty"`
+	PreviewWidth int `json:"preview_width,omitempty"`
+	// Overlays are drawn on the program video in order. Overlays set through
+	// the API replace these.
+	Overlays []Overlay `json:"overlays,omitempty"`
+
+	// silentAudio replaces the audio of a network source that turned out to
+	// have none with generated silence. It is set by the streamer.
+	silentAudio bool
+	// webrtcVideo is the side output the program video is copied to for
+	// the WebRTC feed. It is set by the streamer.
+	webrtcVideo string
+}
+
+// DefaultProfile returns the profile matching the original Raspberry Pi rig.
+func DefaultProfile() StreamProfile {
+	return StreamProfile{
+		Name:              DefaultProfileName,
+		VideoDevice:       "/dev/video0",
+		AudioDevice:       "hw:1,0",
+		Encoder:           EncoderAuto,
+		Preset:            "veryfast",
+		MaxrateKbps:       2000,
+		BufsizeKbps:       4000,
+		GOP:               50,
+		AudioBitrateKbps:  128,
+		AudioSampleRate:   44100,
+		HLSTime:           4,
+		HLSListSize:       15,
+		RecordMaxrateKbps: 8000,
+	}
+}
+
+// Validate checks that the profile can be turned into a working FFmpeg command.
+func (p StreamProfile) Validate() error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidProfile)
+	case !isKnownSource(p.Source):
+		return fmt.Errorf("%w %q: unsupported source %s", ErrInvalidProfile, p.Name, p.Source)
+	case p.source() == SourceDevice && p.VideoDevice == "":
+		return fmt.Errorf("%w %q: video_device is required", ErrInvalidProfile, p.Name)
+	case p.source() == SourceDevice && p.AudioDevice == "":
+		return fmt.Errorf("%w %q: audio_device is required", ErrInvalidProfile, p.Name)
+	case p.Width < 0 || p.Height < 0 || (p.Width == 0) != (p.Height == 0):
+		return fmt.Errorf("%w %q: width and height must both be set or both be zero", ErrInvalidProfile, p.Name)
+	case p.Framerate < 0:
+		return fmt.Errorf("%w %q: framerate must not be negative", ErrInvalidProfile, p.Name)
+	case p.PreviewFPS < 0 || p.PreviewWidth < 0:
+		return fmt.Errorf("%w %q: preview_fps and preview_width must not be negative", ErrInvalidProfile, p.Name)
+	case !isKnownEncoder(p.Encoder):
+		return fmt.Errorf("%w %q: unsupported encoder %s", ErrInvalidProfile, p.Name, p.Encoder)
+	case p.MaxrateKbps <= 0:
+		return fmt.Errorf("%w %q: maxrate_kbps must be positive", ErrInvalidProfile, p.Name)
+	case p.BufsizeKbps < p.MaxrateKbps:
+		return fmt.Errorf("%w %q: bufsize_kbps must be at least maxrate_kbps", ErrInvalidProfile, p.Name)
+	case p.GOP <= 0:
+		return fmt.Errorf("%w %q: gop must be positive", ErrInvalidProfile, p.Name)
+	case p.AudioBitrateKbps <= 0:
+		return fmt.Errorf("%w %q: audio_bitrate_kbps must be positive", ErrInvalidProfile, p.Name)
+	case p.AudioSampleRate <= 0:
+		return fmt.Errorf("%w %q: audio_sample_rate must be positive", ErrInvalidProfile, p.Name)
+	case p.HLSTime <= 0:
+		return fmt.Errorf("%w %q: hls_time must be positive", ErrInvalidProfile, p.Name)
+	case p.HLSListSize <= 0:
+		return fmt.Errorf("%w %q: hls_list_size must be positive", ErrInvalidProfile, p.Name)
+	case p.RecordMaxrateKbps <= 0:
+		return fmt.Errorf("%w %q: record_maxrate_kbps must be positive", ErrInvalidProfile, p.Name)
+	}
+	if err := p.validateNetworkSource(); err != nil {
+		return err
+	}
+	if err := validateOverlays(p.Overlays); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidProfile, p.Name, err)
+	}
+	if err := p.validateRenditions(); err != nil {
+		return err
+	}
+	return p.validateLowLatency()
+}
+
+// inputArgs returns the FFmpeg arguments for the profile's source.
+func (p StreamProfile) inputArgs() []string {
+	switch {
+	case p.source() == SourceTest:
+		return p.testSourceArgs()
+	case p.isNetworkSource():
+		return p.networkInputArgs()
+	}
+	args := []string{"-f", "v4l2"}
+	if p.Width > 0 && p.Height > 0 {
+		args = append(args, "-video_size", fmt.Sprintf("%dx%d", p.Width, p.Height))
+	}
+	if p.Framerate > 0 {
+		args = append(args, "-framerate", strconv.Itoa(p.Framerate))
+	}
+	args = append(args, "-i", p.VideoDevice)
+	args = append(args, "-f", "alsa", "-i", p.AudioDevice)
+	return args
+}
+
+// videoStream returns the FFmpeg stream specifier of the captured video.
+func (p StreamProfile) videoStream() string {
+	if p.isNetworkSource() {
+		return "0:v:0"
+	}
+	return "0:v"
+}
+
+// audioStream returns the FFmpeg stream specifier of the captured audio.
+// Network sources carry audio and video in a single input, and their audio
+// is optional until it is replaced by silence on the second input.
+func (p StreamProfile) audioStream() string {
+	if p.isNetworkSource() && !p.silentAudio {
+		return "0:a:0?"
+	}
+	return "1:a"
+}
+
+// commandArgs returns the full FFmpeg argument list for the profile using
+// encoder, writing the HLS output into outDir. outDir is the HLS directory,
+// or the run directory of the LL-HLS packager in low-latency mode. Segments
+// are encrypted with the keys named in the key info file keyInfo when set.
+// retain keeps the segments that leave the playlist for the DVR window.
+func (p StreamProfile) commandArgs(encoder, outDir, keyInfo string, retain bool) []string {
+	spec := encoderSpecs[encoder]
+
+	args := progressArgs()
+	if spec.globalArgs != nil {
+		args = append(args, spec.globalArgs(p)...)
+	}
+	args = append(args, p.inputArgs()...)
+	switch {
+	case p.LowLatency:
+		return append(args, p.llHLSArgs(encoder, outDir)...)
+	case len(p.Renditions) > 0:
+		return append(args, p.ladderArgs(encoder, outDir, keyInfo, retain)...)
+	}
+
+	args = append(args, "-map", p.videoStream(), "-map", p.audioStream())
+	args = append(args, p.programVideoArgs(spec)...)
+	args = append(args, "-c:v", encoder)
+	args = append(args, spec.args(p)...)
+	args = append(args, p.audioArgs()...)
+	args = append(args, p.programOutput(p.hlsArgs(filepath.Join(outDir, LivePlaylist), keyInfo, retain))...)
+	return args
+}
+
+// audioArgs returns the FFmpeg arguments for the audio encoder.
+func (p StreamProfile) audioArgs() []string {
+	return []string{
+		"-c:a", "aac",
+		"-b:a", kbps(p.AudioBitrateKbps),
+		"-ar", strconv.Itoa(p.AudioSampleRate),
+	}
+}
+
+// hlsArgs returns the FFmpeg arguments for the HLS muxer writing to playlistPath.
+func (p StreamProfile) hlsArgs(playlistPath, keyInfo string, retain bool) []string {
+	args := []string{
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(p.HLSTime),
+		"-hls_list_size", strconv.Itoa(p.HLSListSize),
+	}
+	encryption, flags := encryptionArgs(keyInfo, nil)
+	args = append(args, encryption...)
+	args = append(args, segmentLifetimeArgs(flags, retain)...)
+	return append(args, "-master_pl_name", MasterPlaylist, playlistPath)
+}
+
+// segmentLifetimeArgs returns the HLS muxer flags, adding deletion of the
+// segments that leave the playlist unless retain is set. Retained segments
+// are numbered from the epoch so that the runs of a session never reuse a
+// segment name.
+func segmentLifetimeArgs(flags []string, retain bool) []string {
+	var args []string
+	if retain {
+		args = append(args, "-hls_start_number_source", "epoch")
+	} else {
+		flags = append([]string{"delete_segments"}, flags...)
+	}
+	if len(flags) > 0 {
+		args = append(args, "-hls_flags", strings.Join(flags, "+"))
+	}
+	return args
+}
+
+// encryptionArgs returns the HLS muxer arguments that encrypt segments with
+// the key named in the key info file keyInfo, and flags with periodic_rekey,
+// which makes FFmpeg re-read the file before every segment so that keys can
+// rotate. Nothing is added when keyInfo is empty.
+func encryptionArgs(keyInfo string, flags []string) ([]string, []string) {
+	if keyInfo == "" {
+		return nil, flags
+	}
+	return []string{"-hls_key_info_file", keyInfo}, append(flags, "periodic_rekey")
+}
+
+// LoadProfiles reads a JSON array of profiles from path and validates each one.
+func LoadProfiles(path string) ([]StreamProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []StreamProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file %s: %w", path, err)
+	}
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// kbps formats a kilobit-per-second value the way FFmpeg expects it.
+func kbps(v int) string {
+	return strconv.Itoa(v) + "k"
+}
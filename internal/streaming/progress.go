@@ -0,0 +1,95 @@
+package streaming
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is a snapshot of FFmpeg's -progress output for the running stream.
+type Stats struct {
+	Stream      string    `json:"stream"`
+	Session     string    `json:"session"`
+	Frame       int64     `json:"frame"`
+	FPS         float64   `json:"fps"`
+	BitrateKbps float64   `json:"bitrate_kbps"`
+	TotalSize   int64     `json:"total_size"`
+	OutTime     string    `json:"out_time"`
+	OutTimeSecs float64   `json:"out_time_secs"`
+	DupFrames   int64     `json:"dup_frames"`
+	DropFrames  int64     `json:"drop_frames"`
+	Speed       float64   `json:"speed"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// progressArgs makes FFmpeg write machine readable progress to stdout.
+func progressArgs() []string {
+	return []string{"-nostats", "-progress", "pipe:1"}
+}
+
+// parseProgress reads key=value blocks from FFmpeg's -progress output and
+// calls report at the end of every block. It returns when r is exhausted.
+func parseProgress(r io.Reader, report func(Stats)) {
+	var st Stats
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			st.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			st.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			// e.g. "1834.5kbits/s" or "N/A"
+			st.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "total_size":
+			st.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				st.OutTimeSecs = float64(us) / 1e6
+			}
+		case "out_time":
+			st.OutTime = value
+		case "dup_frames":
+			st.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "drop_frames":
+			st.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			// e.g. "1.01x" or "N/A"
+			st.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			st.UpdatedAt = time.Now()
+			report(st)
+			st = Stats{}
+		}
+	}
+}
+
+// recordStats stores the latest progress block for the session of st.
+func (s *FFmpegStreamer) recordStats(st *stream, sess *StreamSession, stats Stats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if st.session != sess {
+		return
+	}
+	stats.Stream = sess.Stream
+	stats.Session = sess.ID
+	st.stats = stats
+}
+
+// Stats returns the latest FFmpeg progress for the named stream.
+// The zero value is returned when the stream is not running.
+func (s *FFmpegStreamer) Stats(name string) Stats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil || st.session == nil {
+		return Stats{}
+	}
+	return st.stats
+}
@@ -0,0 +1,68 @@
+package streaming
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Stats
+	}{
+		{
+			name: "continue and end blocks",
+			output: `frame=250
+fps=25.01
+bitrate=1834.5kbits/s
+total_size=2293760
+out_time_us=10000000
+out_time=00:00:10.000000
+dup_frames=1
+drop_frames=2
+speed=1.01x
+progress=continue
+frame=500
+fps=25.00
+progress=end
+`,
+			want: []Stats{
+				{Frame: 250, FPS: 25.01, BitrateKbps: 1834.5, TotalSize: 2293760, OutTime: "00:00:10.000000", OutTimeSecs: 10, DupFrames: 1, DropFrames: 2, Speed: 1.01},
+				{Frame: 500, FPS: 25},
+			},
+		},
+		{
+			name:   "values not available yet",
+			output: "frame=0\nbitrate=N/A\nout_time_us=N/A\nspeed=N/A\nprogress=continue\n",
+			want:   []Stats{{}},
+		},
+		{
+			name:   "incomplete block",
+			output: "frame=25\nfps=25.00\n",
+			want:   nil,
+		},
+		{
+			name:   "noise between keys",
+			output: "\n  frame=75  \nunexpected line\nprogress=continue\n",
+			want:   []Stats{{Frame: 75}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Stats
+			parseProgress(strings.NewReader(tt.output), func(st Stats) {
+				if st.UpdatedAt.IsZero() {
+					t.Error("report without an update time")
+				}
+				st.UpdatedAt = time.Time{}
+				got = append(got, st)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProgress() reported %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package streaming
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordSegmentTime is the length in seconds of the spooled recording segments.
+const recordSegmentTime = 2
+
+var (
+	// ErrNotStreaming is returned when an operation needs a running stream.
+	ErrNotStreaming = errors.New("no stream is running")
+	// ErrRecordingDisabled is returned when the streamer has no recording directory.
+	ErrRecordingDisabled = errors.New("recording is not configured")
+	// ErrAlreadyRecording is returned when a take is already being recorded.
+	ErrAlreadyRecording = errors.New("a recording is already in progress")
+	// ErrNotRecording is returned when there is no take to stop.
+	ErrNotRecording = errors.New("no recording in progress")
+)
+
+// RecordingState is the lifecycle state of a Recording.
+type RecordingState string
+
+const (
+	RecordingActive     RecordingState = "recording"
+	RecordingFinalizing RecordingState = "finalizing"
+	RecordingSaved      RecordingState = "saved"
+	RecordingFailed     RecordingState = "failed"
+)
+
+// Recording describes one take written from the live stream to the recording directory.
+type Recording struct {
+	ID        string         `json:"id"`
+	Stream    string         `json:"stream"`
+	Session   string         `json:"session"`
+	File      string         `json:"file"`
+	State     RecordingState `json:"state"`
+	StartedAt time.Time      `json:"started_at"`
+	StoppedAt *time.Time     `json:"stopped_at,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// recordArgs returns the FFmpeg arguments for the full-quality recording output.
+// It is written as short MPEG-TS segments into dir so that takes can start and
+// stop at any time without restarting FFmpeg.
+func (p StreamProfile) recordArgs(encoder, dir string) []string {
+	master := p
+	master.MaxrateKbps = p.RecordMaxrateKbps
+	master.BufsizeKbps = 2 * p.RecordMaxrateKbps
+	spec := encoderSpecs[encoder]
+
+	args := []string{"-map", p.videoStream(), "-map", p.audioStream()}
+	if len(spec.videoFilters) > 0 {
+		args = append(args, "-vf", strings.Join(spec.videoFilters, ","))
+	}
+	args = append(args, "-c:v", encoder)
+	args = append(args, spec.args(master)...)
+	args = append(args, master.audioArgs()...)
+	args = append(args,
+		"-f", "segment",
+		"-segment_time", strconv.Itoa(recordSegmentTime),
+		"-segment_format", "mpegts",
+		"-segment_list", filepath.Join(dir, "segments.csv"),
+		"-segment_list_type", "csv",
+		filepath.Join(dir, "rec_%06d.ts"),
+	)
+	return args
+}
+
+// recorder spools the recording output of one session and assembles takes from it.
+type recorder struct {
+	mutex      sync.Mutex
+	dir        string         // Spool directory of the session
+	runs       int            // Number of FFmpeg runs started for the session
+	segments   []string       // Completed segments, oldest first
+	firstIndex int            // Global index of segments[0]
+	take       *Recording     // The take being recorded
+	takeStart  int            // Global index of the first segment of the take
+	finalizing map[string]int // First segment of each stopped take not yet written, by ID
+	preRoll    int            // Completed segments kept for the lead-in of the next take
+	postRoll   int            // Segments recorded after a take is stopped
+	closed     bool           // Set when the session ended; the spool is removed once idle
+	logger     *logrus.Entry
+}
+
+// rollSegments returns the number of spooled segments covering at least d.
+func rollSegments(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + recordSegmentTime*time.Second - 1) / (recordSegmentTime * time.Second))
+}
+
+// newRecorder creates a recorder spooling into dir. Takes start preRoll
+// before they are started and end postRoll after they are stopped.
+func newRecorder(dir string, preRoll, postRoll time.Duration, logger *logrus.Entry) (*recorder, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recorder{
+		dir:        dir,
+		finalizing: make(map[string]int),
+		preRoll:    rollSegments(preRoll),
+		postRoll:   rollSegments(postRoll),
+		logger:     logger,
+	}, nil
+}
+
+// nextRun creates the spool directory for a new FFmpeg run.
+func (r *recorder) nextRun() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.runs++
+	dir := filepath.Join(r.dir, fmt.Sprintf("run-%03d", r.runs))
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// watch collects completed segments of a run until its process exits.
+func (r *recorder) watch(runDir string, proc *process) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	seen := 0
+	for {
+		select {
+		case <-proc.done:
+			r.collect(runDir, &seen)
+			return
+		case <-ticker.C:
+			r.collect(runDir, &seen)
+		}
+	}
+}
+
+// collect appends segments newly listed in the run's segment list.
+func (r *recorder) collect(runDir string, seen *int) {
+	f, err := os.Open(filepath.Join(runDir, "segments.csv"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name, _, ok := strings.Cut(scanner.Text(), ","); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= *seen {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, name := range names[*seen:] {
+		r.segments = append(r.segments, filepath.Join(runDir, name))
+	}
+	*seen = len(names)
+	r.prune()
+}
+
+// prune deletes spooled segments that no take needs, keeping the pre-roll
+// for the next take. Callers must hold the mutex.
+func (r *recorder) prune() {
+	keepFrom := r.completed() - r.preRoll
+	if r.take != nil && r.takeStart < keepFrom {
+		keepFrom = r.takeStart
+	}
+	for _, start := range r.finalizing {
+		if start < keepFrom {
+			keepFrom = start
+		}
+	}
+	for r.firstIndex < keepFrom && len(r.segments) > 0 {
+		os.Remove(r.segments[0])
+		r.segments = r.segments[1:]
+		r.firstIndex++
+	}
+}
+
+// completed returns the global index of the next segment to be completed.
+// Callers must hold the mutex.
+func (r *recorder) completed() int {
+	return r.firstIndex + len(r.segments)
+}
+
+// start begins a take of sess with the pre-roll, or with the segment
+// currently being written when there is none.
+func (r *recorder) start(sess *StreamSession, outDir string) (Recording, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.take != nil {
+		return *r.take, ErrAlreadyRecording
+	}
+
+	now := time.Now()
+	id, err := reserveClip(outDir, fmt.Sprintf("take-%s-%s", sess.Stream, now.Format("20060102-150405")))
+	if err != nil {
+		return Recording{}, err
+	}
+	r.take = &Recording{
+		ID:        id,
+		Stream:    sess.Stream,
+		Session:   sess.ID,
+		File:      filepath.Join(outDir, id+".mp4"),
+		State:     RecordingActive,
+		StartedAt: now,
+	}
+	r.takeStart = r.completed() - r.preRoll
+	if r.takeStart < r.firstIndex {
+		r.takeStart = r.firstIndex
+	}
+	return *r.take, nil
+}
+
+// current returns a copy of the take being recorded, or nil.
+func (r *recorder) current() *Recording {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.take == nil {
+		return nil
+	}
+	take := *r.take
+	return &take
+}
+
+// stop ends the take being recorded, so that the next take can start right
+// away, and writes its MP4 in the background once the post-roll and the
+// segment being written complete (or done is closed). The finished take is
+// passed to onDone.
+func (r *recorder) stop(ffmpegPath string, done <-chan struct{}, onDone func(Recording)) (Recording, error) {
+	r.mutex.Lock()
+	if r.take == nil {
+		r.mutex.Unlock()
+		return Recording{}, ErrNotRecording
+	}
+	now := time.Now()
+	take := *r.take
+	take.State = RecordingFinalizing
+	take.StoppedAt = &now
+	start, end := r.takeStart, r.completed()+r.postRoll
+	r.take = nil
+	r.finalizing[take.ID] = start
+	r.mutex.Unlock()
+
+	go func() {
+		segments := r.waitFor(start, end, done)
+		err := concatSegments(context.Background(), ffmpegPath, segments, take.File)
+
+		r.mutex.Lock()
+		if err != nil {
+			os.Remove(take.File + ".part")
+			take.State = RecordingFailed
+			take.Error = err.Error()
+		} else {
+			take.State = RecordingSaved
+		}
+		delete(r.finalizing, take.ID)
+		r.prune()
+		cleanup := r.closed && r.idle()
+		r.mutex.Unlock()
+
+		onDone(take)
+		if cleanup {
+			r.cleanup()
+		}
+	}()
+	return take, nil
+}
+
+// idle reports whether no take is recorded or finalized. Callers must hold
+// the mutex.
+func (r *recorder) idle() bool {
+	return r.take == nil && len(r.finalizing) == 0
+}
+
+// waitFor blocks until segment end has completed, done is closed, or the wait
+// times out, then returns the segments from start to end.
+func (r *recorder) waitFor(start, end int, done <-chan struct{}) []string {
+	deadline := time.After(time.Duration(2+r.postRoll)*recordSegmentTime*time.Second + 2*time.Second)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		r.mutex.Lock()
+		ready := r.completed() > end
+		r.mutex.Unlock()
+		if ready {
+			break
+		}
+		select {
+		case <-done:
+			// Give the watcher a moment to read the final segment list.
+			time.Sleep(time.Second)
+		case <-deadline:
+			r.logger.Warn("Timed out waiting for the last recording segment")
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	from := start - r.firstIndex
+	to := end + 1 - r.firstIndex
+	if to > len(r.segments) {
+		to = len(r.segments)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from >= to {
+		return nil
+	}
+	return append([]string(nil), r.segments[from:to]...)
+}
+
+// close marks the session as ended and removes the spool unless a take is
+// still being finalized, in which case the last finalizer removes it.
+func (r *recorder) close() {
+	r.mutex.Lock()
+	r.closed = true
+	idle := r.idle()
+	r.mutex.Unlock()
+	if idle {
+		r.cleanup()
+	}
+}
+
+// cleanup removes the session's spool directory.
+func (r *recorder) cleanup() {
+	if err := os.RemoveAll(r.dir); err != nil {
+		r.logger.Warnf("Failed to remove recording spool %s: %v", r.dir, err)
+	}
+}
+
+// streamState returns the active session and recording spool of the named
+// stream, or nils when it is idle.
+func (s *FFmpegStreamer) streamState(name string) (*StreamSession, *recorder, *process) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil {
+		return nil, nil, nil
+	}
+	return st.session, st.recorder, st.proc
+}
+
+// StartRecording starts a take from the named stream without restarting it.
+func (s *FFmpegStreamer) StartRecording(name string) (Recording, error) {
+	sess, rec, _ := s.streamState(name)
+
+	if s.recordingDir == "" {
+		return Recording{}, ErrRecordingDisabled
+	}
+	if sess == nil || rec == nil {
+		return Recording{}, ErrNotStreaming
+	}
+
+	take, err := rec.start(sess, s.recordingDir)
+	if err != nil {
+		return take, err
+	}
+	s.logger.Infof("Recording %s started", take.ID)
+	s.emit(Event{Type: EventRecordingStarted, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Recording %s started", take.ID)})
+	return take, nil
+}
+
+// StopRecording ends the current take of the named stream. The MP4 is written
+// in the background and announced with an EventRecordingSaved or
+// EventRecordingFailed event.
+func (s *FFmpegStreamer) StopRecording(name string) (Recording, error) {
+	sess, rec, proc := s.streamState(name)
+
+	if sess == nil || rec == nil {
+		return Recording{}, ErrNotRecording
+	}
+	var done <-chan struct{}
+	if proc != nil {
+		done = proc.done
+	}
+	return s.stopRecording(sess, rec, done)
+}
+
+// stopRecording ends the take of rec and reports the result once it is written.
+func (s *FFmpegStreamer) stopRecording(sess *StreamSession, rec *recorder, done <-chan struct{}) (Recording, error) {
+	take, err := rec.stop(s.ffmpegPath, done, func(take Recording) {
+		if take.State == RecordingFailed {
+			s.logger.Errorf("Recording %s failed: %s", take.ID, take.Error)
+			s.emit(Event{Type: EventRecordingFailed, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Recording %s failed: %s", take.ID, take.Error), Error: take.Error})
+			return
+		}
+		s.logger.Infof("Recording %s saved to %s", take.ID, take.File)
+		s.emit(Event{Type: EventRecordingSaved, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Recording saved: %s", filepath.Base(take.File))})
+	})
+	if err != nil {
+		return take, err
+	}
+	s.logger.Infof("Recording %s stopped, finalizing", take.ID)
+	return take, nil
+}
+
+// stopActiveTake ends a take that is still recording, e.g. when the stream stops.
+func (s *FFmpegStreamer) stopActiveTake(sess *StreamSession, rec *recorder, done <-chan struct{}) {
+	if rec.current() != nil {
+		if _, err := s.stopRecording(sess, rec, done); err != nil {
+			s.logger.Errorf("Failed to stop recording: %v", err)
+		}
+	}
+}
+
+// Recording returns the take in progress on the named stream, or nil.
+func (s *FFmpegStreamer) Recording(name string) *Recording {
+	_, rec, _ := s.streamState(name)
+	if rec == nil {
+		return nil
+	}
+	return rec.current()
+}
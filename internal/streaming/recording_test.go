@@ -0,0 +1,156 @@
+package streaming
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollSegments(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Second, 1},
+		{2 * time.Second, 1},
+		{2*time.Second + time.Millisecond, 2},
+		{10 * time.Second, 5},
+	}
+	for _, tt := range tests {
+		if got := rollSegments(tt.d); got != tt.want {
+			t.Errorf("rollSegments(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestReserveClipNumbersTakenIDs(t *testing.T) {
+	dir := t.TempDir()
+	base := "take-main-20240101-120000"
+	if err := os.WriteFile(filepath.Join(dir, base+".mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{base + "-2", base + "-3"} {
+		id, err := reserveClip(dir, base)
+		if err != nil {
+			t.Fatalf("reserveClip() error = %v", err)
+		}
+		if id != want {
+			t.Errorf("reserveClip() = %q, want %q", id, want)
+		}
+		if _, err := os.Stat(filepath.Join(dir, id+".mp4.part")); err != nil {
+			t.Errorf("reserveClip() did not create the temporary file: %v", err)
+		}
+	}
+}
+
+// addSegments spools n new segments in rec and returns their paths.
+func addSegments(t *testing.T, rec *recorder, n int) []string {
+	t.Helper()
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	var paths []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(rec.dir, fmt.Sprintf("rec_%06d.ts", rec.completed()))
+		if err := os.WriteFile(path, []byte("segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rec.segments = append(rec.segments, path)
+		paths = append(paths, path)
+	}
+	rec.prune()
+	return paths
+}
+
+// stopTake stops the take of rec and returns a channel receiving the
+// finished take. The fake FFmpeg fails the concatenation.
+func stopTake(t *testing.T, rec *recorder, done <-chan struct{}) (Recording, <-chan Recording) {
+	t.Helper()
+	t.Setenv("FAKE_FFMPEG", "1")
+	finished := make(chan Recording, 1)
+	take, err := rec.stop(os.Args[0], done, func(take Recording) { finished <- take })
+	if err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+	return take, finished
+}
+
+func TestRecorderTakesInOneSecondGetDistinctFiles(t *testing.T) {
+	rec, err := newRecorder(filepath.Join(t.TempDir(), "spool"), 0, 0, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+	sess := newSession("main", DefaultProfileName, "libx264")
+
+	first, err := rec.start(sess, outDir)
+	if err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	done := make(chan struct{})
+	close(done)
+	stopTake(t, rec, done)
+	second, err := rec.start(sess, outDir)
+	if err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	if first.File == second.File {
+		t.Errorf("takes started in the same second share the file %s", first.File)
+	}
+}
+
+func TestRecorderStartsNextTakeWhileFinalizing(t *testing.T) {
+	rec, err := newRecorder(filepath.Join(t.TempDir(), "spool"), 0, 2*time.Second, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+	sess := newSession("main", DefaultProfileName, "libx264")
+
+	addSegments(t, rec, 2)
+	if _, err := rec.start(sess, outDir); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	firstSegments := addSegments(t, rec, 1)
+
+	// The first take waits for its post-roll segment
+	take, finished := stopTake(t, rec, make(chan struct{}))
+	if take.State != RecordingFinalizing || take.StoppedAt == nil {
+		t.Errorf("stopped take = %+v, want it finalizing", take)
+	}
+	if cur := rec.current(); cur != nil {
+		t.Fatalf("current() = %+v after stop, want no take", cur)
+	}
+	second, err := rec.start(sess, outDir)
+	if err != nil {
+		t.Fatalf("start() during the post-roll error = %v", err)
+	}
+	if cur := rec.current(); cur == nil || cur.ID != second.ID {
+		t.Errorf("current() = %+v, want the second take", cur)
+	}
+
+	secondSegments := addSegments(t, rec, 1)
+	if _, err := os.Stat(firstSegments[0]); err != nil {
+		t.Errorf("segment of the finalizing take was pruned: %v", err)
+	}
+
+	addSegments(t, rec, 1) // Completes the post-roll
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first take was not finalized")
+	}
+	rec.mutex.Lock()
+	rec.prune()
+	rec.mutex.Unlock()
+	if _, err := os.Stat(firstSegments[0]); !os.IsNotExist(err) {
+		t.Errorf("segment only the finalized take needed was kept: %v", err)
+	}
+	if _, err := os.Stat(secondSegments[0]); err != nil {
+		t.Errorf("segment of the second take was pruned: %v", err)
+	}
+}
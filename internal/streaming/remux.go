@@ -0,0 +1,81 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// concatSegments joins MPEG-TS segments into a single MP4 at outPath without
+// re-encoding. The file is written under a temporary name and renamed when
+// complete so that partially written files are never listed.
+func concatSegments(ctx context.Context, ffmpegPath string, segments []string, outPath string) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no segments to write to %s", outPath)
+	}
+
+	list, err := os.CreateTemp(filepath.Dir(outPath), ".concat-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	for _, seg := range segments {
+		abs, err := filepath.Abs(seg)
+		if err != nil {
+			list.Close()
+			return err
+		}
+		fmt.Fprintf(list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	if err := list.Close(); err != nil {
+		return err
+	}
+	return remux(ctx, ffmpegPath, []string{"-f", "concat", "-safe", "0", "-i", list.Name()}, outPath)
+}
+
+// remuxSegments copies segments of dir into a single MP4 at outPath without
+// re-encoding. fMP4 segments are read behind their initialization segment
+// mapURI, and encrypted segments through a local playlist naming their keys.
+func (s *FFmpegStreamer) remuxSegments(ctx context.Context, dir string, segments []PlaylistSegment, mapURI, outPath string) error {
+	if encrypted(segments) {
+		list, err := s.localVOD(dir, segments, mapURI)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(list)
+		return remux(ctx, s.ffmpegPath, []string{"-i", list}, outPath)
+	}
+
+	paths := make([]string, len(segments))
+	for i, seg := range segments {
+		paths[i] = filepath.Join(dir, seg.URI)
+	}
+	if mapURI == "" {
+		return concatSegments(ctx, s.ffmpegPath, paths, outPath)
+	}
+	input := "concat:" + filepath.Join(dir, mapURI) + "|" + strings.Join(paths, "|")
+	return remux(ctx, s.ffmpegPath, []string{"-i", input}, outPath)
+}
+
+// remux copies the streams of the input given by inputArgs into an MP4 at
+// outPath, renaming it into place once complete.
+func remux(ctx context.Context, ffmpegPath string, inputArgs []string, outPath string) error {
+	tmpPath := outPath + ".part"
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+	args = append(args, inputArgs...)
+	args = append(args,
+		"-c", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-movflags", "+faststart",
+		"-f", "mp4", tmpPath,
+	)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg remux failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmpPath, outPath)
+}
@@ -0,0 +1,178 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultReplaySeconds is the length of a replay clip when none is given.
+	DefaultReplaySeconds = 30
+	// MaxReplaySeconds bounds the length of a replay clip. The live playlist
+	// rarely holds more, and a longer clip is a recording.
+	MaxReplaySeconds = 300
+	// replayTimeout bounds the remux of a replay clip.
+	replayTimeout = 2 * time.Minute
+	// replayPollInterval is how often the playlist is checked for the segment
+	// being written when a replay is requested.
+	replayPollInterval = 250 * time.Millisecond
+)
+
+// ErrInvalidReplay is returned when a replay length is out of range.
+var ErrInvalidReplay = errors.New("invalid replay length")
+
+// Replay describes a clip of the most recent seconds of a live stream,
+// written from its HLS segments to the recording directory.
+type Replay struct {
+	ID        string         `json:"id"`
+	Stream    string         `json:"stream"`
+	Session   string         `json:"session"`
+	File      string         `json:"file"`
+	Seconds   int            `json:"seconds"` // Requested length
+	State     RecordingState `json:"state"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// replaySegments returns the most recent segments of pl that add up to at
+// least seconds, or all of them when the playlist is shorter, with their
+// total duration. Segments of fMP4 playlists do not reach back across a
+// discontinuity, where the initialization segment changes.
+func replaySegments(pl *MediaPlaylist, seconds int) ([]PlaylistSegment, float64) {
+	var duration float64
+	first := len(pl.Segments)
+	for first > 0 && duration < float64(seconds) {
+		first--
+		duration += pl.Segments[first].Duration
+		if pl.MapURI != "" && pl.Segments[first].Discontinuity {
+			break
+		}
+	}
+	return pl.Segments[first:], duration
+}
+
+// awaitSegment waits up to a target duration for the segment after last to
+// complete, so that a clip includes the moment it was asked for, and returns
+// the playlist then.
+func awaitSegment(playlistPath string, last int) (*MediaPlaylist, error) {
+	pl, err := readMediaPlaylist(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(time.Duration(pl.TargetDuration+1) * time.Second)
+	for time.Now().Before(deadline) {
+		if n := len(pl.Segments); pl.EndList || (n > 0 && pl.Segments[n-1].Sequence > last) {
+			break
+		}
+		time.Sleep(replayPollInterval)
+		next, err := readMediaPlaylist(playlistPath)
+		if err != nil {
+			// The stream stopped and its playlist is gone; use what was listed.
+			break
+		}
+		pl = next
+	}
+	return pl, nil
+}
+
+// Replay writes the last seconds of the named stream to an MP4 in the
+// recording directory without re-encoding. The clip is written in the
+// background and announced with an EventReplaySaved or EventReplayFailed
+// event.
+func (s *FFmpegStreamer) Replay(name string, seconds int) (Replay, error) {
+	if seconds < 1 || seconds > MaxReplaySeconds {
+		return Replay{}, fmt.Errorf("%w: %d seconds, must be between 1 and %d", ErrInvalidReplay, seconds, MaxReplaySeconds)
+	}
+	if s.recordingDir == "" {
+		return Replay{}, ErrRecordingDisabled
+	}
+
+	s.mutex.RLock()
+	st := s.lookupStream(name)
+	if st == nil || st.session == nil {
+		s.mutex.RUnlock()
+		return Replay{}, ErrNotStreaming
+	}
+	sess := *st.session
+	playlistPath := st.profile.restreamInput(st.hlsDir)
+	s.mutex.RUnlock()
+
+	pl, err := readMediaPlaylist(playlistPath)
+	if err != nil || len(pl.Segments) == 0 {
+		return Replay{}, ErrNoFrame
+	}
+
+	now := time.Now()
+	id, err := reserveClip(s.recordingDir, fmt.Sprintf("replay-%s-%s", sess.Stream, now.Format("20060102-150405")))
+	if err != nil {
+		return Replay{}, err
+	}
+	clip := Replay{
+		ID:        id,
+		Stream:    sess.Stream,
+		Session:   sess.ID,
+		File:      filepath.Join(s.recordingDir, id+".mp4"),
+		Seconds:   seconds,
+		State:     RecordingFinalizing,
+		CreatedAt: now,
+	}
+	s.logger.Infof("Replay %s of the last %d seconds requested", id, seconds)
+	go s.writeReplay(clip, playlistPath, pl.Segments[len(pl.Segments)-1].Sequence)
+	return clip, nil
+}
+
+// reserveClip claims a clip ID starting with base in dir by creating the
+// temporary file remux writes to, numbering IDs that are taken.
+func reserveClip(dir, base string) (string, error) {
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		path := filepath.Join(dir, id+".mp4")
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		f, err := os.OpenFile(path+".part", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return id, f.Close()
+	}
+}
+
+// writeReplay writes clip from the playlist at playlistPath, whose newest
+// segment was last when the clip was requested, and announces the result.
+func (s *FFmpegStreamer) writeReplay(clip Replay, playlistPath string, last int) {
+	duration, err := s.remuxReplay(clip, playlistPath, last)
+	if err != nil {
+		os.Remove(clip.File + ".part")
+		s.logger.Errorf("Replay %s failed: %v", clip.ID, err)
+		s.emit(Event{Type: EventReplayFailed, Stream: clip.Stream, Session: clip.Session, Message: fmt.Sprintf("Replay %s failed: %v", clip.ID, err), Error: err.Error()})
+		return
+	}
+	s.logger.Infof("Replay %s saved to %s", clip.ID, clip.File)
+	s.emit(Event{Type: EventReplaySaved, Stream: clip.Stream, Session: clip.Session, Message: fmt.Sprintf("Replay saved: %s (%.1f s)", filepath.Base(clip.File), duration)})
+}
+
+// remuxReplay copies the segments of clip into its MP4 and returns the
+// duration of the clip.
+func (s *FFmpegStreamer) remuxReplay(clip Replay, playlistPath string, last int) (float64, error) {
+	pl, err := awaitSegment(playlistPath, last)
+	if err != nil {
+		return 0, err
+	}
+	segments, duration := replaySegments(pl, clip.Seconds)
+	if len(segments) == 0 {
+		return 0, ErrNoFrame
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	return duration, s.remuxSegments(ctx, filepath.Dir(playlistPath), segments, pl.MapURI, clip.File)
+}
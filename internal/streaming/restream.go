@@ -0,0 +1,313 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DestinationState is the connection state of a restream destination.
+type DestinationState string
+
+const (
+	DestinationDisabled   DestinationState = "disabled"
+	DestinationIdle       DestinationState = "idle" // The stream is not running
+	DestinationConnecting DestinationState = "connecting"
+	DestinationLive       DestinationState = "live"
+	DestinationRetrying   DestinationState = "retrying"
+)
+
+// DestinationStatus reports a destination and the state of its relay.
+type DestinationStatus struct {
+	Destination
+	HasKey  bool             `json:"has_key"`
+	State   DestinationState `json:"state"`
+	Error   string           `json:"error,omitempty"`
+	Attempt int              `json:"attempt,omitempty"`
+	Since   *time.Time       `json:"since,omitempty"`
+}
+
+// newDestinationStatus returns the status of d without any stream key.
+func newDestinationStatus(d Destination, state DestinationState) DestinationStatus {
+	return DestinationStatus{Destination: d.redacted(), HasKey: d.Key != "", State: state}
+}
+
+// relayPolicy is the reconnect backoff of restream relays. Relays keep
+// retrying for as long as the stream runs, since ingest servers come and go.
+var relayPolicy = DefaultRestartPolicy()
+
+// relay publishes a stream's HLS output to one destination without
+// re-encoding, reconnecting with backoff until it is stopped.
+type relay struct {
+	mutex   sync.Mutex
+	dest    Destination
+	input   string // Media playlist the relay reads
+	state   DestinationState
+	err     string
+	attempt int
+	since   time.Time
+	stop    chan struct{}
+}
+
+// set records a state change of the relay.
+func (r *relay) set(state DestinationState, err string, attempt int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state, r.err, r.attempt, r.since = state, err, attempt, time.Now()
+}
+
+// markLive moves a connecting relay to live, reporting whether it changed.
+func (r *relay) markLive() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.state != DestinationConnecting {
+		return false
+	}
+	r.state, r.err, r.since = DestinationLive, "", time.Now()
+	return true
+}
+
+// status returns the relay's current status.
+func (r *relay) status() DestinationStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := newDestinationStatus(r.dest, r.state)
+	status.Error = r.err
+	status.Attempt = r.attempt
+	since := r.since
+	status.Since = &since
+	return status
+}
+
+// relayArgs returns the FFmpeg arguments that copy the playlist at input to
+// d, reading the stream key from keyFile.
+func relayArgs(input string, d Destination, keyFile string) []string {
+	args := progressArgs()
+	args = append(args,
+		"-re", "-live_start_index", "-1", "-i", input,
+		"-map", "0:v:0", "-map", "0:a:0?", "-c", "copy",
+	)
+	if d.format() == "flv" {
+		args = append(args, "-bsf:a", "aac_adtstoasc")
+	}
+	args = append(args, d.keyArgs(keyFile)...)
+	return append(args, "-f", d.format(), d.URL)
+}
+
+// restreamInput returns the media playlist of p's output that relays read:
+// the top rendition of a ladder, or the LL-HLS playlist in low-latency mode.
+func (p StreamProfile) restreamInput(hlsDir string) string {
+	switch {
+	case p.LowLatency:
+		return filepath.Join(hlsDir, LowLatencyDir, LivePlaylist)
+	case len(p.Renditions) > 0:
+		return filepath.Join(hlsDir, p.Renditions[0].Name, LivePlaylist)
+	}
+	return filepath.Join(hlsDir, LivePlaylist)
+}
+
+// runRelay runs FFmpeg for r until r.stop is closed, restarting it with
+// backoff whenever the connection to the destination fails.
+func (s *FFmpegStreamer) runRelay(r *relay) {
+	attempt := 0
+	for {
+		r.set(DestinationConnecting, "", attempt)
+		started := time.Now()
+		var proc *process
+		keyFile, err := r.dest.writeKey()
+		if err == nil {
+			proc, err = s.spawn(relayArgs(r.input, r.dest, keyFile), func(stats Stats) {
+				if stats.Frame > 0 && r.markLive() {
+					s.logger.Infof("Restream of %s to %s is live", r.dest.Stream, r.dest.Name)
+					s.emit(Event{Type: EventDestinationLive, Stream: r.dest.Stream, Destination: r.dest.ID, Message: fmt.Sprintf("Restream to %s is live", r.dest.Name)})
+				}
+			})
+		}
+		if err == nil {
+			select {
+			case <-r.stop:
+				if _, err := proc.shutdown(s.stopGrace, s.stopTermTimeout); err != nil {
+					s.logger.Errorf("Failed to stop restream to %s: %v", r.dest.Name, err)
+				}
+				removeKey(keyFile)
+				return
+			case <-proc.done:
+				err = proc.err
+				if err == nil {
+					err = errors.New("relay exited")
+				}
+			}
+		}
+		removeKey(keyFile)
+
+		if time.Since(started) >= relayPolicy.ResetAfter {
+			attempt = 0
+		}
+		attempt++
+		delay := relayPolicy.backoff(attempt)
+		r.set(DestinationRetrying, err.Error(), attempt)
+		s.logger.Warnf("Restream of %s to %s failed, retrying in %s: %v", r.dest.Stream, r.dest.Name, delay, err)
+		s.emit(Event{
+			Type:        EventDestinationRetrying,
+			Stream:      r.dest.Stream,
+			Destination: r.dest.ID,
+			Message:     fmt.Sprintf("Restream to %s failed, retrying in %s", r.dest.Name, delay),
+			Attempt:     attempt,
+			Delay:       delay.String(),
+			Error:       err.Error(),
+		})
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// syncRelay starts or stops the relay of d so that it runs exactly when d is
+// enabled and its stream is running. Callers must hold the mutex.
+func (s *FFmpegStreamer) syncRelay(st *stream, d Destination, removed bool) {
+	r := st.relays[d.ID]
+	run := !removed && d.Enabled && st.session != nil && st.session.State != SessionStopping
+	switch {
+	case run && r == nil:
+		if st.relays == nil {
+			st.relays = make(map[string]*relay)
+		}
+		r = &relay{dest: d, input: st.mediaInput(), stop: make(chan struct{})}
+		st.relays[d.ID] = r
+		go s.runRelay(r)
+	case !run && r != nil:
+		close(r.stop)
+		delete(st.relays, d.ID)
+	}
+}
+
+// startRelays starts the relays of every enabled destination of st.
+// Callers must hold the mutex.
+func (s *FFmpegStreamer) startRelays(st *stream) {
+	for _, d := range s.destinations.list(st.name) {
+		s.syncRelay(st, d, false)
+	}
+}
+
+// stopRelays stops every relay of the stream. Callers must hold the mutex.
+func (st *stream) stopRelays() {
+	for id, r := range st.relays {
+		close(r.stop)
+		delete(st.relays, id)
+	}
+}
+
+// LoadDestinations reads the restream destinations stored at path and
+// persists later changes there. It should be called before any stream is
+// started; until then destinations are only kept in memory.
+func (s *FFmpegStreamer) LoadDestinations(path string) error {
+	ds, err := loadDestinationStore(path)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.destinations = ds
+	return nil
+}
+
+// destinationStatus returns the status of d. Callers must hold the mutex.
+func (s *FFmpegStreamer) destinationStatus(d Destination) DestinationStatus {
+	if !d.Enabled {
+		return newDestinationStatus(d, DestinationDisabled)
+	}
+	if st := s.streams[d.Stream]; st != nil {
+		if r := st.relays[d.ID]; r != nil {
+			return r.status()
+		}
+	}
+	return newDestinationStatus(d, DestinationIdle)
+}
+
+// Destinations returns the restream destinations of the named stream.
+func (s *FFmpegStreamer) Destinations(name string) ([]DestinationStatus, error) {
+	name, err := streamName(name)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	out := []DestinationStatus{}
+	for _, d := range s.destinations.list(name) {
+		out = append(out, s.destinationStatus(d))
+	}
+	return out, nil
+}
+
+// AddDestination adds a restream destination to the named stream. It starts
+// publishing right away when the destination is enabled and the stream runs.
+func (s *FFmpegStreamer) AddDestination(name string, d Destination) (DestinationStatus, error) {
+	name, err := streamName(name)
+	if err != nil {
+		return DestinationStatus{}, err
+	}
+	if err := d.validate(); err != nil {
+		return DestinationStatus{}, err
+	}
+	d.ID = newDestinationID()
+	d.Stream = name
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.destinations.add(d); err != nil {
+		return DestinationStatus{}, err
+	}
+	s.logger.Infof("Added restream destination %s (%s) to stream %s", d.ID, d.Name, name)
+	if st := s.streams[name]; st != nil {
+		s.syncRelay(st, d, false)
+	}
+	return s.destinationStatus(d), nil
+}
+
+// SetDestinationEnabled enables or disables a destination of the named
+// stream, starting or stopping its relay if the stream is running.
+func (s *FFmpegStreamer) SetDestinationEnabled(name, id string, enabled bool) (DestinationStatus, error) {
+	name, err := streamName(name)
+	if err != nil {
+		return DestinationStatus{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, err := s.destinations.update(name, id, func(d *Destination) {
+		d.Enabled = enabled
+	})
+	if err != nil {
+		return DestinationStatus{}, err
+	}
+	if st := s.streams[name]; st != nil {
+		s.syncRelay(st, d, false)
+	}
+	return s.destinationStatus(d), nil
+}
+
+// RemoveDestination deletes a destination of the named stream, stopping its relay.
+func (s *FFmpegStreamer) RemoveDestination(name, id string) error {
+	name, err := streamName(name)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, err := s.destinations.get(name, id)
+	if err != nil {
+		return err
+	}
+	if err := s.destinations.remove(name, id); err != nil {
+		return err
+	}
+	if st := s.streams[name]; st != nil {
+		s.syncRelay(st, d, true)
+	}
+	s.logger.Infof("Removed restream destination %s from stream %s", id, name)
+	return nil
+}
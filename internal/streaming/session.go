@@ -0,0 +1,131 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// maxSessionHistory bounds the number of sessions kept in memory.
+const maxSessionHistory = 50
+
+// ErrSessionNotFound is returned when a session ID is unknown.
+var ErrSessionNotFound = errors.New("stream session not found")
+
+// SessionState is the lifecycle state of a StreamSession.
+type SessionState string
+
+const (
+	SessionStarting SessionState = "starting"
+	// SessionListening waits for the sender of a listening network source.
+	SessionListening SessionState = "listening"
+	SessionLive      SessionState = "live"
+	SessionStopping  SessionState = "stopping"
+	SessionStopped   SessionState = "stopped"
+	SessionFailed    SessionState = "failed"
+)
+
+// StreamSession records one run of the streamer from start to stop.
+type StreamSession struct {
+	ID        string       `json:"id"`
+	Stream    string       `json:"stream"`
+	Profile   string       `json:"profile"`
+	Encoder   string       `json:"encoder"`
+	State     SessionState `json:"state"`
+	StartedAt time.Time    `json:"started_at"`
+	LiveAt    *time.Time   `json:"live_at,omitempty"`
+	EndedAt   *time.Time   `json:"ended_at,omitempty"`
+	Restarts  int          `json:"restarts"`
+	// StalledSince is set while FFmpeg runs without producing segments.
+	StalledSince *time.Time `json:"stalled_since,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// newSession creates a session of the named stream in the starting state.
+func newSession(stream, profile, encoder string) *StreamSession {
+	return &StreamSession{
+		ID:        newSessionID(),
+		Stream:    stream,
+		Profile:   profile,
+		Encoder:   encoder,
+		State:     SessionStarting,
+		StartedAt: time.Now(),
+	}
+}
+
+// newSessionID returns a sortable, unique session identifier.
+func newSessionID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// setState moves the session to state, stamping the live and end times.
+func (ss *StreamSession) setState(state SessionState) {
+	now := time.Now()
+	ss.State = state
+	switch state {
+	case SessionStarting:
+		// A restarted run has to stall on its own
+		ss.StalledSince = nil
+	case SessionLive:
+		if ss.LiveAt == nil {
+			ss.LiveAt = &now
+		}
+	case SessionStopped, SessionFailed:
+		ss.EndedAt = &now
+		ss.StalledSince = nil
+	}
+}
+
+// fail moves the session to the failed state with the given error.
+func (ss *StreamSession) fail(err error) {
+	ss.Error = err.Error()
+	ss.setState(SessionFailed)
+}
+
+// addSession records a new session as current for st. Callers must hold the mutex.
+func (s *FFmpegStreamer) addSession(st *stream, sess *StreamSession) {
+	st.session = sess
+	s.sessions = append(s.sessions, sess)
+	if len(s.sessions) > maxSessionHistory {
+		s.sessions = s.sessions[len(s.sessions)-maxSessionHistory:]
+	}
+}
+
+// CurrentSession returns a copy of the active session of the named stream,
+// or nil when it is idle.
+func (s *FFmpegStreamer) CurrentSession(name string) *StreamSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil || st.session == nil {
+		return nil
+	}
+	sess := *st.session
+	return &sess
+}
+
+// Sessions returns copies of the current and past sessions of all streams, newest first.
+func (s *FFmpegStreamer) Sessions() []StreamSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	out := make([]StreamSession, 0, len(s.sessions))
+	for i := len(s.sessions) - 1; i >= 0; i-- {
+		out = append(out, *s.sessions[i])
+	}
+	return out
+}
+
+// Session returns a copy of the session with the given ID.
+func (s *FFmpegStreamer) Session(id string) (StreamSession, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, sess := range s.sessions {
+		if sess.ID == id {
+			return *sess, nil
+		}
+	}
+	return StreamSession{}, ErrSessionNotFound
+}
@@ -0,0 +1,133 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// sideOutputChunk is the size of the reads from a side output pipe.
+	sideOutputChunk = 32 << 10
+	// sideOutputBuffer is the number of chunks held for a slow consumer
+	// before further data is dropped.
+	sideOutputBuffer = 64
+)
+
+// errSideOutputGap is returned once by a side output read where data was
+// dropped. The consumer resyncs at the next frame of its format.
+var errSideOutputGap = errors.New("side output data dropped")
+
+// sideOutput reads one of FFmpeg's side outputs on behalf of its consumer.
+// All outputs of a stream share one FFmpeg process, so a consumer that stops
+// reading would block FFmpeg and one that closes the pipe would fail its
+// writes, stopping every output with it. sideOutput keeps draining the pipe
+// instead: a consumer that falls behind loses data, learning where from
+// errSideOutputGap, and one that gave up gets none.
+type sideOutput struct {
+	chunks  chan sideChunk
+	pending []byte
+	done    chan struct{}
+	once    sync.Once
+}
+
+// sideChunk is data read from a side output pipe.
+type sideChunk struct {
+	data []byte
+	gap  bool // Data was dropped before this chunk
+}
+
+// isolate starts draining r and returns the reader its consumer reads from.
+func isolate(r io.ReadCloser) *sideOutput {
+	so := &sideOutput{
+		chunks: make(chan sideChunk, sideOutputBuffer),
+		done:   make(chan struct{}),
+	}
+	go so.pump(r)
+	return so
+}
+
+// pump copies r to the consumer until FFmpeg closes its end of the pipe.
+func (so *sideOutput) pump(r io.ReadCloser) {
+	defer r.Close()
+	defer close(so.chunks)
+	buf := make([]byte, sideOutputChunk)
+	dropped := false
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case <-so.done:
+			case so.chunks <- sideChunk{data: append([]byte(nil), buf[:n]...), gap: dropped}:
+				dropped = false
+			default:
+				// The consumer is behind; drop the chunk
+				dropped = true
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads the data passed on to the consumer. It returns errSideOutputGap
+// before the first data following dropped data.
+func (so *sideOutput) Read(p []byte) (int, error) {
+	if len(so.pending) == 0 {
+		chunk, ok := <-so.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		so.pending = chunk.data
+		if chunk.gap {
+			return 0, errSideOutputGap
+		}
+	}
+	n := copy(p, so.pending)
+	so.pending = so.pending[n:]
+	return n, nil
+}
+
+// Close detaches the consumer; the pipe is drained until FFmpeg closes it.
+func (so *sideOutput) Close() error {
+	so.once.Do(func() { close(so.done) })
+	return nil
+}
+
+// skipTo discards the data on r up to the next occurrence of marker, which
+// starts a frame of the consumer's format, skipping any further gaps.
+func skipTo(r *bufio.Reader, marker []byte) error {
+	for {
+		b, err := r.Peek(len(marker))
+		if bytes.Equal(b, marker) {
+			return nil
+		}
+		switch {
+		case errors.Is(err, errSideOutputGap):
+			r.Discard(r.Buffered())
+		case err != nil:
+			return err
+		default:
+			r.Discard(1)
+		}
+	}
+}
+
+// gapReader records a gap in a side output for readers that do not return
+// read errors to their caller.
+type gapReader struct {
+	r   io.Reader
+	gap bool
+}
+
+// Read reads from the side output, recording errSideOutputGap.
+func (g *gapReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	if errors.Is(err, errSideOutputGap) {
+		g.gap = true
+	}
+	return n, err
+}
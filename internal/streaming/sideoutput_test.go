@@ -0,0 +1,151 @@
+package streaming
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeAll writes n bytes to w and reports the result, failing the test if
+// the writes block for longer than timeout.
+func writeAll(t *testing.T, w io.Writer, n int, timeout time.Duration) error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, n))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		t.Fatalf("writing %d bytes blocked", n)
+		return nil
+	}
+}
+
+// gappedOutput returns a side output holding chunks, as if FFmpeg had written
+// them and closed the pipe.
+func gappedOutput(chunks ...sideChunk) *sideOutput {
+	so := &sideOutput{
+		chunks: make(chan sideChunk, len(chunks)),
+		done:   make(chan struct{}),
+	}
+	for _, chunk := range chunks {
+		so.chunks <- chunk
+	}
+	close(so.chunks)
+	return so
+}
+
+func TestSideOutputPassesData(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	so := isolate(r)
+	want := bytes.Repeat([]byte("frame"), 10000)
+	go func() {
+		w.Write(want)
+		w.Close()
+	}()
+	got, err := io.ReadAll(so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestSideOutputReportsGap(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	so := isolate(r)
+	// Overflow the buffer before the consumer reads
+	n := 2 * sideOutputBuffer * sideOutputChunk
+	if err := writeAll(t, w, n, 5*time.Second); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+	go func() {
+		w.Write([]byte("after"))
+		w.Close()
+	}()
+
+	var got []byte
+	gaps := 0
+	buf := make([]byte, sideOutputChunk)
+	for {
+		m, err := so.Read(buf)
+		got = append(got, buf[:m]...)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errSideOutputGap) {
+			gaps++
+		} else if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if gaps == 0 || len(got) >= n {
+		t.Errorf("read %d of %d bytes with %d gaps, want dropped data reported", len(got), n, gaps)
+	}
+	if !bytes.HasSuffix(got, []byte("after")) {
+		t.Error("data written after the gap was not read")
+	}
+}
+
+func TestSideOutputDrainsStalledConsumer(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	isolate(r) // The consumer never reads
+
+	if err := writeAll(t, w, 4*sideOutputBuffer*sideOutputChunk, 5*time.Second); err != nil {
+		t.Errorf("write error = %v", err)
+	}
+}
+
+func TestSideOutputDrainsClosedConsumer(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	so := isolate(r)
+	so.Close() // The consumer gave up
+
+	if err := writeAll(t, w, 4*sideOutputBuffer*sideOutputChunk, 5*time.Second); err != nil {
+		t.Errorf("write error = %v, want the pipe to stay open", err)
+	}
+}
+
+func TestPreviewSkipsOversizedFrames(t *testing.T) {
+	hub := newPreviewHub()
+	frames, cancel := hub.subscribe()
+	defer cancel()
+
+	small := []byte{0xFF, 0xD8, 1, 2, 3, 0xFF, 0xD9}
+	var stream []byte
+	stream = append(stream, 0xFF, 0xD8)
+	stream = append(stream, make([]byte, maxPreviewFrameSize+1)...)
+	stream = append(stream, 0xFF, 0xD9)
+	stream = append(stream, small...)
+	go hub.consume(io.NopCloser(bytes.NewReader(stream)))
+
+	select {
+	case frame := <-frames:
+		if !bytes.Equal(frame, small) {
+			t.Errorf("got a frame of %d bytes, want the small frame", len(frame))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame after an oversized one")
+	}
+}
@@ -0,0 +1,157 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PosterFile is the preview image of each stream in its PosterDir subdirectory.
+	PosterFile = "poster.jpg"
+	// MaxSnapshotWidth bounds the width a snapshot can be scaled to.
+	MaxSnapshotWidth = 3840
+	// posterWidth is the width of the periodic poster image.
+	posterWidth = 640
+)
+
+var (
+	// ErrNoFrame is returned when the stream has not written a segment yet.
+	ErrNoFrame = errors.New("no video segment available yet")
+	// ErrInvalidWidth is returned for snapshot widths outside 1..MaxSnapshotWidth.
+	ErrInvalidWidth = errors.New("invalid snapshot width")
+)
+
+// snapshotLatest returns a JPEG of the newest complete segment of the media
+// playlist at playlistPath, scaled to width when it is positive. fMP4
+// segments are prefixed with their initialization section using the concat
+// protocol, and encrypted segments are read through a local playlist naming
+// their key.
+func (s *FFmpegStreamer) snapshotLatest(ctx context.Context, playlistPath string, width int) ([]byte, error) {
+	pl, err := readMediaPlaylist(playlistPath)
+	if err != nil || len(pl.Segments) == 0 {
+		return nil, ErrNoFrame
+	}
+	dir := filepath.Dir(playlistPath)
+	newest := pl.Segments[len(pl.Segments)-1]
+	if newest.Key != "" {
+		list, err := s.localVOD(dir, []PlaylistSegment{newest}, pl.MapURI)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(list)
+		return s.snapshot(ctx, list, width)
+	}
+	input := filepath.Join(dir, newest.URI)
+	if pl.MapURI != "" {
+		input = "concat:" + filepath.Join(dir, pl.MapURI) + "|" + input
+	}
+	return s.snapshot(ctx, input, width)
+}
+
+// snapshot decodes the frame about a second before the end of input and
+// returns it as a JPEG, scaled to width when it is positive.
+func (s *FFmpegStreamer) snapshot(ctx context.Context, input string, width int) ([]byte, error) {
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-sseof", "-1", "-i", input,
+		"-frames:v", "1", "-q:v", "3",
+	}
+	if width > 0 {
+		args = append(args, "-vf", "scale="+strconv.Itoa(width)+":-2")
+	}
+	args = append(args, "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg snapshot failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, ErrNoFrame
+	}
+	return stdout.Bytes(), nil
+}
+
+// Snapshot returns a JPEG of the latest frame of the named stream, scaled to
+// width when it is positive.
+func (s *FFmpegStreamer) Snapshot(ctx context.Context, name string, width int) ([]byte, error) {
+	if width < 0 || width > MaxSnapshotWidth {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidWidth, width)
+	}
+	s.mutex.RLock()
+	st := s.lookupStream(name)
+	if st == nil || st.session == nil {
+		s.mutex.RUnlock()
+		return nil, ErrNotStreaming
+	}
+	playlistPath := st.profile.restreamInput(st.hlsDir)
+	s.mutex.RUnlock()
+
+	return s.snapshotLatest(ctx, playlistPath, width)
+}
+
+// Poster returns the latest poster image of the named stream.
+func (s *FFmpegStreamer) Poster(name string) ([]byte, error) {
+	s.mutex.RLock()
+	st := s.lookupStream(name)
+	s.mutex.RUnlock()
+	if st == nil {
+		return nil, ErrNotStreaming
+	}
+	jpeg, err := os.ReadFile(filepath.Join(s.posterDir, st.name, PosterFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoFrame
+	}
+	return jpeg, err
+}
+
+// runPoster refreshes the poster image of the session every interval until
+// the session ends. The session profile is passed in, as st.profile changes
+// when the next session starts.
+func (s *FFmpegStreamer) runPoster(st *stream, sess *StreamSession, p StreamProfile, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.posterInterval)
+	defer ticker.Stop()
+
+	playlistPath := p.restreamInput(st.hlsDir)
+	posterPath := filepath.Join(s.posterDir, st.name, PosterFile)
+	if err := os.MkdirAll(filepath.Dir(posterPath), 0700); err != nil {
+		s.logger.Warnf("Failed to create poster directory of stream %s: %v", st.name, err)
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.mutex.RLock()
+		current := st.session == sess
+		s.mutex.RUnlock()
+		if !current {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.posterInterval)
+		jpeg, err := s.snapshotLatest(ctx, playlistPath, posterWidth)
+		cancel()
+		if errors.Is(err, ErrNoFrame) {
+			continue
+		}
+		if err == nil {
+			err = writeFileAtomic(posterPath, jpeg)
+		}
+		if err != nil {
+			s.logger.Warnf("Failed to update poster of stream %s: %v", st.name, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPosterIsKeptOutOfHLSDir(t *testing.T) {
+	cfg := fakeConfig(t, DefaultProfile())
+	cfg.PosterInterval = 2 * fakeSegmentInterval
+	s := NewFFmpegStreamer(cfg, testLogger())
+
+	if _, err := s.Poster(""); !errors.Is(err, ErrNotStreaming) {
+		t.Errorf("Poster() before the first session error = %v, want ErrNotStreaming", err)
+	}
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	waitFor(t, 5*time.Second, "the poster", func() bool {
+		_, err := s.Poster("")
+		return err == nil
+	})
+	jpeg, err := s.Poster("")
+	if err != nil || !bytes.Equal(jpeg, fakeJPEG) {
+		t.Errorf("Poster() = %q, %v, want the snapshot", jpeg, err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.PosterDir, DefaultStreamName, PosterFile)); err != nil {
+		t.Errorf("poster not written to PosterDir: %v", err)
+	}
+	filepath.WalkDir(cfg.HLSDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.Name() == PosterFile {
+			t.Errorf("poster written next to the playlists at %s", path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package streaming
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Source types of a StreamProfile.
+const (
+	// SourceDevice captures from the V4L2 video device and ALSA audio device.
+	SourceDevice = "device"
+	// SourceTest generates a test pattern with a burnt-in clock and a sine
+	// tone, so the pipeline can run without any capture hardware.
+	SourceTest = "test"
+	// SourceRTMP reads an RTMP feed, e.g. a push from OBS when listening.
+	SourceRTMP = "rtmp"
+	// SourceSRT reads an SRT feed as caller or listener.
+	SourceSRT = "srt"
+	// SourceRTSP pulls from an RTSP server such as an IP camera.
+	SourceRTSP = "rtsp"
+)
+
+// Defaults of the test source when the profile leaves them unset.
+const (
+	testSourceWidth     = 1280
+	testSourceHeight    = 720
+	testSourceFramerate = 30
+)
+
+// StartOptions selects what StartStream starts.
+type StartOptions struct {
+	Stream  string // Stream name; empty selects DefaultStreamName
+	Profile string // Profile name; empty selects the stream's or the default profile
+	Source  string // Overrides the profile's source when set
+}
+
+// isKnownSource reports whether source is a supported source type.
+func isKnownSource(source string) bool {
+	switch source {
+	case "", SourceDevice, SourceTest, SourceRTMP, SourceSRT, SourceRTSP:
+		return true
+	}
+	return false
+}
+
+// source returns the profile's source type.
+func (p StreamProfile) source() string {
+	if p.Source == "" {
+		return SourceDevice
+	}
+	return p.Source
+}
+
+// testSourceArgs returns the FFmpeg arguments for the lavfi test pattern and
+// tone. Both inputs are read in real time so that the output behaves like a
+// live capture.
+func (p StreamProfile) testSourceArgs() []string {
+	width, height, framerate := p.Width, p.Height, p.Framerate
+	if width == 0 {
+		width, height = testSourceWidth, testSourceHeight
+	}
+	if framerate == 0 {
+		framerate = testSourceFramerate
+	}
+	video := fmt.Sprintf("testsrc2=size=%dx%d:rate=%d,"+
+		"drawtext=text='%%{localtime\\:%%T}':fontsize=%d:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=8:x=(w-tw)/2:y=h-th-%d",
+		width, height, framerate, height/12, height/24)
+	audio := "sine=frequency=1000:sample_rate=" + strconv.Itoa(p.AudioSampleRate)
+	return []string{
+		"-re", "-f", "lavfi", "-i", video,
+		"-re", "-f", "lavfi", "-i", audio,
+	}
+}
@@ -0,0 +1,97 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// StopStage identifies which step of the staged shutdown ended FFmpeg.
+type StopStage string
+
+const (
+	StopStageQuit   StopStage = "quit"    // FFmpeg exited after "q" or SIGINT and finalized its outputs
+	StopStageTerm   StopStage = "sigterm" // FFmpeg needed SIGTERM after the grace period
+	StopStageKill   StopStage = "sigkill" // FFmpeg had to be killed; outputs may be incomplete
+	StopStageExited StopStage = "exited"  // FFmpeg was already gone when the stop was requested
+)
+
+// StopResult reports the outcome of a staged stop.
+type StopResult struct {
+	Stream   string    `json:"stream"`
+	Session  string    `json:"session"`
+	Stage    StopStage `json:"stage"`
+	Clean    bool      `json:"clean"` // True when FFmpeg had the chance to finalize playlists and files
+	Duration string    `json:"duration"`
+}
+
+// Message returns a human readable summary of the stop outcome.
+func (r StopResult) Message() string {
+	switch r.Stage {
+	case StopStageQuit:
+		return fmt.Sprintf("Stream %s stopped cleanly in %s", r.Stream, r.Duration)
+	case StopStageTerm:
+		return fmt.Sprintf("Stream %s stopped with SIGTERM after %s", r.Stream, r.Duration)
+	case StopStageKill:
+		return fmt.Sprintf("Stream %s killed after %s; outputs may be incomplete", r.Stream, r.Duration)
+	default:
+		return fmt.Sprintf("Stream %s stopped", r.Stream)
+	}
+}
+
+// shutdown stops the process in stages: "q" on stdin (or SIGINT), SIGTERM after
+// grace, then SIGKILL after termTimeout. It returns the stage that ended the process.
+func (p *process) shutdown(grace, termTimeout time.Duration) (StopStage, error) {
+	select {
+	case <-p.done:
+		return StopStageExited, nil
+	default:
+	}
+
+	if err := p.quit(); err != nil {
+		if err := p.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return "", err
+		}
+	}
+	if p.wait(grace) {
+		return StopStageQuit, nil
+	}
+
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return "", err
+	}
+	if p.wait(termTimeout) {
+		return StopStageTerm, nil
+	}
+
+	if err := p.kill(); err != nil {
+		return "", err
+	}
+	<-p.done
+	return StopStageKill, nil
+}
+
+// quit asks FFmpeg to finish by sending "q" on its stdin.
+func (p *process) quit() error {
+	if p.stdin == nil {
+		return errors.New("ffmpeg stdin is not available")
+	}
+	_, err := io.WriteString(p.stdin, "q\n")
+	p.stdin.Close()
+	return err
+}
+
+// wait blocks until the process exits or timeout elapses, reporting whether it exited.
+func (p *process) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// DefaultStreamName is the stream used when a call does not name one.
+const DefaultStreamName = "main"
+
+// ErrInvalidStreamName is returned when a stream name cannot be used as a directory name.
+var ErrInvalidStreamName = errors.New("invalid stream name")
+
+// streamNamePattern restricts stream names to safe path segments.
+var streamNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// stream holds the state of one named stream. Its fields are guarded by the
+// streamer's mutex.
+type stream struct {
+	name       string
+	hlsDir     string        // HLS output directory of the stream
+	profile    StreamProfile // Profile of the active or last session
+	proc       *process
+	stop       chan struct{}     // Closed when the current session is stopped on purpose
+	session    *StreamSession    // Active session, nil when idle
+	stats      Stats             // Latest progress of the active session
+	recorder   *recorder         // Recording spool of the active session
+	llPackager *llPackager       // LL-HLS packager of the active session
+	relays     map[string]*relay // Restream relays by destination ID
+	preview    *previewHub       // MJPEG preview of the active session
+	webrtc     *webrtcFeed       // WebRTC viewers of the active session
+	meter      *audioMeter       // Audio levels of the active session
+	dvr        *dvrArchive       // DVR window of the active session
+	keys       *keyRing          // Encryption keys of the active session
+	staging    chan struct{}     // Closed once the last session's segments are staged for its archive
+	// silentAudio is set once the network source of the active session
+	// turned out to have no audio track.
+	silentAudio bool
+}
+
+// StreamStatus summarizes a named stream.
+type StreamStatus struct {
+	Name      string `json:"name"`
+	Streaming bool   `json:"streaming"`
+	Playlist  string `json:"playlist"` // Master playlist, relative to the HLS directory
+	// DVRPlaylist is the master playlist of the DVR window, relative to the
+	// HLS directory, while one is kept.
+	DVRPlaylist string         `json:"dvr_playlist,omitempty"`
+	Session     *StreamSession `json:"session,omitempty"`
+	Recording   *Recording     `json:"recording,omitempty"`
+}
+
+// streamName validates name, falling back to DefaultStreamName when empty.
+func streamName(name string) (string, error) {
+	if name == "" {
+		return DefaultStreamName, nil
+	}
+	if !streamNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidStreamName, name)
+	}
+	return name, nil
+}
+
+// lookupStream returns the named stream, or nil when it was never started.
+// Callers must hold the mutex.
+func (s *FFmpegStreamer) lookupStream(name string) *stream {
+	name, err := streamName(name)
+	if err != nil {
+		return nil
+	}
+	return s.streams[name]
+}
+
+// clear releases the resources of the stream's session. Callers must hold the mutex.
+func (st *stream) clear() {
+	st.stopRelays()
+	if st.recorder != nil {
+		st.recorder.close()
+	}
+	if st.preview != nil {
+		st.preview.close()
+	}
+	if st.webrtc != nil {
+		st.webrtc.close()
+	}
+	if st.dvr != nil {
+		st.dvr.close()
+	}
+	st.session = nil
+	st.proc = nil
+	st.recorder = nil
+	st.llPackager = nil
+	st.preview = nil
+	st.webrtc = nil
+	st.meter = nil
+	st.dvr = nil
+	st.keys = nil
+	st.silentAudio = false
+}
+
+// mediaInput returns the media playlist FFmpeg readers such as relays use:
+// the playlist relays restream, or its local copy naming the key files when
+// the output is encrypted.
+func (st *stream) mediaInput() string {
+	if st.keys != nil {
+		return st.keys.localPlaylist()
+	}
+	return st.profile.restreamInput(st.hlsDir)
+}
+
+// status returns a snapshot of the stream. Callers must hold the mutex.
+func (st *stream) status() StreamStatus {
+	status := StreamStatus{
+		Name:      st.name,
+		Streaming: st.session != nil,
+		Playlist:  path.Join(st.name, MasterPlaylist),
+	}
+	if st.session != nil {
+		sess := *st.session
+		status.Session = &sess
+	}
+	if st.recorder != nil {
+		status.Recording = st.recorder.current()
+	}
+	if st.dvr != nil {
+		status.DVRPlaylist = path.Join(st.name, DVRMasterPlaylist)
+	}
+	return status
+}
+
+// Streams returns the status of every stream started since the streamer was
+// created, sorted by name.
+func (s *FFmpegStreamer) Streams() []StreamStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	out := make([]StreamStatus, 0, len(s.streams))
+	for _, st := range s.streams {
+		out = append(out, st.status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// StreamStatus returns the status of the named stream. A stream that was
+// never started is reported as idle.
+func (s *FFmpegStreamer) StreamStatus(name string) (StreamStatus, error) {
+	name, err := streamName(name)
+	if err != nil {
+		return StreamStatus{}, err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if st := s.streams[name]; st != nil {
+		return st.status(), nil
+	}
+	return StreamStatus{Name: name, Playlist: path.Join(name, MasterPlaylist)}, nil
+}
@@ -2,121 +2,744 @@ package streaming
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 // Streamer defines the interface for streaming operations.
+// Every stream-scoped method takes the stream name; an empty name selects
+// DefaultStreamName.
 type Streamer interface {
-	StartStream(ctx context.Context) error
-	StopStream() error
-	IsStreaming() bool
+	StartStream(ctx context.Context, opts StartOptions) error
+	StopStream(name string) (StopResult, error)
+	IsStreaming(name string) bool
+	Streams() []StreamStatus
+	StreamStatus(name string) (StreamStatus, error)
+	CurrentSession(name string) *StreamSession
+	Sessions() []StreamSession
+	Session(id string) (StreamSession, error)
+	SetEventHandler(handler func(Event))
+	Profiles() []StreamProfile
+	Encoder() EncoderInfo
+	Stats(name string) Stats
+	StartRecording(name string) (Recording, error)
+	StopRecording(name string) (Recording, error)
+	Recording(name string) *Recording
+	Replay(name string, seconds int) (Replay, error)
+	AwaitPlaylist(ctx context.Context, name string, msn, part int) error
+	AwaitPart(ctx context.Context, name, uri string) error
+	Destinations(name string) ([]DestinationStatus, error)
+	AddDestination(name string, d Destination) (DestinationStatus, error)
+	SetDestinationEnabled(name, id string, enabled bool) (DestinationStatus, error)
+	RemoveDestination(name, id string) error
+	Snapshot(ctx context.Context, name string, width int) ([]byte, error)
+	Poster(name string) ([]byte, error)
+	SubscribePreview(name string) (<-chan []byte, func(), error)
+	Overlays(profile string) ([]Overlay, error)
+	SetOverlays(profile string, overlays []Overlay) ([]Overlay, error)
+	SetLevelHandler(handler func(AudioLevels))
+	Levels(name string) (AudioLevels, error)
+	Key(name string) ([]byte, error)
+	OpenWHEP(ctx context.Context, name, offer string) (WHEPSession, error)
+	CloseWHEP(name, id string) error
+}
+
+// Config holds the settings for an FFmpegStreamer.
+type Config struct {
+	HLSDir       string          // Every stream writes into a subdirectory named after it
+	Profiles     []StreamProfile // The first profile is the default; DefaultProfile() is used when empty
+	FFmpegPath   string          // Defaults to "ffmpeg"
+	EncoderChain []string        // Defaults to DefaultEncoderChain
+	Restart      RestartPolicy
+	// StartupTimeout caps how long StartStream waits for the first playlist when
+	// the caller context has no earlier deadline. Defaults to 30 seconds.
+	StartupTimeout time.Duration
+	// StopGracePeriod is how long FFmpeg may take to flush after "q" before it
+	// receives SIGTERM. Defaults to 5 seconds.
+	StopGracePeriod time.Duration
+	// StopTermTimeout is how long FFmpeg may take after SIGTERM before it is
+	// killed. Defaults to 2 seconds.
+	StopTermTimeout time.Duration
+	// RecordingDir receives recorded takes. Recording is disabled when empty.
+	RecordingDir string
+	// SpoolDir holds the segments of the recording output until a take needs
+	// them. Defaults to a directory under os.TempDir().
+	SpoolDir string
+	// Source overrides the source of every profile when set, e.g. SourceTest
+	// on machines without capture hardware.
+	Source string
+	// PosterInterval is how often the poster image of each running stream is
+	// refreshed. Defaults to 10 seconds.
+	PosterInterval time.Duration
+	// PosterDir receives the poster images, in a subdirectory per stream. It
+	// is kept apart from HLSDir so that the posters of encrypted streams are
+	// not served with their playlists. Defaults to a directory under
+	// os.TempDir().
+	PosterDir string
+	// SilenceThreshold is the RMS level in dBFS below which audio counts as
+	// silent. Defaults to -50.
+	SilenceThreshold float64
+	// SilenceDuration is how long audio must stay below SilenceThreshold
+	// before a silence alert is raised. Defaults to 10 seconds.
+	SilenceDuration time.Duration
+	// PreRoll is how much of the stream before a take is started the take
+	// includes. The spool keeps that much while no take is recorded.
+	PreRoll time.Duration
+	// PostRoll is how long a take keeps recording after it is stopped.
+	PostRoll time.Duration
+	// DVRWindow is how far back viewers can scrub in the DVR playlists of a
+	// stream. No DVR window is kept when zero, or for low-latency profiles.
+	DVRWindow time.Duration
+	// DVRMaxSize bounds the size in bytes of the segments retained for the
+	// DVR window of each stream. The window is only bounded by age when zero.
+	DVRMaxSize int64
+	// ArchiveDir receives every finished session as VOD, in a subdirectory
+	// named after the session. Without a DVR window only the segments still in
+	// the live playlist are archived. Sessions are not archived when empty.
+	ArchiveDir string
+	// ArchiveMP4 also remuxes archived sessions to a single MP4 in RecordingDir.
+	ArchiveMP4 bool
+	// KeyDir holds the keys of AES-128 encrypted HLS output, in a subdirectory
+	// per stream and session. Segments are not encrypted when empty, and
+	// low-latency profiles cannot be used then.
+	KeyDir string
+	// KeyURI is the URL path under which the keys are served; playlists name
+	// each key <KeyURI>/<stream>/<session>/<n>.key.
+	KeyURI string
+	// KeyRotation is how many segments are encrypted with a key before the
+	// next one. Defaults to DefaultKeyRotation.
+	KeyRotation int
+	// StallTimeout is how long a running stream may go without a new segment
+	// before it is flagged as stalled, at least three segment durations.
+	// Defaults to DefaultStallTimeout.
+	StallTimeout time.Duration
+	// StallRestart kills the FFmpeg process of a stalled stream so that the
+	// restart policy restarts it. Without restarts that ends the session.
+	StallRestart bool
+	// AssetsDir holds the images and fonts overlays may draw. Overlay paths
+	// are resolved within it; image overlays and custom fonts are refused
+	// when it is empty.
+	AssetsDir string
 }
 
 // FFmpegStreamer implements the Streamer interface using FFmpeg.
 type FFmpegStreamer struct {
-	cmd    *exec.Cmd
-	mutex  sync.RWMutex
-	status bool
-	hlsDir string
-	logger *logrus.Entry
+	mutex             sync.RWMutex
+	streams           map[string]*stream
+	destinations      *destinationStore
+	overlays          *overlayStore
+	sessions          []*StreamSession
+	recordingDir      string
+	spoolDir          string
+	restartPolicy     RestartPolicy
+	startupTimeout    time.Duration
+	stopGrace         time.Duration
+	stopTermTimeout   time.Duration
+	posterInterval    time.Duration
+	posterDir         string
+	silenceThreshold  float64
+	silenceDuration   time.Duration
+	preRoll           time.Duration
+	postRoll          time.Duration
+	dvrWindow         time.Duration
+	dvrMaxSize        int64
+	archiveDir        string
+	archiveMP4        bool
+	keyDir            string
+	keyURI            string
+	keyRotation       int
+	stallTimeout      time.Duration
+	stallRestart      bool
+	hlsDir            string
+	ffmpegPath        string
+	source            string // Source override from Config.Source
+	assetsDir         string
+	profiles          map[string]StreamProfile
+	profileOrder      []string
+	defaultProfile    string
+	encoderChain      []string
+	availableEncoders map[string]bool
+	selectedEncoder   string
+	eventMutex        sync.RWMutex // Guards the handlers
+	eventHandler      func(Event)
+	levelHandler      func(AudioLevels)
+	logger            *logrus.Entry
 }
 
 // NewFFmpegStreamer creates a new FFmpegStreamer instance.
-func NewFFmpegStreamer(hlsDir string, logger *logrus.Entry) *FFmpegStreamer {
-	return &FFmpegStreamer{
-		hlsDir: hlsDir,
-		logger: logger,
+func NewFFmpegStreamer(cfg Config, logger *logrus.Entry) *FFmpegStreamer {
+	profiles := cfg.Profiles
+	if len(profiles) == 0 {
+		profiles = []StreamProfile{DefaultProfile()}
+	}
+
+	ffmpegPath := cfg.FFmpegPath
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	chain := knownEncoders(cfg.EncoderChain, logger)
+	if len(chain) == 0 {
+		chain = DefaultEncoderChain
+	}
+
+	if cfg.StartupTimeout <= 0 {
+		cfg.StartupTimeout = 30 * time.Second
+	}
+	if cfg.StopGracePeriod <= 0 {
+		cfg.StopGracePeriod = 5 * time.Second
+	}
+	if cfg.StopTermTimeout <= 0 {
+		cfg.StopTermTimeout = 2 * time.Second
+	}
+	if cfg.PosterInterval <= 0 {
+		cfg.PosterInterval = 10 * time.Second
+	}
+	if cfg.SilenceThreshold == 0 {
+		cfg.SilenceThreshold = -50
+	}
+	if cfg.SilenceDuration <= 0 {
+		cfg.SilenceDuration = 10 * time.Second
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = DefaultStallTimeout
+	}
+	if cfg.KeyRotation <= 0 {
+		cfg.KeyRotation = DefaultKeyRotation
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = filepath.Join(os.TempDir(), "multimedia-sys-spool")
+	}
+	if cfg.PosterDir == "" {
+		cfg.PosterDir = filepath.Join(os.TempDir(), "multimedia-sys-posters")
+	}
+
+	s := &FFmpegStreamer{
+		hlsDir:           cfg.HLSDir,
+		streams:          make(map[string]*stream),
+		destinations:     &destinationStore{},
+		overlays:         &overlayStore{profiles: make(map[string][]Overlay)},
+		ffmpegPath:       ffmpegPath,
+		profiles:         make(map[string]StreamProfile, len(profiles)),
+		defaultProfile:   profiles[0].Name,
+		restartPolicy:    cfg.Restart,
+		startupTimeout:   cfg.StartupTimeout,
+		stopGrace:        cfg.StopGracePeriod,
+		silenceThreshold: cfg.SilenceThreshold,
+		silenceDuration:  cfg.SilenceDuration,
+		preRoll:          cfg.PreRoll,
+		postRoll:         cfg.PostRoll,
+		dvrWindow:        cfg.DVRWindow,
+		dvrMaxSize:       cfg.DVRMaxSize,
+		archiveDir:       cfg.ArchiveDir,
+		archiveMP4:       cfg.ArchiveMP4,
+		keyDir:           cfg.KeyDir,
+		assetsDir:        cfg.AssetsDir,
+		keyURI:           strings.TrimSuffix(cfg.KeyURI, "/"),
+		keyRotation:      cfg.KeyRotation,
+		stallTimeout:     cfg.StallTimeout,
+		stallRestart:     cfg.StallRestart,
+		stopTermTimeout:  cfg.StopTermTimeout,
+		posterInterval:   cfg.PosterInterval,
+		posterDir:        cfg.PosterDir,
+		recordingDir:     cfg.RecordingDir,
+		spoolDir:         cfg.SpoolDir,
+		source:           cfg.Source,
+		encoderChain:     chain,
+		selectedEncoder:  chain[len(chain)-1],
+		logger:           logger,
+	}
+	for _, p := range profiles {
+		if _, exists := s.profiles[p.Name]; !exists {
+			s.profileOrder = append(s.profileOrder, p.Name)
+		}
+		s.profiles[p.Name] = p
+	}
+	return s
+}
+
+// Profiles returns the configured stream profiles, default first, with the
+// overlays set through the API.
+func (s *FFmpegStreamer) Profiles() []StreamProfile {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	out := make([]StreamProfile, 0, len(s.profileOrder))
+	for _, name := range s.profileOrder {
+		out = append(out, s.withOverlays(s.profiles[name]))
 	}
+	return out
 }
 
-// StartStream initiates the FFmpeg streaming process.
-func (s *FFmpegStreamer) StartStream(ctx context.Context) error {
+// resolveProfile looks up and validates the profile selected by opts. Without
+// an explicit profile a stream uses the profile named like it, or the default
+// profile. The source is taken from opts, then from the streamer's configured
+// override, then from the profile itself.
+func (s *FFmpegStreamer) resolveProfile(stream string, opts StartOptions) (StreamProfile, error) {
+	name := opts.Profile
+	if name == "" {
+		name = s.defaultProfile
+		if _, ok := s.profiles[stream]; ok {
+			name = stream
+		}
+	}
+	p, ok := s.profiles[name]
+	if !ok {
+		return StreamProfile{}, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	p = s.withOverlays(p)
+	overlays, err := s.resolveAssets(p.Overlays)
+	if err != nil {
+		return StreamProfile{}, fmt.Errorf("%w %q: %v", ErrInvalidProfile, p.Name, err)
+	}
+	p.Overlays = overlays
+	switch {
+	case opts.Source != "":
+		p.Source = opts.Source
+	case s.source != "":
+		p.Source = s.source
+	}
+	if err := p.Validate(); err != nil {
+		return StreamProfile{}, err
+	}
+	if p.LowLatency && s.keyDir != "" {
+		return StreamProfile{}, fmt.Errorf("%w %q: low_latency output cannot be encrypted", ErrInvalidProfile, p.Name)
+	}
+	return p, nil
+}
+
+// StartStream initiates the FFmpeg streaming process for the stream named in
+// opts using the selected profile and source. The context only bounds the
+// startup handshake; the stream keeps running until StopStream is called.
+func (s *FFmpegStreamer) StartStream(ctx context.Context, opts StartOptions) error {
+	name, err := streamName(opts.Stream)
+	if err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 
-	if s.status {
-		s.logger.Warn("Stream already running")
+	st := s.streams[name]
+	// The new session's output must not overwrite the segments of the last
+	// one before they are staged for its archive
+	for st != nil && st.staging != nil {
+		staging := st.staging
+		s.mutex.Unlock()
+		select {
+		case <-staging:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mutex.Lock()
+		st = s.streams[name]
+		if st != nil && st.staging == staging {
+			st.staging = nil
+		}
+	}
+	if st != nil && st.session != nil {
+		s.mutex.Unlock()
+		s.logger.Warnf("Stream %s already running", name)
 		return nil
 	}
 
-	streamPath := filepath.Join(s.hlsDir, "playlist.m3u8")
-	s.logger.Infof("Starting stream, outputting to %s", streamPath)
-
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-f", "v4l2", "-i", "/dev/video0",
-		"-f", "alsa", "-i", "hw:1,0",
-		"-c:v", "h264_omx", // Hardware-accelerated encoder
-		"-preset", "veryfast",
-		"-maxrate", "2000k",
-		"-bufsize", "4000k",
-		"-pix_fmt", "yuv420p",
-		"-g", "50",
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-ar", "44100",
-		"-f", "hls",
-		"-hls_time", "4",
-		"-hls_list_size", "15",
-		"-hls_flags", "delete_segments",
-		streamPath,
-	)
-
-	// Redirect stdout and stderr for logging
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	if err := cmd.Start(); err != nil {
-		s.logger.Errorf("Failed to start FFmpeg: %v", err)
+	p, err := s.resolveProfile(name, opts)
+	if err != nil {
+		s.mutex.Unlock()
+		s.logger.Errorf("Cannot start stream %s: %v", name, err)
+		return err
+	}
+	if st == nil {
+		st = &stream{name: name, hlsDir: filepath.Join(s.hlsDir, name)}
+		s.streams[name] = st
+	}
+	if err := os.MkdirAll(st.hlsDir, 0755); err != nil {
+		s.mutex.Unlock()
 		return err
 	}
 
-	s.cmd = cmd
-	s.status = true
-	s.logger.Info("FFmpeg stream started successfully")
+	encoder := s.resolveEncoder(p)
+	masterPath := filepath.Join(st.hlsDir, MasterPlaylist)
+	s.logger.Infof("Starting stream %s with profile %q, %s source and encoder %s, outputting to %s", name, p.Name, p.source(), encoder, masterPath)
 
-	// Monitor the FFmpeg process
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			s.logger.Errorf("FFmpeg process exited with error: %v", err)
-		} else {
-			s.logger.Info("FFmpeg process exited gracefully")
+	sess := newSession(name, p.Name, encoder)
+	s.addSession(st, sess)
+	st.profile = p
+	st.stats = Stats{}
+	st.preview = newPreviewHub()
+	feed, err := newWebRTCFeed(name, p.webrtcFramerate(), s.logger)
+	if err != nil {
+		sess.fail(err)
+		st.clear()
+		s.mutex.Unlock()
+		return err
+	}
+	st.webrtc = feed
+	st.meter = newAudioMeter(name, sess.ID, s.silenceThreshold, s.silenceDuration)
+	if s.recordingDir != "" {
+		rec, err := newRecorder(filepath.Join(s.spoolDir, sess.ID), s.preRoll, s.postRoll, s.logger)
+		if err != nil {
+			sess.fail(err)
+			st.clear()
+			s.mutex.Unlock()
+			return err
 		}
-		s.mutex.Lock()
-		s.status = false
+		st.recorder = rec
+	}
+	if p.LowLatency {
+		lp, err := newLLPackager(st.hlsDir, p, s.logger)
+		if err != nil {
+			sess.fail(err)
+			st.clear()
+			s.mutex.Unlock()
+			return err
+		}
+		st.llPackager = lp
+	}
+	if s.dvrWindow > 0 && !p.LowLatency {
+		dvr, err := newDVRArchive(st.hlsDir, p, s.dvrWindow, s.dvrMaxSize, s.logger)
+		if err != nil {
+			sess.fail(err)
+			st.clear()
+			s.mutex.Unlock()
+			return err
+		}
+		st.dvr = dvr
+	}
+	if s.keyDir != "" {
+		keys, err := s.newKeyRing(st, sess.ID)
+		if err != nil {
+			sess.fail(err)
+			st.clear()
+			s.mutex.Unlock()
+			return err
+		}
+		st.keys = keys
+	}
+	proc, err := s.launch(st, sess, p, encoder)
+	if err != nil {
+		sess.fail(err)
+		st.clear()
 		s.mutex.Unlock()
-	}()
+		return err
+	}
+	stop := make(chan struct{})
+	st.proc = proc
+	st.stop = stop
+	s.mutex.Unlock()
 
-	return nil
-}
+	// Wait for the first playlist without holding the lock so that status
+	// queries and StopStream stay responsive during startup.
+	proc, err = s.awaitStartup(ctx, st, sess, p, encoder, proc, stop)
 
-// StopStream terminates the FFmpeg streaming process.
-func (s *FFmpegStreamer) StopStream() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if !s.status || s.cmd == nil || s.cmd.Process == nil {
-		s.logger.Warn("No active stream to stop")
-		return nil
+	select {
+	case <-stop:
+		return fmt.Errorf("stream %s was stopped during startup", sess.ID)
+	default:
 	}
-
-	s.logger.Info("Stopping FFmpeg stream...")
-	if err := s.cmd.Process.Kill(); err != nil {
-		s.logger.Errorf("Failed to kill FFmpeg process: %v", err)
+	if err != nil {
+		s.logger.Errorf("Stream session %s failed to start: %v", sess.ID, err)
+		close(stop)
+		proc.kill()
+		sess.fail(err)
+		st.clear()
 		return err
 	}
 
-	s.status = false
-	s.logger.Info("FFmpeg stream stopped successfully")
+	if p.listens() {
+		// The session goes live once the sender's media arrives
+		sess.setState(SessionListening)
+		s.logger.Infof("Stream %s session %s is waiting for a sender on %s", name, sess.ID, p.URL)
+		go s.awaitSender(st, sess, proc, sess.StartedAt, stop)
+	} else {
+		sess.setState(SessionLive)
+		s.logger.Infof("FFmpeg stream %s session %s is live", name, sess.ID)
+		s.startRelays(st)
+	}
+
+	// Monitor the FFmpeg process, restarting it according to the restart policy
+	relaunch := func() (*process, error) {
+		return s.launch(st, sess, p, encoder)
+	}
+	go s.supervise(st, sess, proc, relaunch, stop)
+	go s.runPoster(st, sess, p, stop)
+	go s.runWatchdog(st, sess, p, stop)
+
+	return nil
+}
+
+// launch starts FFmpeg for the session, adding the recording output when
+// recording is enabled and the MJPEG preview, WebRTC feed and audio meter as
+// side outputs. The WebRTC feed shares the program's video encode. Callers
+// must hold the mutex.
+func (s *FFmpegStreamer) launch(st *stream, sess *StreamSession, p StreamProfile, encoder string) (*process, error) {
+	outDir := st.hlsDir
+	if st.llPackager != nil {
+		dir, err := st.llPackager.nextRun()
+		if err != nil {
+			return nil, err
+		}
+		outDir = dir
+	}
+	if st.dvr != nil {
+		st.dvr.nextRun()
+	}
+	var keyInfo string
+	if st.keys != nil {
+		keyInfo = st.keys.infoFile()
+	}
+	p.silentAudio = st.silentAudio
+
+	// Side outputs are passed to FFmpeg as pipe:3 onwards
+	var sideOutputs, readers []*os.File
+	closePipes := func() {
+		for _, f := range append(sideOutputs, readers...) {
+			f.Close()
+		}
+	}
+	sideOutput := func() (string, *os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return "", nil, err
+		}
+		output := fmt.Sprintf("pipe:%d", 3+len(sideOutputs))
+		sideOutputs = append(sideOutputs, w)
+		readers = append(readers, r)
+		return output, r, nil
+	}
+	var preview, video, audio, levels *os.File
+	var previewOutput, audioOutput, levelOutput string
+	var err error
+	if st.preview != nil {
+		if previewOutput, preview, err = sideOutput(); err != nil {
+			closePipes()
+			return nil, err
+		}
+	}
+	if st.webrtc != nil {
+		if p.webrtcVideo, video, err = sideOutput(); err != nil {
+			closePipes()
+			return nil, err
+		}
+		if audioOutput, audio, err = sideOutput(); err != nil {
+			closePipes()
+			return nil, err
+		}
+	}
+	if st.meter != nil {
+		if levelOutput, levels, err = sideOutput(); err != nil {
+			closePipes()
+			return nil, err
+		}
+	}
+
+	args := p.commandArgs(encoder, outDir, keyInfo, st.dvr != nil)
+	var runDir string
+	if st.recorder != nil {
+		dir, err := st.recorder.nextRun()
+		if err != nil {
+			closePipes()
+			return nil, err
+		}
+		runDir = dir
+		args = append(args, p.recordArgs(encoder, runDir)...)
+	}
+	if preview != nil {
+		args = append(args, p.previewArgs(previewOutput)...)
+	}
+	if audio != nil {
+		args = append(args, p.webrtcAudioArgs(audioOutput)...)
+	}
+	if levels != nil {
+		args = append(args, p.levelArgs(levelOutput)...)
+	}
+
+	proc, err := s.spawn(args, func(stats Stats) {
+		s.recordStats(st, sess, stats)
+	}, sideOutputs...)
+	if err != nil {
+		closePipes()
+		return nil, err
+	}
+	// Consumers read through isolate so that none of them can hold up or
+	// fail FFmpeg
+	if preview != nil {
+		go st.preview.consume(isolate(preview))
+	}
+	if st.webrtc != nil {
+		go st.webrtc.consumeVideo(isolate(video))
+		go st.webrtc.consumeAudio(isolate(audio))
+	}
+	if levels != nil {
+		go s.consumeLevels(st.meter, isolate(levels))
+	}
+	if st.recorder != nil {
+		go st.recorder.watch(runDir, proc)
+	}
+	if st.llPackager != nil {
+		go st.llPackager.watch(outDir, proc)
+	}
+	if st.dvr != nil {
+		go st.dvr.watch(proc)
+	}
+	if st.keys != nil {
+		go st.keys.watch(proc)
+	}
+	return proc, nil
+}
+
+// awaitLive blocks until FFmpeg writes the master playlist, exits, or ctx ends.
+func (s *FFmpegStreamer) awaitLive(ctx context.Context, proc *process, playlistPath string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.startupTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stream did not go live: %w", ctx.Err())
+		case <-proc.done:
+			if proc.err != nil {
+				return fmt.Errorf("ffmpeg exited during startup: %w", proc.err)
+			}
+			return errors.New("ffmpeg exited during startup")
+		case <-ticker.C:
+			if info, err := os.Stat(playlistPath); err == nil && !info.ModTime().Before(since) {
+				return nil
+			}
+		}
+	}
+}
+
+// process wraps a running FFmpeg command and its exit status.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser // Used to send FFmpeg the "q" command
+	log   *outputTail    // End of FFmpeg's log output
+	done  chan struct{}  // Closed once the process has exited
+	err   error          // Exit error, valid after done is closed
+}
+
+// kill forcibly terminates the process if it is still running.
+func (p *process) kill() error {
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
 	return nil
 }
 
-// IsStreaming returns the current streaming status.
-func (s *FFmpegStreamer) IsStreaming() bool {
+// spawn starts FFmpeg with args and waits for it in the background. Progress
+// written to stdout is passed to report. sideOutputs are passed to FFmpeg as
+// file descriptors 3 and up ("pipe:3", ...) and closed in this process.
+func (s *FFmpegStreamer) spawn(args []string, report func(Stats), sideOutputs ...*os.File) (*process, error) {
+	defer func() {
+		for _, f := range sideOutputs {
+			f.Close()
+		}
+	}()
+	cmd := exec.Command(s.ffmpegPath, args...)
+	log := &outputTail{}
+	cmd.Stderr = log
+	cmd.ExtraFiles = sideOutputs
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	progress, progressWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = progressWriter
+
+	err = cmd.Start()
+	progressWriter.Close()
+	if err != nil {
+		progress.Close()
+		s.logger.Errorf("Failed to start FFmpeg: %v", err)
+		return nil, err
+	}
+
+	go func() {
+		defer progress.Close()
+		parseProgress(progress, report)
+	}()
+
+	proc := &process{cmd: cmd, stdin: stdin, log: log, done: make(chan struct{})}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+	return proc, nil
+}
+
+// StopStream stops the named stream in stages so that FFmpeg can finalize
+// the playlist and any file outputs before being forced to exit.
+func (s *FFmpegStreamer) StopStream(name string) (StopResult, error) {
+	s.mutex.Lock()
+	st := s.lookupStream(name)
+	if st == nil || st.session == nil || st.session.State == SessionStopping {
+		s.mutex.Unlock()
+		s.logger.Warnf("No active stream %q to stop", name)
+		return StopResult{}, nil
+	}
+	sess, proc, rec := st.session, st.proc, st.recorder
+
+	s.logger.Infof("Stopping FFmpeg stream %s session %s...", st.name, sess.ID)
+	sess.setState(SessionStopping)
+	close(st.stop)
+	st.stopRelays()
+	s.mutex.Unlock()
+
+	started := time.Now()
+	stage, err := StopStageExited, error(nil)
+	if proc != nil {
+		// End a take in progress first so that it includes the segment that
+		// FFmpeg finalizes while shutting down.
+		if rec != nil {
+			s.stopActiveTake(sess, rec, proc.done)
+		}
+		stage, err = proc.shutdown(s.stopGrace, s.stopTermTimeout)
+	}
+	result := StopResult{
+		Stream:   st.name,
+		Session:  sess.ID,
+		Stage:    stage,
+		Clean:    stage == StopStageQuit || stage == StopStageExited,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dvr := st.dvr
+	st.clear()
+	if err != nil {
+		s.logger.Errorf("Failed to stop FFmpeg process: %v", err)
+		sess.fail(err)
+		s.stageArchive(st, sess, dvr)
+		return result, err
+	}
+
+	sess.setState(SessionStopped)
+	s.logger.Infof("FFmpeg stream %s stopped (%s)", st.name, result.Message())
+	s.stageArchive(st, sess, dvr)
+	return result, nil
+}
+
+// IsStreaming reports whether the named stream is running.
+func (s *FFmpegStreamer) IsStreaming(name string) bool {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return s.status
-}
\ No newline at end of file
+	st := s.lookupStream(name)
+	return st != nil && st.session != nil
+}
@@ -0,0 +1,234 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RestartPolicy controls how the streamer recovers when FFmpeg exits unexpectedly.
+type RestartPolicy struct {
+	Enabled        bool
+	InitialBackoff time.Duration // Delay before the first restart
+	MaxBackoff     time.Duration // Upper bound for the exponential backoff
+	MaxRestarts    int           // Restarts allowed before giving up
+	ResetAfter     time.Duration // A run lasting this long resets the restart budget
+}
+
+// DefaultRestartPolicy returns a supervised policy suitable for unattended rigs.
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Enabled:        true,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		MaxRestarts:    5,
+		ResetAfter:     time.Minute,
+	}
+}
+
+// backoff returns the delay before the given restart attempt (starting at 1).
+func (rp RestartPolicy) backoff(attempt int) time.Duration {
+	delay := rp.InitialBackoff
+	for i := 1; i < attempt && delay < rp.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > rp.MaxBackoff {
+		delay = rp.MaxBackoff
+	}
+	return delay
+}
+
+// EventType identifies a stream lifecycle transition.
+type EventType string
+
+const (
+	EventStarted    EventType = "started"
+	EventStopped    EventType = "stopped"
+	EventCrashed    EventType = "crashed"
+	EventRestarting EventType = "restarting"
+	EventRestarted  EventType = "restarted"
+	EventGaveUp     EventType = "gave_up"
+	EventLive       EventType = "live" // A listening source receives media
+
+	EventRecordingStarted EventType = "recording_started"
+	EventRecordingSaved   EventType = "recording_saved"
+	EventRecordingFailed  EventType = "recording_failed"
+
+	EventDestinationLive     EventType = "destination_live"
+	EventDestinationRetrying EventType = "destination_retrying"
+
+	EventAudioSilent   EventType = "audio_silent"
+	EventAudioRestored EventType = "audio_restored"
+
+	EventReplaySaved  EventType = "replay_saved"
+	EventReplayFailed EventType = "replay_failed"
+
+	EventSessionArchived EventType = "session_archived"
+	EventArchiveFailed   EventType = "archive_failed"
+
+	EventStalled EventType = "stalled"
+	EventResumed EventType = "resumed"
+)
+
+// Event describes a stream lifecycle transition reported to the event handler.
+type Event struct {
+	Type    EventType `json:"type"`
+	Stream  string    `json:"stream"`
+	Session string    `json:"session"`
+	// Destination is the ID of the restream destination of destination events.
+	Destination string `json:"destination,omitempty"`
+	Message     string `json:"message"`
+	Attempt     int    `json:"attempt,omitempty"`
+	Delay       string `json:"delay,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SetEventHandler registers a callback for stream lifecycle events.
+func (s *FFmpegStreamer) SetEventHandler(handler func(Event)) {
+	s.eventMutex.Lock()
+	defer s.eventMutex.Unlock()
+	s.eventHandler = handler
+}
+
+// emit delivers an event to the registered handler. It must not be called with s.mutex held.
+func (s *FFmpegStreamer) emit(ev Event) {
+	s.eventMutex.RLock()
+	handler := s.eventHandler
+	s.eventMutex.RUnlock()
+	if handler != nil {
+		handler(ev)
+	}
+}
+
+// supervise waits for the FFmpeg process and, depending on the restart policy,
+// relaunches it after unexpected exits until stop is closed or the budget runs out.
+func (s *FFmpegStreamer) supervise(st *stream, sess *StreamSession, proc *process, relaunch func() (*process, error), stop <-chan struct{}) {
+	restarts := 0
+	for {
+		startedAt := time.Now()
+		select {
+		case <-stop:
+			return
+		case <-proc.done:
+		}
+
+		select {
+		case <-stop:
+			s.logger.Infof("FFmpeg process of stream %s exited after stop request", sess.Stream)
+			return
+		default:
+		}
+
+		err := proc.err
+		if err != nil {
+			s.logger.Errorf("FFmpeg process of stream %s exited with error: %v", sess.Stream, err)
+		} else {
+			s.logger.Warnf("FFmpeg process of stream %s exited unexpectedly", sess.Stream)
+			err = errors.New("ffmpeg exited")
+		}
+		s.emit(Event{Type: EventCrashed, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Stream %s crashed: %v", sess.Stream, err), Error: err.Error()})
+		s.useSilence(st, proc)
+
+		if !s.restartPolicy.Enabled {
+			s.endSession(st, sess, err, stop)
+			return
+		}
+		if time.Since(startedAt) >= s.restartPolicy.ResetAfter {
+			restarts = 0
+		}
+
+		proc = s.restart(st, sess, &restarts, relaunch, stop)
+		if proc == nil {
+			return
+		}
+	}
+}
+
+// restart relaunches FFmpeg with backoff. It returns nil when the stream was
+// stopped or the restart budget is exhausted.
+func (s *FFmpegStreamer) restart(st *stream, sess *StreamSession, restarts *int, relaunch func() (*process, error), stop <-chan struct{}) *process {
+	s.mutex.Lock()
+	sess.setState(SessionStarting)
+	s.mutex.Unlock()
+
+	for {
+		if *restarts >= s.restartPolicy.MaxRestarts {
+			msg := fmt.Sprintf("Stream %s gave up after %d restart attempts", sess.Stream, *restarts)
+			s.logger.Error(msg)
+			s.endSession(st, sess, errors.New(msg), stop)
+			s.emit(Event{Type: EventGaveUp, Stream: sess.Stream, Session: sess.ID, Message: msg, Attempt: *restarts})
+			return nil
+		}
+
+		*restarts++
+		delay := s.restartPolicy.backoff(*restarts)
+		s.logger.Warnf("Restarting FFmpeg for stream %s in %s (attempt %d/%d)", sess.Stream, delay, *restarts, s.restartPolicy.MaxRestarts)
+		s.emit(Event{
+			Type:    EventRestarting,
+			Stream:  sess.Stream,
+			Session: sess.ID,
+			Message: fmt.Sprintf("Stream %s restarting in %s (attempt %d/%d)", sess.Stream, delay, *restarts, s.restartPolicy.MaxRestarts),
+			Attempt: *restarts,
+			Delay:   delay.String(),
+		})
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+
+		s.mutex.Lock()
+		select {
+		case <-stop:
+			s.mutex.Unlock()
+			return nil
+		default:
+		}
+		relaunchedAt := time.Now()
+		proc, err := relaunch()
+		listening := err == nil && st.profile.listens()
+		if err == nil {
+			st.proc = proc
+			sess.Restarts++
+			if listening {
+				sess.setState(SessionListening)
+			} else {
+				sess.setState(SessionLive)
+			}
+		}
+		s.mutex.Unlock()
+
+		if err != nil {
+			s.logger.Errorf("Failed to restart FFmpeg for stream %s: %v", sess.Stream, err)
+			continue
+		}
+		if listening {
+			go s.awaitSender(st, sess, proc, relaunchedAt, stop)
+		}
+		s.logger.Infof("FFmpeg restarted for stream %s (attempt %d)", sess.Stream, *restarts)
+		s.emit(Event{Type: EventRestarted, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Stream %s restarted", sess.Stream), Attempt: *restarts})
+		return proc
+	}
+}
+
+// endSession marks the session failed unless it was already stopped on purpose.
+func (s *FFmpegStreamer) endSession(st *stream, sess *StreamSession, err error, stop <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-stop:
+	default:
+		sess.fail(err)
+		if st.session == sess {
+			if st.recorder != nil {
+				exited := make(chan struct{})
+				close(exited)
+				s.stopActiveTake(sess, st.recorder, exited)
+			}
+			dvr := st.dvr
+			st.clear()
+			s.stageArchive(st, sess, dvr)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package streaming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	policy := RestartPolicy{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+	tests := []struct {
+		policy  RestartPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{policy, 0, time.Second},
+		{policy, 1, time.Second},
+		{policy, 2, 2 * time.Second},
+		{policy, 3, 4 * time.Second},
+		{policy, 5, 16 * time.Second},
+		{policy, 6, 30 * time.Second},
+		{policy, 100, 30 * time.Second},
+		{RestartPolicy{InitialBackoff: time.Minute, MaxBackoff: 30 * time.Second}, 1, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.backoff(tt.attempt); got != tt.want {
+			t.Errorf("%+v backoff(%d) = %s, want %s", tt.policy, tt.attempt, got, tt.want)
+		}
+	}
+}
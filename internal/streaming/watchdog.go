@@ -0,0 +1,138 @@
+package streaming
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultStallTimeout is how long a stream may go without a new segment
+	// before it counts as stalled when Config.StallTimeout is zero.
+	DefaultStallTimeout = 20 * time.Second
+	// stallPollInterval is how often the watchdog checks the live playlist.
+	stallPollInterval = time.Second
+	// stallSegments is the minimum stall timeout in segment durations.
+	stallSegments = 3
+)
+
+// playlistProgress identifies the newest segment of a live playlist. FFmpeg
+// is producing output for as long as it keeps changing.
+type playlistProgress struct {
+	sequence int
+	modTime  time.Time
+}
+
+// readProgress returns the progress of the media playlist at playlistPath,
+// or false while it lists no segment.
+func readProgress(playlistPath string) (playlistProgress, bool) {
+	pl, err := readMediaPlaylist(playlistPath)
+	if err != nil || len(pl.Segments) == 0 {
+		return playlistProgress{}, false
+	}
+	seg := pl.Segments[len(pl.Segments)-1]
+	pr := playlistProgress{sequence: seg.Sequence}
+	if info, err := os.Stat(filepath.Join(filepath.Dir(playlistPath), seg.URI)); err == nil {
+		pr.modTime = info.ModTime()
+	}
+	return pr, true
+}
+
+// stallLimit returns how long the output of p may stand still before the
+// stream counts as stalled: the configured timeout, but at least
+// stallSegments segment durations.
+func (s *FFmpegStreamer) stallLimit(p StreamProfile) time.Duration {
+	if floor := time.Duration(stallSegments*p.HLSTime) * time.Second; s.stallTimeout < floor {
+		return floor
+	}
+	return s.stallTimeout
+}
+
+// runWatchdog flags the session as stalled when FFmpeg keeps running but its
+// playlist stops progressing, as it does when a capture device hangs, until
+// the session ends. Every FFmpeg run is armed as it goes live, with the stall
+// limit to list a new segment, so that a run that never produces one stalls
+// too; listening for a sender is not mistaken for a stall. Like runPoster, it
+// is passed the profile of its session.
+func (s *FFmpegStreamer) runWatchdog(st *stream, sess *StreamSession, p StreamProfile, stop <-chan struct{}) {
+	ticker := time.NewTicker(stallPollInterval)
+	defer ticker.Stop()
+
+	playlistPath := p.restreamInput(st.hlsDir)
+	timeout := s.stallLimit(p)
+	var (
+		proc       *process
+		last       playlistProgress
+		armed      bool
+		progressAt time.Time
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.mutex.RLock()
+		current, live, running := st.session == sess, sess.State == SessionLive, st.proc
+		s.mutex.RUnlock()
+		if !current {
+			return
+		}
+		if running != proc || !live {
+			proc, armed = running, false
+		}
+		if !live {
+			continue
+		}
+
+		now := time.Now()
+		pr, ok := readProgress(playlistPath)
+		switch {
+		case !armed:
+			// Segments listed so far may be left over from the previous run
+			last, armed, progressAt = pr, true, now
+		case ok && pr != last:
+			last, progressAt = pr, now
+			s.markStalled(st, sess, false, 0)
+		case now.Sub(progressAt) >= timeout:
+			s.markStalled(st, sess, true, now.Sub(progressAt))
+		}
+	}
+}
+
+// markStalled records whether sess is stalled, idle for how long, and
+// announces changes. A stalled FFmpeg is killed when the streamer restarts
+// stalled streams, so that the supervisor restarts it.
+func (s *FFmpegStreamer) markStalled(st *stream, sess *StreamSession, stalled bool, idle time.Duration) {
+	s.mutex.Lock()
+	changed := st.session == sess && (sess.StalledSince != nil) != stalled
+	if changed {
+		if stalled {
+			since := time.Now().Add(-idle)
+			sess.StalledSince = &since
+		} else {
+			sess.StalledSince = nil
+		}
+	}
+	proc := st.proc
+	s.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	if !stalled {
+		s.logger.Infof("Stream %s is producing segments again", sess.Stream)
+		s.emit(Event{Type: EventResumed, Stream: sess.Stream, Session: sess.ID, Message: fmt.Sprintf("Stream %s is producing segments again", sess.Stream)})
+		return
+	}
+	msg := fmt.Sprintf("Stream %s stalled: no new segment for %s", sess.Stream, idle.Round(time.Second))
+	s.logger.Warn(msg)
+	s.emit(Event{Type: EventStalled, Stream: sess.Stream, Session: sess.ID, Message: msg})
+	if s.stallRestart && proc != nil {
+		s.logger.Warnf("Killing stalled FFmpeg process of stream %s", sess.Stream)
+		if err := proc.kill(); err != nil {
+			s.logger.Errorf("Failed to kill stalled FFmpeg process of stream %s: %v", sess.Stream, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package streaming
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchdogCatchesRestartsWithoutSegments(t *testing.T) {
+	p := DefaultProfile()
+	p.HLSTime = 1
+	cfg := fakeConfig(t, p)
+	cfg.StallTimeout = time.Millisecond // Raised to three segment durations
+	cfg.StallRestart = true
+	cfg.Restart = RestartPolicy{Enabled: true, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, MaxRestarts: 5, ResetAfter: time.Minute}
+	// Only the runs after the first one never list a segment
+	t.Setenv("FAKE_FFMPEG_STALL", "0")
+	t.Setenv("FAKE_FFMPEG_STALL_RESTARTS", filepath.Join(t.TempDir(), "restarted"))
+	s := NewFFmpegStreamer(cfg, testLogger())
+	events := recordEvents(s)
+
+	if err := s.StartStream(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	defer s.StopStream("")
+
+	// The first run dies; its replacement is armed without listing a segment
+	s.mutex.RLock()
+	proc := s.streams[DefaultStreamName].proc
+	s.mutex.RUnlock()
+	if err := proc.kill(); err != nil {
+		t.Fatal(err)
+	}
+	occurred := func(typ EventType, attempt int) func() bool {
+		return func() bool {
+			for _, ev := range events() {
+				if ev.Type == typ && ev.Attempt == attempt {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	waitFor(t, 10*time.Second, "the restarted run to stall", occurred(EventStalled, 0))
+
+	// The stalled run is killed and its replacement starts out not stalled
+	waitFor(t, 5*time.Second, "the second restart", occurred(EventRestarted, 2))
+	if sess := s.CurrentSession(""); sess.StalledSince != nil {
+		t.Errorf("session stalled since %s after a restart", sess.StalledSince)
+	}
+}
@@ -0,0 +1,391 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultWebRTCFramerate is the frame rate assumed for the WebRTC feed
+	// when the profile keeps the device default.
+	defaultWebRTCFramerate = 30
+	// opusSampleRate is the RTP clock rate of Opus.
+	opusSampleRate = 48000
+	// opusFrameDuration is the duration of the Opus packets FFmpeg writes.
+	opusFrameDuration = 20 * time.Millisecond
+)
+
+var (
+	// annexBStartCode begins every NAL unit of an Annex B stream.
+	annexBStartCode = []byte{0, 0, 1}
+	// oggCapturePattern begins every Ogg page.
+	oggCapturePattern = []byte("OggS")
+)
+
+var (
+	// ErrInvalidOffer is returned when a WHEP offer cannot be negotiated.
+	ErrInvalidOffer = errors.New("invalid WebRTC offer")
+	// ErrViewerNotFound is returned when a WHEP session ID is unknown.
+	ErrViewerNotFound = errors.New("WebRTC viewer not found")
+)
+
+// WHEPSession is a WebRTC viewer of a stream created from a WHEP offer.
+type WHEPSession struct {
+	ID     string `json:"id"`
+	Stream string `json:"stream"`
+	Answer string `json:"-"` // SDP answer including every ICE candidate
+}
+
+// webrtcFramerate returns the frame rate the WebRTC feed times video
+// samples with.
+func (p StreamProfile) webrtcFramerate() int {
+	if p.Framerate > 0 {
+		return p.Framerate
+	}
+	return defaultWebRTCFramerate
+}
+
+// programOutput returns the muxer arguments of the program output. With a
+// WebRTC feed the output is split with the tee muxer so that the feed gets
+// the program's own H.264 as Annex B on p.webrtcVideo instead of a second
+// encode. The program then drops B-frames, which WebRTC players do not
+// handle, and the feed's branch is dropped rather than failing the stream
+// if its pipe breaks.
+func (p StreamProfile) programOutput(muxer []string) []string {
+	if p.webrtcVideo == "" {
+		return muxer
+	}
+	args := []string{"-bf", "0"}
+	if p.LowLatency {
+		// fMP4 needs the parameter sets before the first frame, which tee
+		// only passes on from the encoder's global header
+		args = append(args, "-flags:v", "+global_header")
+	}
+	feed := "[select=" + teeValue("v:0") + ":f=h264:bsfs/v=dump_extra:onfail=ignore]" + teeEscape(p.webrtcVideo)
+	return append(args, "-f", "tee", teeSlave(muxer)+"|"+feed)
+}
+
+// teeSlave turns muxer arguments of the form "-f muxer -option value ...
+// output" into a slave of the tee muxer.
+func teeSlave(muxer []string) string {
+	var options []string
+	for i := 0; i+1 < len(muxer); i += 2 {
+		options = append(options, strings.TrimPrefix(muxer[i], "-")+"="+teeValue(muxer[i+1]))
+	}
+	return "[" + strings.Join(options, ":") + "]" + teeEscape(muxer[len(muxer)-1])
+}
+
+// teeValue quotes v as a slave option value of the tee muxer, which parses
+// the slave list and then every slave's options.
+func teeValue(v string) string {
+	return teeEscape("'" + strings.ReplaceAll(v, "'", `'\''`) + "'")
+}
+
+// teeEscape escapes the characters the tee muxer splits its slave list on.
+func teeEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `|`, `\|`).Replace(v)
+}
+
+// webrtcAudioArgs returns the FFmpeg arguments for the audio of the WebRTC
+// feed: Opus in Ogg to output, as WebRTC players do not support AAC.
+func (p StreamProfile) webrtcAudioArgs(output string) []string {
+	return []string{
+		"-map", p.audioStream(),
+		"-c:a", "libopus",
+		"-b:a", kbps(p.AudioBitrateKbps),
+		"-ar", strconv.Itoa(opusSampleRate),
+		"-application", "lowdelay",
+		"-frame_duration", "20",
+		"-page_duration", "20000",
+		"-flush_packets", "1",
+		"-f", "ogg", output,
+	}
+}
+
+// webrtcFeed publishes a stream's WebRTC outputs to its viewers. Every viewer
+// is bound to the same pair of tracks, so samples are written once for all.
+type webrtcFeed struct {
+	mutex         sync.Mutex
+	stream        string
+	video         *webrtc.TrackLocalStaticSample
+	audio         *webrtc.TrackLocalStaticSample
+	frameDuration time.Duration
+	viewers       map[string]*webrtc.PeerConnection
+	closed        bool
+	api           *webrtc.API // Negotiates viewers; nil uses the defaults
+	logger        *logrus.Entry
+}
+
+// newWebRTCFeed creates the tracks of a stream whose video runs at fps.
+func newWebRTCFeed(stream string, fps int, logger *logrus.Entry) (*webrtcFeed, error) {
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", stream)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", stream)
+	if err != nil {
+		return nil, err
+	}
+	return &webrtcFeed{
+		stream:        stream,
+		video:         video,
+		audio:         audio,
+		frameDuration: time.Second / time.Duration(fps),
+		viewers:       make(map[string]*webrtc.PeerConnection),
+		logger:        logger,
+	}, nil
+}
+
+// newViewerID returns a random WHEP session identifier.
+func newViewerID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "whep-" + hex.EncodeToString(b)
+}
+
+// answer negotiates a viewer from a WHEP offer and returns it once ICE
+// gathering has completed, since WHEP clients may not support trickle ICE.
+func (f *webrtcFeed) answer(ctx context.Context, offer string) (WHEPSession, error) {
+	newPeerConnection := webrtc.NewPeerConnection
+	if f.api != nil {
+		newPeerConnection = f.api.NewPeerConnection
+	}
+	pc, err := newPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return WHEPSession{}, err
+	}
+	id := newViewerID()
+	fail := func(err error) (WHEPSession, error) {
+		pc.Close()
+		return WHEPSession{}, err
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrInvalidOffer, err))
+	}
+	for _, track := range []*webrtc.TrackLocalStaticSample{f.video, f.audio} {
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			return fail(fmt.Errorf("%w: %v", ErrInvalidOffer, err))
+		}
+		// Read RTCP so that the interceptors process receiver reports
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		f.logger.Debugf("WebRTC viewer %s of stream %s is %s", id, f.stream, state)
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			f.remove(id)
+		}
+	})
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrInvalidOffer, err))
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fail(err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return fail(ErrNotStreaming)
+	}
+	f.viewers[id] = pc
+	f.logger.Infof("WebRTC viewer %s joined stream %s", id, f.stream)
+	return WHEPSession{ID: id, Stream: f.stream, Answer: pc.LocalDescription().SDP}, nil
+}
+
+// remove disconnects the viewer with the given ID.
+func (f *webrtcFeed) remove(id string) error {
+	f.mutex.Lock()
+	pc, ok := f.viewers[id]
+	delete(f.viewers, id)
+	f.mutex.Unlock()
+	if !ok {
+		return ErrViewerNotFound
+	}
+	f.logger.Infof("WebRTC viewer %s left stream %s", id, f.stream)
+	// Close may wait for connection state callbacks, which call remove
+	go pc.Close()
+	return nil
+}
+
+// close disconnects every viewer.
+func (f *webrtcFeed) close() {
+	f.mutex.Lock()
+	f.closed = true
+	viewers := f.viewers
+	f.viewers = make(map[string]*webrtc.PeerConnection)
+	f.mutex.Unlock()
+	for _, pc := range viewers {
+		go pc.Close()
+	}
+}
+
+// consumeVideo writes the access units of the Annex B stream on r to the
+// video track until the pipe closes.
+func (f *webrtcFeed) consumeVideo(r io.ReadCloser) {
+	defer r.Close()
+	readAccessUnits(r, func(unit []byte) {
+		if err := f.video.WriteSample(media.Sample{Data: unit, Duration: f.frameDuration}); err != nil {
+			f.logger.Debugf("Failed to write WebRTC video of stream %s: %v", f.stream, err)
+		}
+	})
+}
+
+// readAccessUnits calls write with each access unit of the Annex B stream on
+// r until it ends. After dropped data it resumes at the next start code,
+// discarding the access unit in progress.
+func readAccessUnits(r io.Reader, write func([]byte)) {
+	br := bufio.NewReader(r)
+	for {
+		gr := &gapReader{r: br}
+		reader, err := h264reader.NewReader(gr)
+		if err != nil {
+			return
+		}
+		var unit []byte
+		var hasSlice bool
+		for {
+			nal, err := reader.NextNAL()
+			if gr.gap {
+				// The reader returns the NAL unit cut short as complete
+				break
+			}
+			if err != nil {
+				return
+			}
+			if hasSlice && startsAccessUnit(nal) {
+				write(unit)
+				unit, hasSlice = nil, false
+			}
+			unit = append(unit, 0, 0, 0, 1)
+			unit = append(unit, nal.Data...)
+			if isSlice(nal) {
+				hasSlice = true
+			}
+		}
+		if err := skipTo(br, annexBStartCode); err != nil {
+			return
+		}
+	}
+}
+
+// isSlice reports whether nal carries picture data.
+func isSlice(nal *h264reader.NAL) bool {
+	return nal.UnitType == h264reader.NalUnitTypeCodedSliceIdr || nal.UnitType == h264reader.NalUnitTypeCodedSliceNonIdr
+}
+
+// startsAccessUnit reports whether nal begins a new picture when it follows
+// a slice: parameter sets and delimiters do, as does a slice whose
+// first_mb_in_slice is zero, the leading bit of its header being set then.
+func startsAccessUnit(nal *h264reader.NAL) bool {
+	switch nal.UnitType {
+	case h264reader.NalUnitTypeAUD, h264reader.NalUnitTypeSPS, h264reader.NalUnitTypePPS:
+		return true
+	}
+	return isSlice(nal) && len(nal.Data) > 1 && nal.Data[1]&0x80 != 0
+}
+
+// consumeAudio writes the Opus packets of the Ogg stream on r to the audio
+// track until the pipe closes.
+func (f *webrtcFeed) consumeAudio(r io.ReadCloser) {
+	defer r.Close()
+	readOpus(r, func(sample media.Sample) {
+		if err := f.audio.WriteSample(sample); err != nil {
+			f.logger.Debugf("Failed to write WebRTC audio of stream %s: %v", f.stream, err)
+		}
+	})
+}
+
+// readOpus calls write with each Opus packet of the Ogg stream on r until it
+// ends. FFmpeg writes one packet per page. After dropped data it resumes at
+// the next page, which is given the nominal packet duration as the granule
+// positions between them are lost.
+func readOpus(r io.Reader, write func(media.Sample)) {
+	br := bufio.NewReader(r)
+	reader, _, err := oggreader.NewWith(br)
+	if err != nil {
+		return
+	}
+	var granule uint64
+	synced := true
+	for {
+		page, header, err := reader.ParseNextPage()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return
+		}
+		if err != nil {
+			// Data was dropped, or a page matched in the data after a gap
+			// failed its checksum
+			if err := skipTo(br, oggCapturePattern); err != nil {
+				return
+			}
+			synced = false
+			continue
+		}
+		if bytes.HasPrefix(page, []byte("OpusTags")) {
+			continue
+		}
+		duration := opusFrameDuration
+		if synced && header.GranulePosition >= granule {
+			duration = time.Duration(header.GranulePosition-granule) * time.Second / opusSampleRate
+		}
+		granule, synced = header.GranulePosition, true
+		write(media.Sample{Data: page, Duration: duration})
+	}
+}
+
+// OpenWHEP creates a WebRTC viewer of the named stream from a WHEP offer.
+func (s *FFmpegStreamer) OpenWHEP(ctx context.Context, name, offer string) (WHEPSession, error) {
+	s.mutex.RLock()
+	st := s.lookupStream(name)
+	if st == nil || st.webrtc == nil {
+		s.mutex.RUnlock()
+		return WHEPSession{}, ErrNotStreaming
+	}
+	feed := st.webrtc
+	s.mutex.RUnlock()
+	return feed.answer(ctx, offer)
+}
+
+// CloseWHEP disconnects a WebRTC viewer of the named stream.
+func (s *FFmpegStreamer) CloseWHEP(name, id string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	st := s.lookupStream(name)
+	if st == nil || st.webrtc == nil {
+		return ErrViewerNotFound
+	}
+	return st.webrtc.remove(id)
+}
@@ -0,0 +1,285 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestTeeSlaveRoundTrip(t *testing.T) {
+	muxer := []string{
+		"-f", "hls",
+		"-var_stream_map", "v:0,a:0,name:high v:1,a:1,name:low",
+		"-hls_key_info_file", `/tmp/it's a|b\c.keyinfo`,
+		"-hls_segment_filename", "/tmp/hls/%v/segment_%05d.ts",
+		"/tmp/hls:live/%v/live.m3u8",
+	}
+	slaves := parseTee(teeSlave(muxer))
+	if len(slaves) != 1 {
+		t.Fatalf("parseTee() returned %d slaves, want 1", len(slaves))
+	}
+	want := map[string]string{
+		"f":                    "hls",
+		"var_stream_map":       "v:0,a:0,name:high v:1,a:1,name:low",
+		"hls_key_info_file":    `/tmp/it's a|b\c.keyinfo`,
+		"hls_segment_filename": "/tmp/hls/%v/segment_%05d.ts",
+	}
+	if !reflect.DeepEqual(slaves[0].options, want) {
+		t.Errorf("options = %v, want %v", slaves[0].options, want)
+	}
+	if slaves[0].output != "/tmp/hls:live/%v/live.m3u8" {
+		t.Errorf("output = %q", slaves[0].output)
+	}
+}
+
+func TestProgramOutputSharesEncodeWithWebRTC(t *testing.T) {
+	single := DefaultProfile()
+	ladder := DefaultProfile()
+	ladder.Renditions = DefaultLadder()
+	lowLatency := DefaultProfile()
+	lowLatency.LowLatency = true
+	tests := []struct {
+		name    string
+		profile StreamProfile
+	}{
+		{"single", single},
+		{"ladder", ladder},
+		{"low latency", lowLatency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := tt.profile.commandArgs("libx264", "/tmp/hls", "", false)
+			shared := tt.profile
+			shared.webrtcVideo = "pipe:4"
+			args := shared.commandArgs("libx264", "/tmp/hls", "", false)
+
+			if got, want := strings.Count(strings.Join(args, " "), "libx264"), strings.Count(strings.Join(plain, " "), "libx264"); got != want {
+				t.Errorf("commandArgs() encodes %d times with WebRTC, want %d", got, want)
+			}
+			if args[len(args)-2] != "tee" {
+				t.Fatalf("commandArgs() does not end in a tee output: %v", args[len(args)-3:])
+			}
+			slaves := parseTee(args[len(args)-1])
+			if len(slaves) != 2 {
+				t.Fatalf("tee has %d slaves, want 2", len(slaves))
+			}
+
+			// The program slave carries the muxer arguments of the plain output
+			program := slaves[0]
+			i := len(plain) - 1
+			for i > 0 && !(plain[i-1] == "-f" && plain[i] == "hls") {
+				i--
+			}
+			for j := i - 1; j+1 < len(plain); j += 2 {
+				key := strings.TrimPrefix(plain[j], "-")
+				if program.options[key] != plain[j+1] {
+					t.Errorf("program slave option %s = %q, want %q", key, program.options[key], plain[j+1])
+				}
+			}
+			if program.output != plain[len(plain)-1] {
+				t.Errorf("program slave writes to %q, want %q", program.output, plain[len(plain)-1])
+			}
+
+			feed := slaves[1]
+			wantFeed := map[string]string{"select": "v:0", "f": "h264", "bsfs/v": "dump_extra", "onfail": "ignore"}
+			if !reflect.DeepEqual(feed.options, wantFeed) || feed.output != "pipe:4" {
+				t.Errorf("WebRTC slave = %+v, want options %v to pipe:4", feed, wantFeed)
+			}
+		})
+	}
+}
+
+// annexBFrame returns a keyframe access unit with parameter sets.
+func annexBFrame() []byte {
+	var b []byte
+	for _, nal := range [][]byte{
+		{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xe8},
+		{0x68, 0xce, 0x3c, 0x80},
+		{0x65, 0x88, 0x84, 0x00, 0x33, 0xff, 0xfe, 0xf6, 0xf0},
+	} {
+		b = append(b, 0, 0, 0, 1)
+		b = append(b, nal...)
+	}
+	return b
+}
+
+// loopbackAPI returns a WebRTC API whose peers connect over loopback.
+func loopbackAPI(t *testing.T) *webrtc.API {
+	t.Helper()
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	settings := webrtc.SettingEngine{}
+	settings.SetIncludeLoopbackCandidate(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	return webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithSettingEngine(settings))
+}
+
+func TestWHEPLoopback(t *testing.T) {
+	api := loopbackAPI(t)
+	feed, err := newWebRTCFeed("main", 30, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.api = api
+	defer feed.close()
+
+	// The Go WHEP client
+	client, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := client.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := make(chan string, 1)
+	client.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			return
+		}
+		if _, _, err := track.ReadRTP(); err == nil {
+			received <- track.Codec().MimeType
+		}
+	})
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(client)
+	if err := client.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := feed.answer(ctx, client.LocalDescription().SDP)
+	if err != nil {
+		t.Fatalf("answer() error = %v", err)
+	}
+	if err := client.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: session.Answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed the Annex B video FFmpeg writes until the client receives it
+	r, w := io.Pipe()
+	go feed.consumeVideo(isolate(r))
+	defer w.Close()
+	go func() {
+		ticker := time.NewTicker(feed.frameDuration)
+		defer ticker.Stop()
+		for {
+			if _, err := w.Write(annexBFrame()); err != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	select {
+	case mime := <-received:
+		if mime != webrtc.MimeTypeH264 {
+			t.Errorf("received %s video, want H.264", mime)
+		}
+	case <-ctx.Done():
+		t.Fatal("the WHEP client received no video")
+	}
+
+	if err := feed.remove(session.ID); err != nil {
+		t.Errorf("remove() error = %v", err)
+	}
+	if err := feed.remove(session.ID); err != ErrViewerNotFound {
+		t.Errorf("second remove() error = %v, want ErrViewerNotFound", err)
+	}
+}
+
+func TestReadAccessUnitsResyncsAfterGap(t *testing.T) {
+	slice := func(payload byte) []byte { return []byte{0, 0, 0, 1, 0x41, 0x9a, payload} }
+	cut := slice(5)
+	so := gappedOutput(
+		sideChunk{data: bytes.Join([][]byte{annexBFrame(), slice(2), cut[:6]}, nil)},
+		sideChunk{data: bytes.Join([][]byte{cut[6:], slice(3), slice(4)}, nil), gap: true},
+	)
+	var got [][]byte
+	readAccessUnits(so, func(unit []byte) { got = append(got, unit) })
+
+	want := [][]byte{annexBFrame(), slice(3)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("access units = %x, want %x", got, want)
+	}
+}
+
+// oggPage returns an Ogg page of stream 1 carrying payload.
+func oggPage(index uint32, granule uint64, payload []byte) []byte {
+	page := make([]byte, 27, 28+len(payload))
+	copy(page, "OggS")
+	if index == 0 {
+		page[5] = 2 // Beginning of stream
+	}
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], 1)
+	binary.LittleEndian.PutUint32(page[18:], index)
+	page[26] = 1
+	page = append(page, byte(len(payload)))
+	page = append(page, payload...)
+	var crc uint32
+	for _, b := range page {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+func TestReadOpusResyncsAfterGap(t *testing.T) {
+	head := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	cut := oggPage(4, 2880, []byte("packet 3"))
+	so := gappedOutput(
+		sideChunk{data: bytes.Join([][]byte{
+			oggPage(0, 0, head),
+			oggPage(1, 0, []byte("OpusTags")),
+			oggPage(2, 960, []byte("packet 1")),
+			oggPage(3, 1920, []byte("packet 2")),
+			cut[:20],
+		}, nil)},
+		sideChunk{data: bytes.Join([][]byte{
+			cut[30:],
+			oggPage(6, 4800, []byte("packet 5")),
+			oggPage(7, 6720, []byte("packet 6")),
+		}, nil), gap: true},
+	)
+	var got []media.Sample
+	readOpus(so, func(sample media.Sample) { got = append(got, sample) })
+
+	want := []media.Sample{
+		{Data: []byte("packet 1"), Duration: 20 * time.Millisecond},
+		{Data: []byte("packet 2"), Duration: 20 * time.Millisecond},
+		{Data: []byte("packet 5"), Duration: opusFrameDuration},
+		{Data: []byte("packet 6"), Duration: 40 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %+v, want %+v", got, want)
+	}
+}
@@ -1,62 +1,135 @@
 package videomanager
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/Cdaprod/multimedia-sys/internal/streaming"
 	"github.com/sirupsen/logrus"
 )
 
 // VideoManager defines the interface for video operations.
 type VideoManager interface {
-	ListVideos() ([]string, error)
-	ServeVideo(filename string, w http.ResponseWriter) error
+	ListVideos() ([]VideoInfo, error)
+	ServeVideo(filename string, w http.ResponseWriter, r *http.Request) error
+}
+
+// VideoInfo describes a video in the storage directory or an archived session.
+type VideoInfo struct {
+	Name       string                    `json:"name,omitempty"` // File in the storage directory, empty for a session archived without MP4
+	Size       int64                     `json:"size"`
+	ModifiedAt time.Time                 `json:"modified_at"`
+	Session    *streaming.SessionArchive `json:"session,omitempty"` // Set for archived sessions
 }
 
 // VideoManagerImpl implements the VideoManager interface.
 type VideoManagerImpl struct {
 	storageDir string
+	archiveDir string
 	logger     *logrus.Entry
 }
 
-// NewVideoManager creates a new VideoManager instance.
-func NewVideoManager(storageDir string, logger *logrus.Entry) *VideoManagerImpl {
+// NewVideoManager creates a new VideoManager instance. Sessions archived
+// under archiveDir are listed with their metadata; archiveDir may be empty.
+func NewVideoManager(storageDir, archiveDir string, logger *logrus.Entry) *VideoManagerImpl {
 	return &VideoManagerImpl{
 		storageDir: storageDir,
+		archiveDir: archiveDir,
 		logger:     logger,
 	}
 }
 
-// ListVideos retrieves a list of video filenames from the storage directory.
-func (vm *VideoManagerImpl) ListVideos() ([]string, error) {
-	var videos []string
+// ListVideos lists the videos in the storage directory and the archived
+// sessions, newest first. Videos remuxed from an archived session carry its
+// metadata.
+func (vm *VideoManagerImpl) ListVideos() ([]VideoInfo, error) {
 	files, err := os.ReadDir(vm.storageDir)
 	if err != nil {
 		vm.logger.Errorf("Failed to read storage directory: %v", err)
 		return nil, err
 	}
 
+	sessions := make(map[string]*streaming.SessionArchive)
+	var unlisted []*streaming.SessionArchive
+	for _, archive := range vm.archivedSessions() {
+		if archive.File != "" {
+			sessions[archive.File] = archive
+		} else {
+			unlisted = append(unlisted, archive)
+		}
+	}
+
+	videos := make([]VideoInfo, 0, len(files))
 	for _, file := range files {
-		if !file.IsDir() && isVideoFile(file.Name()) {
-			videos = append(videos, file.Name())
+		if file.IsDir() || !isVideoFile(file.Name()) {
+			continue
 		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		video := VideoInfo{Name: file.Name(), Size: info.Size(), ModifiedAt: info.ModTime()}
+		if archive, ok := sessions[file.Name()]; ok {
+			video.Session = archive
+			delete(sessions, file.Name())
+		}
+		videos = append(videos, video)
+	}
+	// Sessions whose MP4 was deleted are still playable from the archive
+	for _, archive := range sessions {
+		archive.File = ""
+		unlisted = append(unlisted, archive)
 	}
+	for _, archive := range unlisted {
+		videos = append(videos, VideoInfo{ModifiedAt: archive.ArchivedAt, Session: archive})
+	}
+	sort.SliceStable(videos, func(i, j int) bool { return videos[i].ModifiedAt.After(videos[j].ModifiedAt) })
+
 	vm.logger.Infof("Found %d videos", len(videos))
 	return videos, nil
 }
 
+// archivedSessions reads the metadata of the archived sessions, skipping
+// archives that are incomplete or unreadable.
+func (vm *VideoManagerImpl) archivedSessions() []*streaming.SessionArchive {
+	if vm.archiveDir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(vm.archiveDir, "*", streaming.ArchiveMetadata))
+	if err != nil {
+		return nil
+	}
+	archives := make([]*streaming.SessionArchive, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var archive streaming.SessionArchive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			vm.logger.Warnf("Skipping archived session %s: %v", filepath.Dir(path), err)
+			continue
+		}
+		archives = append(archives, &archive)
+	}
+	return archives
+}
+
 // ServeVideo streams the requested video file to the client.
-func (vm *VideoManagerImpl) ServeVideo(filename string, w http.ResponseWriter) error {
+func (vm *VideoManagerImpl) ServeVideo(filename string, w http.ResponseWriter, r *http.Request) error {
 	filePath := filepath.Join(vm.storageDir, filename)
 	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
 		vm.logger.Warnf("Requested video does not exist: %s", filePath)
 		return errors.New("file does not exist")
 	}
 
-	http.ServeFile(w, nil, filePath)
+	http.ServeFile(w, r, filePath)
 	vm.logger.Infof("Serving video: %s", filePath)
 	return nil
 }
@@ -70,4 +143,4 @@ func isVideoFile(filename string) bool {
 	default:
 		return false
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,75 @@
+package videomanager
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testLogger returns a logger that discards its output.
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+func TestServeListedVideo(t *testing.T) {
+	dir := t.TempDir()
+	take := []byte("take data standing in for an MP4")
+	if err := os.WriteFile(filepath.Join(dir, "take-1.mp4"), take, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVideoManager(dir, "", testLogger())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := vm.ServeVideo(path.Base(r.URL.Path), w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	videos, err := vm.ListVideos()
+	if err != nil {
+		t.Fatalf("ListVideos() error = %v", err)
+	}
+	if len(videos) != 1 || videos[0].Name != "take-1.mp4" {
+		t.Fatalf("ListVideos() = %+v, want the take", videos)
+	}
+
+	resp, err := http.Get(srv.URL + "/videos/" + videos[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != string(take) {
+		t.Errorf("GET take = %d %q, want 200 %q", resp.StatusCode, body, take)
+	}
+
+	// Players seek with range requests
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/videos/"+videos[0].Name, nil)
+	req.Header.Set("Range", "bytes=5-8")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "data" {
+		t.Errorf("GET range of take = %d %q, want 206 %q", resp.StatusCode, body, "data")
+	}
+
+	resp, err = http.Get(srv.URL + "/videos/missing.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET missing video = %d, want 404", resp.StatusCode)
+	}
+}
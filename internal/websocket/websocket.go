@@ -1,31 +1,55 @@
 package websocket
 
 import (
+	"encoding/json"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// writeTimeout bounds how long a client's writer waits for it before
+	// dropping it.
+	writeTimeout = 5 * time.Second
+	// sendBuffer is the number of messages queued for a client before it is
+	// dropped as too slow.
+	sendBuffer = 256
+)
+
 // WebSocketManager defines the interface for managing WebSocket connections.
 type WebSocketManager interface {
 	HandleWebSocket(w http.ResponseWriter, r *http.Request)
 	BroadcastMessage(message string)
+	BroadcastJSON(msgType string, data interface{})
+}
+
+// Message is the envelope for typed JSON messages sent to clients.
+type Message struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// client is a connected client with the messages queued for it.
+type client struct {
+	conn *websocket.Conn
+	send chan []byte
 }
 
 // WebSocketManagerImpl implements the WebSocketManager interface.
 type WebSocketManagerImpl struct {
-	clients map[*websocket.Conn]bool
-	mutex   sync.RWMutex
-	logger  *logrus.Entry
+	clients  map[*client]bool
+	mutex    sync.RWMutex
+	logger   *logrus.Entry
 	upgrader websocket.Upgrader
 }
 
 // NewWebSocketManager creates a new WebSocketManager instance.
 func NewWebSocketManager(logger *logrus.Entry) *WebSocketManagerImpl {
 	return &WebSocketManagerImpl{
-		clients: make(map[*websocket.Conn]bool),
+		clients: make(map[*client]bool),
 		logger:  logger,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
@@ -46,8 +70,10 @@ func (wm *WebSocketManagerImpl) HandleWebSocket(w http.ResponseWriter, r *http.R
 	}
 	defer conn.Close()
 
-	wm.addClient(conn)
-	defer wm.removeClient(conn)
+	c := &client{conn: conn, send: make(chan []byte, sendBuffer)}
+	wm.addClient(c)
+	defer wm.removeClient(c)
+	go wm.writeMessages(c)
 
 	wm.logger.Info("New WebSocket client connected")
 
@@ -61,34 +87,65 @@ func (wm *WebSocketManagerImpl) HandleWebSocket(w http.ResponseWriter, r *http.R
 	}
 }
 
-// BroadcastMessage sends a message to all connected WebSocket clients.
+// BroadcastMessage queues a message for all connected WebSocket clients. A
+// client whose queue is full is disconnected rather than holding up the
+// others.
 func (wm *WebSocketManagerImpl) BroadcastMessage(message string) {
-	wm.mutex.RLock()
-	defer wm.mutex.RUnlock()
+	data := []byte(message)
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
 
-	for client := range wm.clients {
-		err := client.WriteMessage(websocket.TextMessage, []byte(message))
-		if err != nil {
+	for c := range wm.clients {
+		select {
+		case c.send <- data:
+		default:
+			wm.logger.Warn("Disconnecting WebSocket client that fell behind")
+			delete(wm.clients, c)
+			close(c.send)
+			c.conn.Close()
+		}
+	}
+}
+
+// BroadcastJSON sends a typed JSON message to all connected WebSocket clients.
+func (wm *WebSocketManagerImpl) BroadcastJSON(msgType string, data interface{}) {
+	payload, err := json.Marshal(Message{Type: msgType, Data: data})
+	if err != nil {
+		wm.logger.Errorf("Failed to encode %s message: %v", msgType, err)
+		return
+	}
+	wm.BroadcastMessage(string(payload))
+}
+
+// writeMessages writes the messages queued for a client until it is removed.
+// It is the only writer of the connection.
+func (wm *WebSocketManagerImpl) writeMessages(c *client) {
+	for message := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 			wm.logger.Errorf("Failed to send message to client: %v", err)
-			client.Close()
-			delete(wm.clients, client)
+			// Closing the connection ends its read loop, which removes it
+			c.conn.Close()
+			return
 		}
 	}
 }
 
 // addClient adds a new WebSocket client to the manager.
-func (wm *WebSocketManagerImpl) addClient(conn *websocket.Conn) {
+func (wm *WebSocketManagerImpl) addClient(c *client) {
 	wm.mutex.Lock()
 	defer wm.mutex.Unlock()
-	wm.clients[conn] = true
+	wm.clients[c] = true
 }
 
-// removeClient removes a WebSocket client from the manager.
-func (wm *WebSocketManagerImpl) removeClient(conn *websocket.Conn) {
+// removeClient removes a WebSocket client from the manager, stopping its
+// writer.
+func (wm *WebSocketManagerImpl) removeClient(c *client) {
 	wm.mutex.Lock()
 	defer wm.mutex.Unlock()
-	if _, exists := wm.clients[conn]; exists {
-		delete(wm.clients, conn)
+	if _, exists := wm.clients[c]; exists {
+		delete(wm.clients, c)
+		close(c.send)
 		wm.logger.Info("WebSocket client removed")
 	}
-}
\ No newline at end of file
+}
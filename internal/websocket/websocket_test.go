@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// connect starts a manager and connects n clients to it.
+func connect(t *testing.T, n int) (*WebSocketManagerImpl, []*websocket.Conn) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	wm := NewWebSocketManager(logrus.NewEntry(logger))
+	server := httptest.NewServer(http.HandlerFunc(wm.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	var conns []*websocket.Conn
+	for i := 0; i < n; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns = append(conns, conn)
+	}
+	// Wait for the server to register every client
+	waitForClients(t, wm, n)
+	return wm, conns
+}
+
+// waitForClients waits until n clients are registered with wm.
+func waitForClients(t *testing.T, wm *WebSocketManagerImpl, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		wm.mutex.Lock()
+		got := len(wm.clients)
+		wm.mutex.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients registered, want %d", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConcurrentBroadcasts(t *testing.T) {
+	// The broadcasts fit in the send buffer of a client
+	const clients, broadcasters, messages = 3, 8, 30
+	wm, conns := connect(t, clients)
+
+	var received sync.WaitGroup
+	for _, conn := range conns {
+		received.Add(1)
+		go func(conn *websocket.Conn) {
+			defer received.Done()
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			for i := 0; i < broadcasters*messages; i++ {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					t.Errorf("ReadMessage() after %d messages: %v", i, err)
+					return
+				}
+			}
+		}(conn)
+	}
+
+	var sent sync.WaitGroup
+	for i := 0; i < broadcasters; i++ {
+		sent.Add(1)
+		go func() {
+			defer sent.Done()
+			for j := 0; j < messages; j++ {
+				wm.BroadcastJSON("levels", map[string]int{"n": j})
+			}
+		}()
+	}
+	sent.Wait()
+	received.Wait()
+}
+
+func TestBroadcastDropsStalledClient(t *testing.T) {
+	wm, conns := connect(t, 2)
+	healthy := conns[1] // conns[0] never reads
+
+	// Large messages fill the socket buffers of the stalled client, then its
+	// send buffer
+	const messages = 4 * sendBuffer
+	message := strings.Repeat("x", 64<<10)
+	received := make(chan int, 1)
+	go func() {
+		n := 0
+		defer func() { received <- n }()
+		healthy.SetReadDeadline(time.Now().Add(30 * time.Second))
+		for ; n < messages; n++ {
+			if _, _, err := healthy.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < messages; i++ {
+		start := time.Now()
+		wm.BroadcastMessage(message)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("BroadcastMessage() took %v", elapsed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitForClients(t, wm, 1)
+	if n := <-received; n != messages {
+		t.Errorf("healthy client received %d of %d messages", n, messages)
+	}
+}
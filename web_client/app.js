@@ -1,15 +1,182 @@
-let mediaRecorder;
-let recordedChunks = [];
+const streamSelect = document.getElementById('stream-select');
+let currentStream = streamSelect.value;
+let hls = null;
+
+const dvrToggle = document.getElementById('dvr-toggle');
+
+// Attach the master playlist of the selected stream; hls.js switches variants based on bandwidth.
+// With timeshift on, the DVR playlists let the player scrub back through the whole window.
+function attachPlayer() {
+    const videoElement = document.getElementById('video-player');
+    const masterPlaylist = `/hls/${currentStream}/master.m3u8` + (dvrToggle.checked ? '?dvr=1' : '');
+    videoElement.poster = `/streams/${currentStream}/poster.jpg`;
+    loadPlaylist(masterPlaylist, true);
+}
+
+// Play an HLS playlist in the player
+function loadPlaylist(playlist, live) {
+    const videoElement = document.getElementById('video-player');
+    if (window.Hls && Hls.isSupported()) {
+        if (hls) {
+            hls.destroy();
+        }
+        hls = new Hls({ lowLatencyMode: live });
+        hls.loadSource(playlist);
+        hls.attachMedia(videoElement);
+    } else if (videoElement.canPlayType('application/vnd.apple.mpegurl')) {
+        videoElement.src = playlist;
+    }
+}
+
+// Offer the running streams and every profile, since a stream uses the profile named like it
+function fetchStreams() {
+    Promise.all([
+        fetch('/streams').then(response => response.json()),
+        fetch('/profiles').then(response => response.json())
+    ])
+        .then(([streams, profiles]) => {
+            const names = new Set(['main']);
+            streams.streams.forEach(stream => names.add(stream.name));
+            profiles.profiles.forEach(profile => names.add(profile.name));
+            names.delete('default');
+            streamSelect.innerHTML = '';
+            names.forEach(name => {
+                const option = document.createElement('option');
+                option.value = name;
+                option.textContent = name;
+                streamSelect.appendChild(option);
+            });
+            streamSelect.value = currentStream;
+        })
+        .catch(err => console.error(err));
+}
+
+// Swap the HLS player for the near-real-time MJPEG preview while framing shots
+const viewfinderToggle = document.getElementById('viewfinder-toggle');
+
+function updateViewfinder() {
+    const viewfinder = document.getElementById('viewfinder');
+    const videoElement = document.getElementById('video-player');
+    if (viewfinderToggle.checked) {
+        viewfinder.src = `/streams/${encodeURIComponent(currentStream)}/preview.mjpg`;
+        videoElement.pause();
+    } else {
+        viewfinder.removeAttribute('src');
+    }
+    viewfinder.classList.toggle('d-none', !viewfinderToggle.checked);
+    videoElement.classList.toggle('d-none', viewfinderToggle.checked);
+}
+
+viewfinderToggle.addEventListener('change', updateViewfinder);
+dvrToggle.addEventListener('change', attachPlayer);
+
+streamSelect.addEventListener('change', function() {
+    currentStream = streamSelect.value;
+    attachPlayer();
+    updateViewfinder();
+});
+
+attachPlayer();
+fetchStreams();
 
 // WebSocket for real-time updates
 const wsProtocol = window.location.protocol === 'https:' ? 'wss' : 'ws';
 const ws = new WebSocket(`${wsProtocol}://${window.location.host}/ws`);
 
 ws.onmessage = function(event) {
-    console.log("WebSocket message:", event.data);
-    showAlert(event.data, 'info');
+    let message;
+    try {
+        message = JSON.parse(event.data);
+    } catch (e) {
+        showAlert(event.data, 'info');
+        return;
+    }
+    handleTypedMessage(message);
 };
 
+// Dispatch typed JSON messages from the server
+function handleTypedMessage(message) {
+    switch (message.type) {
+        case 'stats':
+            if (message.data.stream === currentStream) {
+                updateStats(message.data);
+            }
+            break;
+        case 'levels':
+            if (message.data.stream === currentStream) {
+                updateLevels(message.data);
+            }
+            break;
+        case 'event':
+            handleStreamEvent(message.data);
+            break;
+        default:
+            console.log("Unhandled message type:", message.type);
+    }
+}
+
+// Show stream lifecycle events; each event names the stream it belongs to
+function handleStreamEvent(event) {
+    const failed = ['crashed', 'gave_up', 'recording_failed', 'destination_retrying', 'audio_silent', 'replay_failed', 'archive_failed', 'stalled'].includes(event.type);
+    showAlert(event.message, failed ? 'danger' : 'info');
+    if (['recording_saved', 'replay_saved', 'session_archived'].includes(event.type)) {
+        fetchVideoList();
+    }
+    if (event.type === 'started' && event.stream === currentStream) {
+        attachPlayer();
+        updateViewfinder();
+    }
+}
+
+// Update the encoder health panel
+function updateStats(stats) {
+    document.getElementById('stats-fps').textContent = stats.fps.toFixed(1);
+    document.getElementById('stats-bitrate').textContent = `${stats.bitrate_kbps.toFixed(0)} kbps`;
+    document.getElementById('stats-speed').textContent = `${stats.speed.toFixed(2)}x`;
+    document.getElementById('stats-dropped').textContent = stats.drop_frames;
+    document.getElementById('stats-duplicated').textContent = stats.dup_frames;
+    document.getElementById('stats-time').textContent = stats.out_time || '-';
+
+    const healthy = stats.speed >= 0.95;
+    const panel = document.getElementById('stats-panel');
+    panel.classList.toggle('border-success', healthy);
+    panel.classList.toggle('border-danger', !healthy);
+}
+
+// Meter range in dBFS; quieter levels show an empty bar
+const meterFloor = -60;
+
+// Update the audio meter panel with one bar per channel showing the peak level
+function updateLevels(levels) {
+    const meters = document.getElementById('audio-meters');
+    while (meters.children.length < levels.channels.length) {
+        const meter = document.createElement('div');
+        meter.className = 'progress audio-meter';
+        meter.innerHTML = '<div class="progress-bar"></div>';
+        meters.appendChild(meter);
+    }
+    while (meters.children.length > levels.channels.length) {
+        meters.removeChild(meters.lastChild);
+    }
+    levels.channels.forEach((channel, i) => {
+        const bar = meters.children[i].firstChild;
+        const percent = Math.max(0, Math.min(100, (channel.peak_db - meterFloor) / -meterFloor * 100));
+        bar.style.width = `${percent}%`;
+        bar.classList.toggle('bg-danger', channel.peak_db > -1);
+        bar.classList.toggle('bg-warning', channel.peak_db > -6 && channel.peak_db <= -1);
+        bar.classList.toggle('bg-success', channel.peak_db <= -6);
+    });
+
+    const lufs = value => value > -120 ? `${value.toFixed(1)} LUFS` : '-';
+    document.getElementById('audio-momentary').textContent = lufs(levels.momentary_lufs);
+    document.getElementById('audio-short-term').textContent = lufs(levels.short_term_lufs);
+    document.getElementById('audio-integrated').textContent = lufs(levels.integrated_lufs);
+
+    const panel = document.getElementById('audio-panel');
+    panel.classList.toggle('border-danger', levels.silent);
+    panel.classList.toggle('border-success', !levels.silent);
+}
+
 // Utility function to show alerts
 function showAlert(message, type) {
     const alertPlaceholder = document.createElement('div');
@@ -27,7 +194,7 @@ function showAlert(message, type) {
 
 // Start Stream Button
 document.getElementById('start-stream').addEventListener('click', function() {
-    fetch('/start-stream')
+    fetch(`/streams/${encodeURIComponent(currentStream)}/start`)
         .then(response => response.json())
         .then(data => {
             showAlert(data.status, 'success');
@@ -40,7 +207,7 @@ document.getElementById('start-stream').addEventListener('click', function() {
 
 // Stop Stream Button
 document.getElementById('stop-stream').addEventListener('click', function() {
-    fetch('/stop-stream')
+    fetch(`/streams/${encodeURIComponent(currentStream)}/stop`)
         .then(response => response.json())
         .then(data => {
             showAlert(data.status, 'success');
@@ -53,39 +220,62 @@ document.getElementById('stop-stream').addEventListener('click', function() {
 
 // Start Recording Button
 document.getElementById('start-recording').addEventListener('click', function() {
-    const videoElement = document.getElementById('video-player');
-    const stream = videoElement.captureStream();
-    mediaRecorder = new MediaRecorder(stream);
-
-    mediaRecorder.ondataavailable = function(event) {
-        if (event.data.size > 0) {
-            recordedChunks.push(event.data);
-        }
-    };
-
-    mediaRecorder.onstop = function() {
-        const blob = new Blob(recordedChunks, { type: 'video/mp4' });
-        const url = URL.createObjectURL(blob);
-
-        const downloadLink = document.getElementById('download-link');
-        downloadLink.href = url;
-        downloadLink.style.display = 'inline-block';
-        recordedChunks = [];
-
-        showAlert('Recording stopped. You can download the video.', 'success');
-    };
-
-    mediaRecorder.start();
-    document.getElementById('start-recording').disabled = true;
-    document.getElementById('stop-recording').disabled = false;
-    showAlert('Recording started', 'success');
+    fetch(`/start-recording?stream=${encodeURIComponent(currentStream)}`)
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text); });
+            }
+            return response.json();
+        })
+        .then(data => {
+            document.getElementById('start-recording').disabled = true;
+            document.getElementById('stop-recording').disabled = false;
+            showAlert(data.status, 'success');
+        })
+        .catch(err => {
+            console.error(err);
+            showAlert(`Error starting recording: ${err.message}`, 'danger');
+        });
 });
 
 // Stop Recording Button
 document.getElementById('stop-recording').addEventListener('click', function() {
-    mediaRecorder.stop();
-    document.getElementById('start-recording').disabled = false;
-    document.getElementById('stop-recording').disabled = true;
+    fetch(`/stop-recording?stream=${encodeURIComponent(currentStream)}`)
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text); });
+            }
+            return response.json();
+        })
+        .then(data => {
+            showAlert(`${data.status}, saving ${data.recording.id}...`, 'success');
+        })
+        .catch(err => {
+            console.error(err);
+            showAlert(`Error stopping recording: ${err.message}`, 'danger');
+        })
+        .finally(() => {
+            document.getElementById('start-recording').disabled = false;
+            document.getElementById('stop-recording').disabled = true;
+        });
+});
+
+// Replay Button: save the last 30 seconds of the live stream as a clip
+document.getElementById('save-replay').addEventListener('click', function() {
+    fetch(`/stream/replay?seconds=30&stream=${encodeURIComponent(currentStream)}`, { method: 'POST' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text); });
+            }
+            return response.json();
+        })
+        .then(data => {
+            showAlert(`${data.status}: ${data.replay.id}...`, 'success');
+        })
+        .catch(err => {
+            console.error(err);
+            showAlert(`Error saving replay: ${err.message}`, 'danger');
+        });
 });
 
 // Fetch and display video list
@@ -105,11 +295,16 @@ function fetchVideoList() {
             data.videos.forEach(video => {
                 const li = document.createElement('li');
                 li.className = 'list-group-item';
-                const a = document.createElement('a');
-                a.href = `/videos/${video}`;
-                a.textContent = video;
-                a.target = '_blank';
-                li.appendChild(a);
+                if (video.name) {
+                    const a = document.createElement('a');
+                    a.href = `/videos/${video.name}`;
+                    a.textContent = video.name;
+                    a.target = '_blank';
+                    li.appendChild(a);
+                }
+                if (video.session) {
+                    li.appendChild(describeSession(video.session));
+                }
                 videoList.appendChild(li);
             });
         })
@@ -119,6 +314,25 @@ function fetchVideoList() {
         });
 }
 
+// Describe an archived session, with a link playing its VOD in the player
+function describeSession(archive) {
+    const div = document.createElement('div');
+    div.className = 'small text-muted';
+    const started = new Date(archive.session.started_at).toLocaleString();
+    const minutes = Math.round(archive.duration / 60);
+    div.textContent = `Session ${archive.session.stream}, ${started}, ${minutes} min `;
+    const vod = document.createElement('a');
+    vod.href = '#';
+    vod.textContent = 'Play VOD';
+    vod.addEventListener('click', e => {
+        e.preventDefault();
+        document.getElementById('video-player').poster = '';
+        loadPlaylist(`/archive/${archive.playlist}`, false);
+    });
+    div.appendChild(vod);
+    return div;
+}
+
 // Initial fetch
 fetchVideoList();
 